@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"path/filepath"
+
+	"tg-archive-bot/internal/config"
+	"tg-archive-bot/internal/search"
+
+	"go.uber.org/zap"
+)
+
+// runEncryptExisting шифрует записи и вложения, сохраненные до включения шифрования,
+// с -dry-run только считает их:
+//
+//	tg-archive-bot encrypt-existing [-dry-run] [-data data | -bot name]
+func runEncryptExisting(cfg *config.Config, args []string) {
+	flags := flag.NewFlagSet("encrypt-existing", flag.ExitOnError)
+	dataDir := flags.String("data", cfg.DataDir, "каталог архива")
+	botName := flags.String("bot", "", "имя бота из конфигурации, архив которого использовать вместо -data")
+	dryRun := flags.Bool("dry-run", false, "только посчитать незашифрованные записи")
+	_ = flags.Parse(args)
+	if *botName != "" {
+		b, ok := cfg.FindBot(*botName)
+		if !ok {
+			zap.L().Fatal("unknown bot", zap.String("bot", *botName))
+		}
+		*dataDir = cfg.BotDataDir(b)
+	}
+
+	store, err := openArchive(cfg, *dataDir)
+	if err != nil {
+		zap.L().Fatal("open archive", zap.Error(err))
+	}
+	report, err := store.EncryptExisting(*dryRun)
+	if err == nil && !*dryRun {
+		// индекс пересохраняет снимок при открытии, уже в зашифрованном виде
+		index, openErr := search.Open(filepath.Join(*dataDir, "index"), store)
+		if openErr == nil {
+			openErr = index.Close()
+		}
+		err = openErr
+	}
+	// Fatal не выполняет defer, поэтому хранилище закрывается явно
+	err = errors.Join(err, store.Close())
+
+	fields := []zap.Field{zap.Bool("dry_run", *dryRun)}
+	if report != nil {
+		fields = append(fields,
+			zap.Int("chats", report.Chats),
+			zap.Int("messages", report.Messages),
+			zap.Int("users", report.Users),
+			zap.Int("blobs", report.Blobs),
+			zap.Int("renamed_blobs", report.Renamed),
+			zap.Int("orphan_blobs", report.Orphans),
+		)
+	}
+	if err != nil {
+		zap.L().Fatal("encrypt existing records", append(fields, zap.Error(err))...)
+	}
+	if *dryRun {
+		zap.L().Info("plaintext records found", fields...)
+		return
+	}
+	zap.L().Info("existing records encrypted", fields...)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"path/filepath"
+
+	"tg-archive-bot/internal/access"
+	"tg-archive-bot/internal/archiver"
+	"tg-archive-bot/internal/config"
+	"tg-archive-bot/internal/fsck"
+	"tg-archive-bot/internal/log"
+	"tg-archive-bot/internal/search"
+
+	"github.com/mymmrac/telego"
+	"go.uber.org/zap"
+)
+
+// runFsck сверяет сообщения с файлами вложений и поисковым индексом и для каждой
+// проблемы предлагает исправление. С -repair применяет безопасные исправления,
+// вложения скачиваются заново, только если задан -bot. Пропуски в номерах сообщений
+// бывают и без сбоев, поэтому сообщаются только с -gaps:
+//
+//	tg-archive-bot fsck [-repair] [-gaps] [-data data | -bot name]
+func runFsck(cfg *config.Config, args []string) {
+	flags := flag.NewFlagSet("fsck", flag.ExitOnError)
+	dataDir := flags.String("data", cfg.DataDir, "каталог архива")
+	botName := flags.String("bot", "", "имя бота из конфигурации, архив которого использовать вместо -data")
+	repair := flags.Bool("repair", false, "применить безопасные исправления")
+	gaps := flags.Bool("gaps", false, "сообщить о пропусках в номерах сообщений")
+	_ = flags.Parse(args)
+	var token string
+	if *botName != "" {
+		b, ok := cfg.FindBot(*botName)
+		if !ok {
+			zap.L().Fatal("unknown bot", zap.String("bot", *botName))
+		}
+		*dataDir = cfg.BotDataDir(b)
+		token = b.Token
+	}
+
+	store, err := openArchive(cfg, *dataDir)
+	if err != nil {
+		zap.L().Fatal("open archive", zap.Error(err))
+	}
+	defer store.Close()
+
+	index, err := search.Open(filepath.Join(*dataDir, "index"), store)
+	if err != nil {
+		zap.L().Fatal("open search index", zap.Error(err))
+	}
+	defer func() {
+		if err := index.Close(); err != nil {
+			zap.L().Error("close search index", zap.Error(err))
+		}
+	}()
+	archiving, err := access.NewArchiving(store)
+	if err != nil {
+		zap.L().Fatal("load archiving settings", zap.Error(err))
+	}
+
+	report, err := fsck.Check(store, index, archiving, *gaps)
+	if err != nil {
+		zap.L().Fatal("check archive", zap.Error(err))
+	}
+	var unsafe int
+	for _, p := range report.Problems {
+		if !p.Safe {
+			unsafe++
+		}
+		zap.L().Warn("archive problem",
+			zap.String("kind", p.Kind),
+			zap.Int64("chat_id", p.ChatID),
+			zap.Int("message_id", p.MessageID),
+			zap.String("blob", p.Blob),
+			zap.String("path", p.Path),
+			zap.String("detail", p.Detail),
+			zap.String("fix", p.Fix),
+			zap.Bool("safe", p.Safe),
+		)
+	}
+	zap.L().Info("archive checked",
+		zap.Int("chats", report.Chats),
+		zap.Int("messages", report.Messages),
+		zap.Int("blobs", report.Blobs),
+		zap.Int("problems", len(report.Problems)),
+		zap.Int("manual", unsafe),
+	)
+	if !*repair || len(report.Problems) == unsafe {
+		return
+	}
+
+	var downloader *archiver.Downloader
+	if token != "" {
+		bot, err := telego.NewBot(token, telego.WithLogger(log.NewTelegoLogger(zap.L(), token)))
+		if err != nil {
+			zap.L().Fatal("create bot", zap.Error(err))
+		}
+		// Enqueue не ждет места в очереди, поэтому она вмещает все вложения сразу
+		downloader = archiver.NewDownloader(*botName, bot, store, 2, max(report.Redownloads(), 1))
+	} else {
+		zap.L().Warn("no bot, attachments are not downloaded again")
+	}
+
+	done, err := fsck.Repair(log.WithLogger(context.Background(), zap.L()), store, index, downloader, report)
+	if downloader != nil {
+		// дожидаемся скачивания поставленных в очередь вложений
+		downloader.Stop()
+	}
+	if err != nil {
+		zap.L().Fatal("repair archive", zap.Error(err))
+	}
+	zap.L().Info("archive repaired",
+		zap.Int("removed", done.Removed),
+		zap.Int("redownloads", done.Redownloads),
+		zap.Int("reindexed", done.Reindexed),
+		zap.Int("skipped", done.Skipped),
+	)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"path/filepath"
+
+	"tg-archive-bot/internal/config"
+	"tg-archive-bot/internal/importer"
+	"tg-archive-bot/internal/search"
+
+	"go.uber.org/zap"
+)
+
+// runImport импорт экспорта Telegram Desktop:
+//
+//	tg-archive-bot import [-data data | -bot name] [-chat -100123] <каталог экспорта>
+func runImport(cfg *config.Config, args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	dataDir := flags.String("data", cfg.DataDir, "каталог архива")
+	botName := flags.String("bot", "", "имя бота из конфигурации, архив которого использовать вместо -data")
+	chatID := flags.Int64("chat", 0, "идентификатор чата в Bot API (обязателен для экспорта всего аккаунта)")
+	_ = flags.Parse(args)
+	if *botName != "" {
+		b, ok := cfg.FindBot(*botName)
+		if !ok {
+			zap.L().Fatal("unknown bot", zap.String("bot", *botName))
+		}
+		*dataDir = cfg.BotDataDir(b)
+	}
+
+	if flags.NArg() != 1 {
+		zap.L().Fatal("export directory is required")
+	}
+
+	store, err := openArchive(cfg, *dataDir)
+	if err != nil {
+		zap.L().Fatal("open archive", zap.Error(err))
+	}
+
+	index, err := search.Open(filepath.Join(*dataDir, "index"), store)
+	if err != nil {
+		store.Close()
+		zap.L().Fatal("open search index", zap.Error(err))
+	}
+
+	_, err = importer.New(store, index).Import(flags.Arg(0), *chatID)
+	if closeErr := index.Close(); closeErr != nil {
+		zap.L().Error("close search index", zap.Error(closeErr))
+	}
+	// Fatal не выполняет defer, поэтому хранилище закрывается явно
+	if closeErr := store.Close(); closeErr != nil {
+		zap.L().Error("close archive", zap.Error(closeErr))
+	}
+	if err != nil {
+		zap.L().Fatal("import failed", zap.Error(err))
+	}
+}
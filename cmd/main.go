@@ -1,43 +1,161 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"errors"
+	"flag"
 	sys_log "log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"tg-archive-bot/internal/admin"
+	"tg-archive-bot/internal/archive"
+	"tg-archive-bot/internal/config"
+	"tg-archive-bot/internal/health"
 	"tg-archive-bot/internal/log"
+	"tg-archive-bot/internal/metrics"
+	"tg-archive-bot/internal/runner"
 
-	"github.com/mymmrac/telego"
 	"go.uber.org/zap"
 )
 
-const TOKEN = "7473509536:AAG_30QvTClBFudeODn4JLiVsZYGatGCoTM"
-
 func main() {
-	_, err := log.RootLogger("development", "debug")
+	cfg, err := config.Load(config.Path())
 	if err != nil {
 		sys_log.Fatal(err)
 	}
 
-	bot, err := telego.NewBot(TOKEN, telego.WithDefaultDebugLogger())
+	_, err = log.RootLogger(cfg.Log.Env, cfg.Log.Level, cfg.Log.Sinks...)
 	if err != nil {
-		zap.L().Fatal("bot connect error", zap.Error(err))
+		sys_log.Fatal(err)
 	}
+	defer func() { _ = zap.L().Sync() }()
+	log.SetPayloadConfig(cfg.Log.Payload)
 
-	botUser, err := bot.GetMe()
-	if err != nil {
-		fmt.Println("Error:", err)
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "import":
+			runImport(cfg, os.Args[2:])
+			return
+		case "reindex":
+			runReindex(cfg, os.Args[2:])
+			return
+		case "purge":
+			runPurge(cfg, os.Args[2:])
+			return
+		case "rotate-key":
+			runRotateKey(cfg, os.Args[2:])
+			return
+		case "encrypt-existing":
+			runEncryptExisting(cfg, os.Args[2:])
+			return
+		case "verify":
+			runVerify(cfg, os.Args[2:])
+			return
+		case "fsck":
+			runFsck(cfg, os.Args[2:])
+			return
+		}
+	}
+	if err = run(cfg, os.Args[1:]); err != nil {
+		zap.L().Error("bot stopped", zap.Error(err))
+		_ = zap.L().Sync()
+		os.Exit(1)
+	}
+}
+
+// run запускает ботов из конфигурации и работает до сигнала остановки
+//
+//	tg-archive-bot [-data data]
+func run(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	dataDir := flags.String("data", cfg.DataDir, "каталог архива")
+	_ = flags.Parse(args)
+
+	reloader := config.NewReloader(config.Path(), cfg, func(next *config.Config) { next.DataDir = *dataDir })
+	cfg = reloader.Current()
+
+	probes := &runner.Probes{Liveness: health.NewProbe(), Readiness: health.NewProbe()}
+	manager := runner.NewManager(reloader, probes)
+	if err := manager.Start(); err != nil {
+		zap.L().Fatal("start bots", zap.Error(err))
 	}
+	defer manager.Stop()
 
-	// Print Bot information
-	zap.L().Info("bot connected", zap.Any("user", botUser))
+	if cfg.Admin.Listen != "" {
+		adminServer := admin.New(cfg.Admin, reloader)
+		adminServer.Handle("GET /metrics", metrics.Handler())
+		adminServer.HandlePublic("/healthz", probes.Liveness)
+		adminServer.HandlePublic("/readyz", probes.Readiness)
+		if err := adminServer.Start(); err != nil {
+			zap.L().Fatal("start admin server", zap.Error(err))
+		}
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := adminServer.Shutdown(ctx); err != nil {
+				zap.L().Error("stop admin server", zap.Error(err))
+			}
+		}()
+	}
+
+	if cfg.Health.Listen != "" {
+		healthServer := health.NewServer(cfg.Health.Listen, probes.Liveness, probes.Readiness)
+		if err := healthServer.Start(); err != nil {
+			zap.L().Fatal("start health server", zap.Error(err))
+		}
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := healthServer.Shutdown(ctx); err != nil {
+				zap.L().Error("stop health server", zap.Error(err))
+			}
+		}()
+	}
 
-	updates, _ := bot.UpdatesViaLongPolling(nil)
+	reloader.OnReload(func(next *config.Config) error {
+		err := log.SetLogLevel(next.Log.Level)
+		log.SetPayloadConfig(next.Log.Payload)
+		return errors.Join(err, manager.Apply(next))
+	})
 
-	// Stop reviving updates from update channel
-	defer bot.StopLongPolling()
+	// SIGHUP перечитывает конфигурацию, обработка обновлений не прерывается
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+	for {
+		select {
+		case err := <-manager.Failed():
+			return err
+		case sig := <-signals:
+			if sig != syscall.SIGHUP {
+				zap.L().Info("stopping")
+				return nil
+			}
+			if err := reloader.Reload(); errors.Is(err, config.ErrApply) {
+				zap.L().Error("config reloaded with errors", zap.Error(err))
+			} else if err != nil {
+				zap.L().Error("config reload failed, keeping current config", zap.Error(err))
+			}
+		}
+	}
+}
 
-	// Loop through all updates when they came
-	for update := range updates {
-		zap.L().Info("update", zap.Any("update", update))
+// openArchive открывает архив и включает шифрование из конфигурации
+func openArchive(cfg *config.Config, dir string) (*archive.Store, error) {
+	enc, err := cfg.Encryption.Archive()
+	if err != nil {
+		return nil, err
+	}
+	store, err := archive.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err = store.SetEncryption(enc); err != nil {
+		_ = store.Close()
+		return nil, err
 	}
+	return store, nil
 }
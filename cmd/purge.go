@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"path/filepath"
+
+	"tg-archive-bot/internal/config"
+	"tg-archive-bot/internal/purge"
+	"tg-archive-bot/internal/search"
+
+	"go.uber.org/zap"
+)
+
+// runPurge удаляет сообщения пользователя из архива, с -dry-run только показывает, что будет удалено:
+//
+//	tg-archive-bot purge -user 123456 [-chat -1001234567890] [-dry-run] [-data data | -bot name]
+func runPurge(cfg *config.Config, args []string) {
+	flags := flag.NewFlagSet("purge", flag.ExitOnError)
+	dataDir := flags.String("data", cfg.DataDir, "каталог архива")
+	botName := flags.String("bot", "", "имя бота из конфигурации, архив которого использовать вместо -data")
+	userID := flags.Int64("user", 0, "идентификатор пользователя")
+	chatID := flags.Int64("chat", 0, "удалить сообщения только в этом чате")
+	dryRun := flags.Bool("dry-run", false, "только показать, что будет удалено")
+	_ = flags.Parse(args)
+	if *userID == 0 {
+		zap.L().Fatal("-user is required")
+	}
+	if *botName != "" {
+		b, ok := cfg.FindBot(*botName)
+		if !ok {
+			zap.L().Fatal("unknown bot", zap.String("bot", *botName))
+		}
+		*dataDir = cfg.BotDataDir(b)
+	}
+
+	store, err := openArchive(cfg, *dataDir)
+	if err != nil {
+		zap.L().Fatal("open archive", zap.Error(err))
+	}
+
+	plan, err := purge.Prepare(store, *userID, *chatID, cfg.Retention.Held)
+	if err != nil {
+		store.Close()
+		zap.L().Fatal("prepare purge", zap.Error(err))
+	}
+	zap.L().Info("purge plan",
+		zap.Int("messages", len(plan.Messages)),
+		zap.Int("chats", plan.Chats),
+		zap.Int("blobs", len(plan.Blobs)),
+		zap.Bool("profile", plan.Profile),
+		zap.Bool("private_chat", plan.PrivateChat),
+		zap.Int("held_chats", plan.HeldChats),
+		zap.Bool("dry_run", *dryRun),
+	)
+	if *dryRun || plan.Empty() {
+		store.Close()
+		return
+	}
+
+	index, err := search.Open(filepath.Join(*dataDir, "index"), store)
+	if err != nil {
+		store.Close()
+		zap.L().Fatal("open search index", zap.Error(err))
+	}
+
+	audit, err := purge.Apply(store, index, plan, purge.ActionCLI, 0)
+	deleted := []zap.Field{
+		zap.Int("messages", audit.Messages),
+		zap.Int("planned_messages", len(plan.Messages)),
+		zap.Int("blobs", audit.Blobs),
+		zap.Int("planned_blobs", len(plan.Blobs)),
+		zap.Bool("profile", audit.Profile),
+	}
+	if closeErr := index.Close(); closeErr != nil {
+		err = errors.Join(err, closeErr)
+	}
+	// Fatal не выполняет defer, поэтому хранилище закрывается явно
+	if closeErr := store.Close(); closeErr != nil {
+		err = errors.Join(err, closeErr)
+	}
+	if err != nil {
+		zap.L().Fatal("purge incomplete, run it again to delete the rest", append(deleted, zap.Error(err))...)
+	}
+	zap.L().Info("user data purged", deleted...)
+}
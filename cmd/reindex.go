@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+
+	"tg-archive-bot/internal/config"
+	"tg-archive-bot/internal/search"
+
+	"go.uber.org/zap"
+)
+
+// runReindex перестраивает поисковый индекс с нуля:
+//
+//	tg-archive-bot reindex [-data data | -bot name]
+func runReindex(cfg *config.Config, args []string) {
+	flags := flag.NewFlagSet("reindex", flag.ExitOnError)
+	dataDir := flags.String("data", cfg.DataDir, "каталог архива")
+	botName := flags.String("bot", "", "имя бота из конфигурации, архив которого использовать вместо -data")
+	_ = flags.Parse(args)
+	if *botName != "" {
+		b, ok := cfg.FindBot(*botName)
+		if !ok {
+			zap.L().Fatal("unknown bot", zap.String("bot", *botName))
+		}
+		*dataDir = cfg.BotDataDir(b)
+	}
+
+	store, err := openArchive(cfg, *dataDir)
+	if err != nil {
+		zap.L().Fatal("open archive", zap.Error(err))
+	}
+	defer store.Close()
+
+	// без снимка search.Open сам строит индекс по хранилищу
+	indexDir := filepath.Join(*dataDir, "index")
+	if err = os.RemoveAll(indexDir); err != nil {
+		zap.L().Fatal("remove search index", zap.Error(err))
+	}
+	index, err := search.Open(indexDir, store)
+	if err != nil {
+		zap.L().Fatal("rebuild search index", zap.Error(err))
+	}
+	if err = index.Close(); err != nil {
+		zap.L().Fatal("close search index", zap.Error(err))
+	}
+}
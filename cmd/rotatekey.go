@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+
+	"tg-archive-bot/internal/archive"
+	"tg-archive-bot/internal/config"
+
+	"go.uber.org/zap"
+)
+
+// runRotateKey перешифровывает ключи данных архива новым мастер-ключом. Текущий ключ
+// берется из конфигурации, сообщения и вложения не переписываются:
+//
+//	tg-archive-bot rotate-key (-new-key-file path | -new-key-env NAME) [-data data | -bot name]
+func runRotateKey(cfg *config.Config, args []string) {
+	flags := flag.NewFlagSet("rotate-key", flag.ExitOnError)
+	dataDir := flags.String("data", cfg.DataDir, "каталог архива")
+	botName := flags.String("bot", "", "имя бота из конфигурации, архив которого использовать вместо -data")
+	keyFile := flags.String("new-key-file", "", "файл с новым мастер-ключом")
+	keyEnv := flags.String("new-key-env", "", "переменная окружения с новым мастер-ключом")
+	_ = flags.Parse(args)
+	if (*keyFile == "") == (*keyEnv == "") {
+		zap.L().Fatal("exactly one of -new-key-file and -new-key-env is required")
+	}
+	if *botName != "" {
+		b, ok := cfg.FindBot(*botName)
+		if !ok {
+			zap.L().Fatal("unknown bot", zap.String("bot", *botName))
+		}
+		*dataDir = cfg.BotDataDir(b)
+	}
+
+	next, err := archive.LoadMasterKey(*keyFile, *keyEnv)
+	if err != nil {
+		zap.L().Fatal("load new master key", zap.Error(err))
+	}
+	store, err := openArchive(cfg, *dataDir)
+	if err != nil {
+		zap.L().Fatal("open archive", zap.Error(err))
+	}
+	defer store.Close()
+
+	keys, err := store.RotateMasterKey(next)
+	if err != nil {
+		zap.L().Fatal("rotate master key", zap.Error(err))
+	}
+	zap.L().Info("master key rotated, update encryption settings in config",
+		zap.Int("data_keys", keys), zap.String("fingerprint", next.Fingerprint()))
+}
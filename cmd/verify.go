@@ -0,0 +1,82 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"flag"
+	"time"
+
+	"tg-archive-bot/internal/config"
+	"tg-archive-bot/internal/integrity"
+
+	"go.uber.org/zap"
+)
+
+// runVerify проходит по цепочкам изменений чатов и сообщает об измененных, вставленных
+// и удаленных записях. При нарушениях завершается с кодом 1:
+//
+//	tg-archive-bot verify [-chat -1001234567890] [-public-key key.pub] [-max-unsigned 2h] [-data data | -bot name]
+func runVerify(cfg *config.Config, args []string) {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	dataDir := flags.String("data", cfg.DataDir, "каталог архива")
+	botName := flags.String("bot", "", "имя бота из конфигурации, архив которого использовать вместо -data")
+	chatID := flags.Int64("chat", 0, "проверить только этот чат")
+	keyPath := flags.String("public-key", cfg.Integrity.KeyFile, "открытый ключ ed25519 в PEM для проверки подписей")
+	// бот подписывает изменения раз в интервал, после аварийного завершения последний
+	// интервал остается неподписанным
+	interval, err := time.ParseDuration(cfg.Integrity.CheckpointInterval)
+	if err != nil {
+		interval = integrity.DefaultInterval
+	}
+	maxUnsigned := flags.Duration("max-unsigned", 2*interval, "сколько изменения чата могут идти после последней подписи, 0 не проверять")
+	_ = flags.Parse(args)
+	if *botName != "" {
+		b, ok := cfg.FindBot(*botName)
+		if !ok {
+			zap.L().Fatal("unknown bot", zap.String("bot", *botName))
+		}
+		*dataDir = cfg.BotDataDir(b)
+	}
+
+	var key ed25519.PublicKey
+	if *keyPath != "" {
+		if key, err = integrity.LoadPublicKey(*keyPath); err != nil {
+			zap.L().Fatal("load public key", zap.Error(err))
+		}
+	} else {
+		zap.L().Warn("no public key, checkpoint signatures are not verified")
+	}
+
+	store, err := openArchive(cfg, *dataDir)
+	if err != nil {
+		zap.L().Fatal("open archive", zap.Error(err))
+	}
+	defer store.Close()
+
+	report, err := integrity.Verify(store, key, *chatID, *maxUnsigned)
+	if err != nil {
+		zap.L().Fatal("verify archive", zap.Error(err))
+	}
+	for _, p := range report.Problems {
+		zap.L().Warn("integrity violation",
+			zap.Int64("chat_id", p.ChatID),
+			zap.String("kind", p.Kind),
+			zap.Int64("seq", p.Seq),
+			zap.Int("message_id", p.MessageID),
+			zap.String("detail", p.Detail),
+		)
+	}
+	fields := []zap.Field{
+		zap.Int("chats", report.Chats),
+		zap.Int64("entries", report.Entries),
+		zap.Int("records", report.Records),
+		zap.Int("checkpoints", report.Checkpoints),
+		zap.Int64("unsigned_entries", report.Unsigned),
+		zap.Int("deleted_chats", report.Deleted),
+		zap.Int("problems", len(report.Problems)),
+	}
+	if len(report.Problems) > 0 {
+		store.Close()
+		zap.L().Fatal("archive verification failed", fields...)
+	}
+	zap.L().Info("archive verified", fields...)
+}
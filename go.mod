@@ -5,7 +5,9 @@ go 1.22.3
 toolchain go1.22.4
 
 require (
+	github.com/kljensen/snowball v0.10.0
 	github.com/mymmrac/telego v0.30.2
+	github.com/valyala/fasthttp v1.54.0
 	go.uber.org/zap v1.27.0
 )
 
@@ -22,7 +24,6 @@ require (
 	github.com/savsgio/gotils v0.0.0-20240303185622-093b76447511 // indirect
 	github.com/twitchyliquid64/golang-asm v0.15.1 // indirect
 	github.com/valyala/bytebufferpool v1.0.0 // indirect
-	github.com/valyala/fasthttp v1.54.0 // indirect
 	github.com/valyala/fastjson v1.6.4 // indirect
 	go.uber.org/multierr v1.10.0 // indirect
 	golang.org/x/arch v0.6.0 // indirect
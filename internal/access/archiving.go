@@ -0,0 +1,111 @@
+package access
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"tg-archive-bot/internal/archive"
+)
+
+// archivingState имя состояния с настройками архивирования чатов
+const archivingState = "archiving"
+
+// ChatArchiving настройки чата, которые выбирают его администраторы. Чаты без записи
+// архивируются полностью
+type ChatArchiving struct {
+	Disabled    bool      `json:"disabled,omitempty"`
+	PausedUntil time.Time `json:"paused_until,omitempty"`
+	// TextOnly сохранять сообщения без скачивания вложений
+	TextOnly bool `json:"text_only,omitempty"`
+	// RetentionDays сколько дней хранить сообщения чата, 0 — бессрочно
+	RetentionDays int `json:"retention_days,omitempty"`
+	// Language язык ответов бота в чате, пустой — язык пользователя
+	Language  string    `json:"language,omitempty"`
+	ChangedBy int64     `json:"changed_by"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// Active архивируется ли чат в момент now
+func (c ChatArchiving) Active(now time.Time) bool {
+	return !c.Disabled && !now.Before(c.PausedUntil)
+}
+
+// Archiving настройки архивирования чатов, которые меняют их администраторы командой /archive
+type Archiving struct {
+	store *archive.Store
+
+	mu    sync.RWMutex
+	chats map[int64]ChatArchiving
+}
+
+// NewArchiving загружает сохраненные настройки чатов
+func NewArchiving(store *archive.Store) (*Archiving, error) {
+	a := &Archiving{store: store, chats: make(map[int64]ChatArchiving)}
+	err := store.GetState(archivingState, &a.chats)
+	if err != nil && !errors.Is(err, archive.ErrNotFound) {
+		return nil, fmt.Errorf("read archiving state: %w", err)
+	}
+	if a.chats == nil {
+		a.chats = make(map[int64]ChatArchiving)
+	}
+	return a, nil
+}
+
+// Active архивируется ли чат сейчас
+func (a *Archiving) Active(chatID int64) bool {
+	return a.Get(chatID).Active(time.Now())
+}
+
+// Get настройки чата
+func (a *Archiving) Get(chatID int64) ChatArchiving {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.chats[chatID]
+}
+
+// Update изменяет и сохраняет настройки чата
+func (a *Archiving) Update(chatID int64, fn func(c *ChatArchiving)) (ChatArchiving, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	prev, had := a.chats[chatID]
+	c := prev
+	fn(&c)
+	a.chats[chatID] = c
+	if err := a.store.PutState(archivingState, a.chats); err != nil {
+		if had {
+			a.chats[chatID] = prev
+		} else {
+			delete(a.chats, chatID)
+		}
+		return prev, err
+	}
+	return c, nil
+}
+
+// Move переносит настройки чата на новый идентификатор, когда группа становится
+// супергруппой. Настройки, уже заданные для нового идентификатора, остаются
+func (a *Archiving) Move(from, to int64) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	c, ok := a.chats[from]
+	if !ok {
+		return nil
+	}
+	prev, had := a.chats[to]
+	if !had {
+		a.chats[to] = c
+	}
+	delete(a.chats, from)
+	if err := a.store.PutState(archivingState, a.chats); err != nil {
+		a.chats[from] = c
+		if had {
+			a.chats[to] = prev
+		} else {
+			delete(a.chats, to)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,3 @@
+package access
+
+// список разрешенных чатов: одобрение владельцем и выход из чужих чатов
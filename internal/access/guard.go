@@ -0,0 +1,455 @@
+package access
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"tg-archive-bot/internal/archive"
+	"tg-archive-bot/internal/config"
+	"tg-archive-bot/internal/i18n"
+	"tg-archive-bot/internal/log"
+
+	"github.com/mymmrac/telego"
+	tu "github.com/mymmrac/telego/telegoutil"
+	"go.uber.org/zap"
+)
+
+// DefaultApprovalTimeout сколько ждать решения владельца
+const DefaultApprovalTimeout = 24 * time.Hour
+
+// stateName имя состояния в хранилище бота
+const stateName = "access"
+
+// callbackPrefix кнопки одобрения: access:approve:<chat_id> и access:deny:<chat_id>
+const callbackPrefix = "access:"
+
+// pending чат, ожидающий решения владельца
+type pending struct {
+	Title    string         `json:"title"`
+	Type     string         `json:"type,omitempty"`
+	AddedBy  int64          `json:"added_by"`
+	Deadline time.Time      `json:"deadline"`
+	Messages []ownerMessage `json:"messages,omitempty"`
+}
+
+// ownerMessage уведомление владельцу, которое обновляется после решения
+type ownerMessage struct {
+	ChatID    int64 `json:"chat_id"`
+	MessageID int   `json:"message_id"`
+}
+
+// state одобренные и ожидающие решения чаты
+type state struct {
+	Approved map[int64]bool     `json:"approved"`
+	Pending  map[int64]*pending `json:"pending"`
+	// Migrated новые идентификаторы ожидающих групп, ставших супергруппами:
+	// кнопки в уведомлениях владельцам содержат прежний
+	Migrated map[int64]int64 `json:"migrated,omitempty"`
+}
+
+// Guard решает, какие чаты бот архивирует. Когда бота добавляют в чат, которого нет
+// в списке, владельцы получают уведомление, а бот выходит из чата сразу или после
+// отказа владельца либо истечения времени ожидания
+type Guard struct {
+	api       *telego.Bot
+	store     *archive.Store
+	archiving *Archiving
+	log       *zap.Logger
+	cfg       atomic.Pointer[config.Access]
+
+	mu     sync.Mutex
+	state  state
+	timers map[int64]*time.Timer
+
+	onApprove func(ctx context.Context, chat telego.Chat)
+}
+
+// New загружает сохраненное состояние и возобновляет ожидание решений. Уведомления
+// владельцам пишутся на языке, выбранном в их личном чате с ботом
+func New(api *telego.Bot, store *archive.Store, archiving *Archiving, cfg config.Access, logger *zap.Logger) (*Guard, error) {
+	g := &Guard{
+		api:       api,
+		store:     store,
+		archiving: archiving,
+		log:       logger,
+		timers:    make(map[int64]*time.Timer),
+		state:     state{Approved: make(map[int64]bool), Pending: make(map[int64]*pending), Migrated: make(map[int64]int64)},
+	}
+	g.cfg.Store(&cfg)
+
+	err := store.GetState(stateName, &g.state)
+	if err != nil && !errors.Is(err, archive.ErrNotFound) {
+		return nil, fmt.Errorf("read access state: %w", err)
+	}
+	if g.state.Approved == nil {
+		g.state.Approved = make(map[int64]bool)
+	}
+	if g.state.Pending == nil {
+		g.state.Pending = make(map[int64]*pending)
+	}
+	if g.state.Migrated == nil {
+		g.state.Migrated = make(map[int64]int64)
+	}
+	for chatID, p := range g.state.Pending {
+		g.schedule(chatID, time.Until(p.Deadline))
+	}
+	if !cfg.Enabled() {
+		logger.Warn("access control is disabled, every chat is archived")
+	}
+	return g, nil
+}
+
+// SetConfig применяет новые списки разрешенных чатов и владельцев
+func (g *Guard) SetConfig(cfg config.Access) {
+	g.cfg.Store(&cfg)
+}
+
+// OnApprove задает обработчик одобрения чата владельцем. Вызывается до начала обработки обновлений
+func (g *Guard) OnApprove(fn func(ctx context.Context, chat telego.Chat)) {
+	g.onApprove = fn
+}
+
+// Allowed можно ли сохранять обновления чата
+func (g *Guard) Allowed(chatID int64) bool {
+	cfg := g.cfg.Load()
+	if !cfg.Enabled() || slices.Contains(cfg.Chats, chatID) {
+		return true
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.state.Approved[chatID]
+}
+
+// Stop останавливает таймеры ожидания
+func (g *Guard) Stop() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for chatID, t := range g.timers {
+		t.Stop()
+		delete(g.timers, chatID)
+	}
+}
+
+// HandleUpdate обрабатывает добавление бота в чат, решения владельцев и переход
+// группы в супергруппу. Возвращает true, если обновление обработано и дальше
+// передавать его не нужно
+func (g *Guard) HandleUpdate(ctx context.Context, update telego.Update) bool {
+	switch msg := update.Message; {
+	case update.MyChatMember != nil:
+		g.chatMember(ctx, update.MyChatMember)
+		return false
+	// группа получает сообщение со ссылкой на супергруппу, супергруппа — на группу;
+	// какое придет первым, не определено
+	case msg != nil && msg.MigrateToChatID != 0:
+		g.migrate(ctx, msg.Chat.ID, msg.MigrateToChatID)
+		return false
+	case msg != nil && msg.MigrateFromChatID != 0:
+		g.migrate(ctx, msg.MigrateFromChatID, msg.Chat.ID)
+		return false
+	case update.CallbackQuery != nil && strings.HasPrefix(update.CallbackQuery.Data, callbackPrefix):
+		g.decision(ctx, update.CallbackQuery)
+		return true
+	}
+	return false
+}
+
+func (g *Guard) chatMember(ctx context.Context, upd *telego.ChatMemberUpdated) {
+	chat := upd.Chat
+	if chat.Type == telego.ChatTypePrivate {
+		return
+	}
+	joined := !IsPresent(upd.OldChatMember) && IsPresent(upd.NewChatMember)
+	if !IsPresent(upd.NewChatMember) {
+		g.forget(chat.ID)
+		return
+	}
+	if !joined {
+		return
+	}
+
+	cfg := g.cfg.Load()
+	logger := log.FromContext(ctx)
+	if !cfg.Enabled() || g.Allowed(chat.ID) {
+		return
+	}
+	if slices.Contains(cfg.Owners, upd.From.ID) {
+		logger.Info("chat approved: bot added by owner")
+		g.approve(chat.ID)
+		return
+	}
+
+	if !cfg.Approval {
+		logger.Warn("leaving unauthorized chat", zap.Int64("added_by", upd.From.ID))
+		g.notify(ctx, cfg, func(lang string) (string, *telego.InlineKeyboardMarkup) {
+			return i18n.T(lang, "access.left", chatTitle(chat), userName(&upd.From)), nil
+		})
+		g.leave(ctx, chat.ID)
+		return
+	}
+
+	timeout := DefaultApprovalTimeout
+	if cfg.ApprovalTimeout != "" {
+		timeout, _ = time.ParseDuration(cfg.ApprovalTimeout)
+	}
+	logger.Info("waiting for chat approval", zap.Int64("added_by", upd.From.ID), zap.Duration("timeout", timeout))
+
+	id := strconv.FormatInt(chat.ID, 10)
+	hours := int(timeout.Round(time.Hour) / time.Hour)
+	messages := g.notify(ctx, cfg, func(lang string) (string, *telego.InlineKeyboardMarkup) {
+		text := i18n.T(lang, "access.ask", chatTitle(chat), userName(&upd.From)) + "\n" +
+			i18n.N(lang, "access.ask_timeout", hours, hours)
+		return text, tu.InlineKeyboard(tu.InlineKeyboardRow(
+			tu.InlineKeyboardButton(i18n.T(lang, "access.approve")).WithCallbackData(callbackPrefix+"approve:"+id),
+			tu.InlineKeyboardButton(i18n.T(lang, "access.deny")).WithCallbackData(callbackPrefix+"deny:"+id),
+		))
+	})
+
+	g.mu.Lock()
+	g.state.Pending[chat.ID] = &pending{
+		Title:    chat.Title,
+		Type:     chat.Type,
+		AddedBy:  upd.From.ID,
+		Deadline: time.Now().Add(timeout),
+		Messages: messages,
+	}
+	g.save(logger)
+	g.schedule(chat.ID, timeout)
+	g.mu.Unlock()
+}
+
+// decision обрабатывает нажатие кнопки владельцем
+func (g *Guard) decision(ctx context.Context, query *telego.CallbackQuery) {
+	logger := log.FromContext(ctx)
+	lang := g.ownerLang(query.From.ID)
+	action, id, _ := strings.Cut(strings.TrimPrefix(query.Data, callbackPrefix), ":")
+	chatID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil || !slices.Contains(g.cfg.Load().Owners, query.From.ID) {
+		g.answer(ctx, query, i18n.T(i18n.Pick(query.From.LanguageCode), "access.owner_only"))
+		return
+	}
+
+	g.mu.Lock()
+	if to, ok := g.state.Migrated[chatID]; ok {
+		chatID = to
+	}
+	p, ok := g.state.Pending[chatID]
+	g.mu.Unlock()
+	if !ok {
+		g.answer(ctx, query, i18n.T(lang, "access.decided"))
+		return
+	}
+
+	switch action {
+	case "approve":
+		logger.Info("chat approved by owner", zap.Int64("approved_chat_id", chatID))
+		g.approve(chatID)
+		g.answer(ctx, query, i18n.T(lang, "access.approved_answer"))
+		g.resolve(ctx, p, "access.approved", html.EscapeString(p.Title), userName(&query.From))
+		if g.onApprove != nil {
+			g.onApprove(ctx, telego.Chat{ID: chatID, Type: p.Type, Title: p.Title})
+		}
+	case "deny":
+		logger.Info("chat denied by owner", zap.Int64("denied_chat_id", chatID))
+		g.forget(chatID)
+		g.leave(ctx, chatID)
+		g.answer(ctx, query, i18n.T(lang, "access.denied_answer"))
+		g.resolve(ctx, p, "access.denied", html.EscapeString(p.Title), userName(&query.From))
+	default:
+		g.answer(ctx, query, "")
+	}
+}
+
+// migrate переносит одобрение, ожидание решения и настройки архивирования группы,
+// ставшей супергруппой: у супергруппы новый идентификатор, а группа больше
+// не получает обновлений. Повторный вызов ничего не меняет
+func (g *Guard) migrate(ctx context.Context, from, to int64) {
+	logger := log.FromContext(ctx).With(zap.Int64("from_chat_id", from), zap.Int64("to_chat_id", to))
+	cfg := g.cfg.Load()
+	listed := slices.Contains(cfg.Chats, from)
+
+	g.mu.Lock()
+	approved := g.state.Approved[from]
+	p, waiting := g.state.Pending[from]
+	if approved || listed {
+		delete(g.state.Approved, from)
+		if !slices.Contains(cfg.Chats, to) {
+			g.state.Approved[to] = true
+		}
+	}
+	if waiting {
+		g.dropPending(from)
+		p.Type = telego.ChatTypeSupergroup
+		g.state.Pending[to] = p
+		g.state.Migrated[from] = to
+		g.schedule(to, time.Until(p.Deadline))
+	}
+	if approved || listed || waiting {
+		g.save(logger)
+	}
+	g.mu.Unlock()
+
+	if approved || listed || waiting {
+		logger.Info("chat migrated to supergroup", zap.Bool("approved", approved || listed), zap.Bool("pending", waiting))
+	}
+	if listed && !slices.Contains(cfg.Chats, to) {
+		logger.Warn("chat from access.chats became a supergroup, replace its id in the config")
+	}
+	if err := g.archiving.Move(from, to); err != nil {
+		logger.Error("move archiving settings", zap.Error(err))
+	}
+}
+
+// schedule выходит из чата, если решение не принято за delay. Вызывается под g.mu
+func (g *Guard) schedule(chatID int64, delay time.Duration) {
+	if t, ok := g.timers[chatID]; ok {
+		t.Stop()
+	}
+	g.timers[chatID] = time.AfterFunc(max(delay, 0), func() {
+		g.mu.Lock()
+		p, ok := g.state.Pending[chatID]
+		g.mu.Unlock()
+		if !ok {
+			return
+		}
+		ctx := log.WithLogger(context.Background(), g.log.With(zap.Int64("chat_id", chatID)))
+		log.FromContext(ctx).Info("approval timed out, leaving chat")
+		g.forget(chatID)
+		g.leave(ctx, chatID)
+		g.resolve(ctx, p, "access.timed_out", html.EscapeString(p.Title))
+	})
+}
+
+func (g *Guard) approve(chatID int64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.state.Approved[chatID] = true
+	g.dropPending(chatID)
+	g.save(g.log)
+}
+
+// forget убирает чат из одобренных и ожидающих, например когда бота удалили из чата
+func (g *Guard) forget(chatID int64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	_, approved := g.state.Approved[chatID]
+	_, waiting := g.state.Pending[chatID]
+	if !approved && !waiting {
+		return
+	}
+	delete(g.state.Approved, chatID)
+	g.dropPending(chatID)
+	g.save(g.log)
+}
+
+// dropPending вызывается под g.mu
+func (g *Guard) dropPending(chatID int64) {
+	delete(g.state.Pending, chatID)
+	for from, to := range g.state.Migrated {
+		if to == chatID {
+			delete(g.state.Migrated, from)
+		}
+	}
+	if t, ok := g.timers[chatID]; ok {
+		t.Stop()
+		delete(g.timers, chatID)
+	}
+}
+
+// save вызывается под g.mu
+func (g *Guard) save(logger *zap.Logger) {
+	if err := g.store.PutState(stateName, &g.state); err != nil {
+		logger.Error("save access state", zap.Error(err))
+	}
+}
+
+func (g *Guard) leave(ctx context.Context, chatID int64) {
+	if err := g.api.LeaveChat(&telego.LeaveChatParams{ChatID: tu.ID(chatID)}); err != nil {
+		log.FromContext(ctx).Error("leave chat", zap.Int64("chat_id", chatID), zap.Error(err))
+	}
+}
+
+// ownerLang язык уведомлений владельцу: выбранный командой /language в личном чате с ботом
+func (g *Guard) ownerLang(ownerID int64) string {
+	return i18n.Pick(g.archiving.Get(ownerID).Language)
+}
+
+// notify отправляет уведомление всем владельцам на их языке. Владелец, который
+// не начинал диалог с ботом, уведомление не получит
+func (g *Guard) notify(ctx context.Context, cfg *config.Access, render func(lang string) (string, *telego.InlineKeyboardMarkup)) []ownerMessage {
+	var sent []ownerMessage
+	for _, owner := range cfg.Owners {
+		text, markup := render(g.ownerLang(owner))
+		params := tu.Message(tu.ID(owner), text).WithParseMode(telego.ModeHTML)
+		if markup != nil {
+			params = params.WithReplyMarkup(markup)
+		}
+		msg, err := g.api.SendMessage(params)
+		if err != nil {
+			log.FromContext(ctx).Error("notify owner", zap.Int64("owner_id", owner), zap.Error(err))
+			continue
+		}
+		sent = append(sent, ownerMessage{ChatID: owner, MessageID: msg.MessageID})
+	}
+	return sent
+}
+
+// resolve заменяет уведомления владельцам итогом по ключу каталога, убирая кнопки
+func (g *Guard) resolve(ctx context.Context, p *pending, key string, args ...any) {
+	for _, m := range p.Messages {
+		_, err := g.api.EditMessageText(&telego.EditMessageTextParams{
+			ChatID:    tu.ID(m.ChatID),
+			MessageID: m.MessageID,
+			Text:      i18n.T(g.ownerLang(m.ChatID), key, args...),
+			ParseMode: telego.ModeHTML,
+		})
+		if err != nil {
+			log.FromContext(ctx).Warn("edit owner notification", zap.Int64("owner_id", m.ChatID), zap.Error(err))
+		}
+	}
+}
+
+func (g *Guard) answer(ctx context.Context, query *telego.CallbackQuery, text string) {
+	params := tu.CallbackQuery(query.ID)
+	if text != "" {
+		params = params.WithText(text)
+	}
+	if err := g.api.AnswerCallbackQuery(params); err != nil {
+		log.FromContext(ctx).Error("answer callback query", zap.Error(err))
+	}
+}
+
+// IsPresent участник, например сам бот, состоит в чате
+func IsPresent(member telego.ChatMember) bool {
+	if member == nil {
+		return false
+	}
+	switch member.MemberStatus() {
+	case telego.MemberStatusLeft, telego.MemberStatusBanned:
+		return false
+	}
+	return member.MemberIsMember()
+}
+
+func chatTitle(chat telego.Chat) string {
+	title := chat.Title
+	if chat.Username != "" {
+		title += " (@" + chat.Username + ")"
+	}
+	return "<b>" + html.EscapeString(title) + "</b> [" + strconv.FormatInt(chat.ID, 10) + "]"
+}
+
+func userName(u *telego.User) string {
+	name := strings.TrimSpace(u.FirstName + " " + u.LastName)
+	if u.Username != "" {
+		name += " (@" + u.Username + ")"
+	}
+	return html.EscapeString(name) + " [" + strconv.FormatInt(u.ID, 10) + "]"
+}
@@ -0,0 +1,240 @@
+package access
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"tg-archive-bot/internal/archive"
+	"tg-archive-bot/internal/config"
+
+	"github.com/mymmrac/telego"
+	"go.uber.org/zap"
+)
+
+const (
+	owner     = 1
+	stranger  = 2
+	groupID   = -100
+	migrateID = -1001234567890
+)
+
+// fakeAPI сервер Bot API, запоминающий вызванные методы
+type fakeAPI struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (a *fakeAPI) serve(w http.ResponseWriter, r *http.Request) {
+	method := path.Base(r.URL.Path)
+	a.mu.Lock()
+	a.calls = append(a.calls, method)
+	a.mu.Unlock()
+	switch method {
+	case "sendMessage", "editMessageText":
+		_, _ = io.WriteString(w, `{"ok":true,"result":{"message_id":7,"date":0,"chat":{"id":1,"type":"private"}}}`)
+	default:
+		_, _ = io.WriteString(w, `{"ok":true,"result":true}`)
+	}
+}
+
+// called число вызовов метода
+func (a *fakeAPI) called(method string) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var n int
+	for _, c := range a.calls {
+		if c == method {
+			n++
+		}
+	}
+	return n
+}
+
+func newTestGuard(t *testing.T, cfg config.Access) (*Guard, *fakeAPI) {
+	t.Helper()
+	api := &fakeAPI{}
+	server := httptest.NewServer(http.HandlerFunc(api.serve))
+	t.Cleanup(server.Close)
+	bot, err := telego.NewBot("123456789:"+strings.Repeat("a", 35), telego.WithAPIServer(server.URL), telego.WithDiscardLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := archive.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	archiving, err := NewArchiving(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := New(bot, store, archiving, cfg, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(g.Stop)
+	return g, api
+}
+
+// added обновление о добавлении бота в группу пользователем from
+func added(from int64) telego.Update {
+	return telego.Update{MyChatMember: &telego.ChatMemberUpdated{
+		Chat:          telego.Chat{ID: groupID, Type: telego.ChatTypeGroup, Title: "group"},
+		From:          telego.User{ID: from, FirstName: "user"},
+		OldChatMember: &telego.ChatMemberLeft{Status: telego.MemberStatusLeft},
+		NewChatMember: &telego.ChatMemberMember{Status: telego.MemberStatusMember},
+	}}
+}
+
+func press(from int64, data string) telego.Update {
+	return telego.Update{CallbackQuery: &telego.CallbackQuery{ID: "q", From: telego.User{ID: from}, Data: data}}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	g, _ := newTestGuard(t, config.Access{})
+	if !g.Allowed(groupID) {
+		t.Fatal("chat denied with access control disabled")
+	}
+	g.SetConfig(config.Access{Chats: []int64{groupID}})
+	if !g.Allowed(groupID) || g.Allowed(migrateID) {
+		t.Fatal("only listed chats must be allowed")
+	}
+
+	// владелец добавляет бота сам
+	g, api := newTestGuard(t, config.Access{Owners: []int64{owner}})
+	g.HandleUpdate(context.Background(), added(owner))
+	if !g.Allowed(groupID) || api.called("leaveChat") != 0 {
+		t.Fatal("chat added by owner is not approved")
+	}
+}
+
+func TestLeaveWithoutApproval(t *testing.T) {
+	g, api := newTestGuard(t, config.Access{Owners: []int64{owner}})
+	g.HandleUpdate(context.Background(), added(stranger))
+	if g.Allowed(groupID) {
+		t.Fatal("chat added by stranger is allowed")
+	}
+	if api.called("leaveChat") != 1 || api.called("sendMessage") != 1 {
+		t.Fatal("bot must leave and notify the owner")
+	}
+}
+
+func TestApprove(t *testing.T) {
+	g, api := newTestGuard(t, config.Access{Owners: []int64{owner}, Approval: true})
+	var onboarded []int64
+	g.OnApprove(func(_ context.Context, chat telego.Chat) { onboarded = append(onboarded, chat.ID) })
+
+	g.HandleUpdate(context.Background(), added(stranger))
+	if g.Allowed(groupID) {
+		t.Fatal("chat allowed before approval")
+	}
+	if n := api.called("sendMessage"); n != 1 {
+		t.Fatalf("%d owner notifications, want 1", n)
+	}
+	g.HandleUpdate(context.Background(), press(stranger, "access:approve:-100"))
+	if g.Allowed(groupID) {
+		t.Fatal("chat approved by a non-owner")
+	}
+	g.HandleUpdate(context.Background(), press(owner, "access:approve:-100"))
+	if !g.Allowed(groupID) {
+		t.Fatal("chat is not allowed after approval")
+	}
+	if len(onboarded) != 1 || onboarded[0] != groupID {
+		t.Fatalf("onboarded %v, want the approved chat", onboarded)
+	}
+	if api.called("editMessageText") != 1 || api.called("leaveChat") != 0 {
+		t.Fatal("owner notification is not resolved")
+	}
+}
+
+func TestDeny(t *testing.T) {
+	g, api := newTestGuard(t, config.Access{Owners: []int64{owner}, Approval: true})
+	g.HandleUpdate(context.Background(), added(stranger))
+	g.HandleUpdate(context.Background(), press(owner, "access:deny:-100"))
+	if g.Allowed(groupID) {
+		t.Fatal("denied chat is allowed")
+	}
+	if api.called("leaveChat") != 1 {
+		t.Fatal("bot did not leave the denied chat")
+	}
+	// повторное решение по тому же чату ничего не меняет
+	g.HandleUpdate(context.Background(), press(owner, "access:approve:-100"))
+	if g.Allowed(groupID) {
+		t.Fatal("chat approved after it was denied")
+	}
+}
+
+func TestApprovalTimeout(t *testing.T) {
+	g, api := newTestGuard(t, config.Access{Owners: []int64{owner}, Approval: true, ApprovalTimeout: "50ms"})
+	g.HandleUpdate(context.Background(), added(stranger))
+	waitFor(t, func() bool { return api.called("leaveChat") == 1 })
+	if g.Allowed(groupID) {
+		t.Fatal("chat allowed after approval timed out")
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.state.Pending) != 0 {
+		t.Fatal("timed out chat is still pending")
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	g, _ := newTestGuard(t, config.Access{Owners: []int64{owner}})
+	g.HandleUpdate(context.Background(), added(owner))
+	_, err := g.archiving.Update(groupID, func(c *ChatArchiving) { c.TextOnly, c.RetentionDays = true, 30 })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	migrated := telego.Update{Message: &telego.Message{
+		Chat:              telego.Chat{ID: migrateID, Type: telego.ChatTypeSupergroup},
+		MigrateFromChatID: groupID,
+	}}
+	// обе стороны перехода приходят отдельными сообщениями
+	for _, update := range []telego.Update{migrated, {Message: &telego.Message{
+		Chat:            telego.Chat{ID: groupID, Type: telego.ChatTypeGroup},
+		MigrateToChatID: migrateID,
+	}}} {
+		g.HandleUpdate(context.Background(), update)
+		if !g.Allowed(migrateID) || g.Allowed(groupID) {
+			t.Fatal("approval is not moved to the supergroup")
+		}
+		if c := g.archiving.Get(migrateID); !c.TextOnly || c.RetentionDays != 30 {
+			t.Fatalf("archiving settings not moved: %+v", c)
+		}
+	}
+}
+
+// Кнопки уведомления содержат идентификатор группы, решение применяется к супергруппе
+func TestMigratePending(t *testing.T) {
+	g, api := newTestGuard(t, config.Access{Owners: []int64{owner}, Approval: true})
+	g.HandleUpdate(context.Background(), added(stranger))
+	g.HandleUpdate(context.Background(), telego.Update{Message: &telego.Message{
+		Chat:            telego.Chat{ID: groupID, Type: telego.ChatTypeGroup},
+		MigrateToChatID: migrateID,
+	}})
+	g.HandleUpdate(context.Background(), press(owner, "access:approve:-100"))
+	if !g.Allowed(migrateID) {
+		t.Fatal("supergroup is not approved by the group's button")
+	}
+	if api.called("leaveChat") != 0 {
+		t.Fatal("bot left the approved supergroup")
+	}
+}
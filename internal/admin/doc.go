@@ -0,0 +1,3 @@
+package admin
+
+// HTTP сервер администрирования: уровень логирования, pprof, сведения о сборке и конфигурации
@@ -0,0 +1,248 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"tg-archive-bot/internal/config"
+	"tg-archive-bot/internal/log"
+
+	"go.uber.org/zap"
+)
+
+// unixPrefix префикс адреса unix-сокета в config.Admin.Listen
+const unixPrefix = "unix:"
+
+// ErrInsecure сервер слушает не localhost и токен не задан
+var ErrInsecure = errors.New("admin: token is required for non-loopback listen address")
+
+// Server HTTP сервер администрирования
+type Server struct {
+	cfg    config.Admin
+	mux    *http.ServeMux
+	server *http.Server
+	log    *zap.Logger
+	// public пути, доступные без токена
+	public map[string]bool
+	// remote сервер слушает не localhost: без токена запросы отклоняются,
+	// даже если токен убрали из конфигурации после запуска
+	remote bool
+
+	reloader *config.Reloader
+}
+
+// New создает сервер, reloader дает действующую конфигурацию для GET /config и перечитывает ее
+func New(cfg config.Admin, reloader *config.Reloader) *Server {
+	s := &Server{
+		cfg:      cfg,
+		mux:      http.NewServeMux(),
+		log:      log.Named("admin"),
+		public:   make(map[string]bool),
+		reloader: reloader,
+	}
+
+	s.mux.HandleFunc("GET /log/level", s.getLevel)
+	s.mux.HandleFunc("PUT /log/level", s.putLevel)
+	s.mux.HandleFunc("GET /buildinfo", s.buildInfo)
+	s.mux.HandleFunc("GET /config", s.config)
+	s.mux.HandleFunc("POST /config/reload", s.reload)
+
+	s.mux.HandleFunc("GET /debug/pprof/", pprof.Index)
+	s.mux.HandleFunc("GET /debug/pprof/cmdline", pprof.Cmdline)
+	s.mux.HandleFunc("GET /debug/pprof/profile", pprof.Profile)
+	s.mux.HandleFunc("GET /debug/pprof/symbol", pprof.Symbol)
+	s.mux.HandleFunc("POST /debug/pprof/symbol", pprof.Symbol)
+	s.mux.HandleFunc("GET /debug/pprof/trace", pprof.Trace)
+
+	s.server = &http.Server{
+		Handler:           s.authorize(s.mux),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return s
+}
+
+// Handle регистрирует дополнительный обработчик, например метрики
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// HandlePublic регистрирует обработчик пути path, доступный без токена, например для проб Kubernetes
+func (s *Server) HandlePublic(path string, handler http.Handler) {
+	s.public[path] = true
+	s.mux.Handle("GET "+path, handler)
+}
+
+// Start начинает принимать соединения в отдельной горутине
+func (s *Server) Start() error {
+	listener, err := s.listen()
+	if err != nil {
+		return err
+	}
+	s.log.Info("admin server started", zap.String("listen", s.cfg.Listen))
+	go func() {
+		if err := s.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.log.Error("admin server stopped", zap.Error(err))
+		}
+	}()
+	return nil
+}
+
+// Shutdown останавливает сервер, дожидаясь завершения запросов
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+func (s *Server) listen() (net.Listener, error) {
+	if path, ok := strings.CutPrefix(s.cfg.Listen, unixPrefix); ok {
+		// сокет от предыдущего запуска
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		listener, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, err
+		}
+		if err = os.Chmod(path, 0o660); err != nil {
+			listener.Close()
+			return nil, err
+		}
+		return listener, nil
+	}
+
+	host, _, err := net.SplitHostPort(s.cfg.Listen)
+	if err != nil {
+		return nil, fmt.Errorf("admin: listen %q: %w", s.cfg.Listen, err)
+	}
+	s.remote = !isLoopback(host)
+	if s.remote && s.token() == "" {
+		return nil, ErrInsecure
+	}
+	return net.Listen("tcp", s.cfg.Listen)
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// token действующий токен: admin.token меняется без перезапуска
+func (s *Server) token() string {
+	if s.reloader != nil {
+		return s.reloader.Current().Admin.Token
+	}
+	return s.cfg.Token
+}
+
+// authorize проверяет токен из заголовка Authorization: Bearer, если он задан
+func (s *Server) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		expected := s.token()
+		if (expected != "" || s.remote) && !s.public[r.URL.Path] {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || expected == "" || subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// levelRequest тело PUT /log/level, пустой module меняет общий уровень,
+// пустой level для модуля возвращает ему общий уровень
+type levelRequest struct {
+	Module string `json:"module,omitempty"`
+	Level  string `json:"level"`
+}
+
+type levelResponse struct {
+	Level   string            `json:"level"`
+	Modules map[string]string `json:"modules"`
+}
+
+func (s *Server) getLevel(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, levelResponse{Level: log.GetLogLevel(), Modules: log.GetModuleLevels()})
+}
+
+func (s *Server) putLevel(w http.ResponseWriter, r *http.Request) {
+	var req levelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var err error
+	if req.Module == "" {
+		err = log.SetLogLevel(req.Level)
+	} else {
+		err = log.SetModuleLevel(req.Module, req.Level)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.log.Info("log level changed", zap.String("module", req.Module), zap.String("level", req.Level))
+	s.getLevel(w, r)
+}
+
+func (s *Server) buildInfo(w http.ResponseWriter, _ *http.Request) {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		http.Error(w, "build info is not available", http.StatusNotFound)
+		return
+	}
+	settings := make(map[string]string, len(info.Settings))
+	for _, setting := range info.Settings {
+		settings[setting.Key] = setting.Value
+	}
+	deps := make(map[string]string, len(info.Deps))
+	for _, dep := range info.Deps {
+		deps[dep.Path] = dep.Version
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"go":       info.GoVersion,
+		"path":     info.Path,
+		"version":  info.Main.Version,
+		"settings": settings,
+		"deps":     deps,
+	})
+}
+
+func (s *Server) config(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, s.reloader.Current().Masked())
+}
+
+func (s *Server) reload(w http.ResponseWriter, r *http.Request) {
+	if err := s.reloader.Reload(); err != nil {
+		s.log.Error("config reload failed", zap.Error(err))
+		status := http.StatusBadRequest
+		if errors.Is(err, config.ErrApply) {
+			status = http.StatusInternalServerError
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	s.config(w, r)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(v)
+}
@@ -0,0 +1,364 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Операции в цепочке изменений чата
+const (
+	// ChainBaseline сообщение уже было в архиве, когда для чата началась цепочка
+	ChainBaseline = "baseline"
+	ChainPut      = "put"
+	ChainDelete   = "delete"
+)
+
+// ChainEntry запись цепочки изменений чата <dir>/chats/<chat_id>/chain.jsonl.
+// Каждая запись содержит хэш предыдущей, поэтому изменить, вставить или удалить
+// запись незаметно нельзя, не пересчитав все следующие
+type ChainEntry struct {
+	Seq       int64  `json:"seq"`
+	Time      int64  `json:"time"`
+	Op        string `json:"op"`
+	MessageID int    `json:"message_id"`
+	// Record sha256 записи сообщения, для delete пусто
+	Record string `json:"record,omitempty"`
+	Prev   string `json:"prev"`
+	Hash   string `json:"hash"`
+}
+
+// Sum хэш записи цепочки по всем полям, кроме Hash
+func (e *ChainEntry) Sum() string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d|%d|%s|%d|%s|%s", e.Seq, e.Time, e.Op, e.MessageID, e.Record, e.Prev)))
+	return hex.EncodeToString(sum[:])
+}
+
+// Checkpoint подписанная вершина цепочки чата
+type Checkpoint struct {
+	ChatID int64  `json:"chat_id"`
+	Seq    int64  `json:"seq"`
+	Hash   string `json:"hash"`
+	Time   int64  `json:"time"`
+	// Deleted чат удален из архива, Seq и Hash — последняя запись его цепочки.
+	// DeleteChat оставляет такую отметку без подписи, подписывает ее бот
+	Deleted   bool   `json:"deleted,omitempty"`
+	Signature []byte `json:"signature"`
+}
+
+// RecordHash sha256 записи сообщения. Считается по JSON без шифрования, поэтому
+// не зависит от того, зашифрован ли архив
+func RecordHash(m *Message) (string, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	return recordHash(data), nil
+}
+
+func recordHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *Store) chainPath(chatID int64) string {
+	return filepath.Join(s.chatDir(chatID), "chain.jsonl")
+}
+
+// checkpointsPath подписанные вершины хранятся вне каталога чата, чтобы удаление
+// чата целиком было видно при проверке
+func (s *Store) checkpointsPath(chatID int64) string {
+	return filepath.Join(s.dir, "checkpoints", strconv.FormatInt(chatID, 10)+".jsonl")
+}
+
+// legacyCheckpointsPath подписанные вершины в каталоге чата, как их хранили прежние версии
+func (s *Store) legacyCheckpointsPath(chatID int64) string {
+	return filepath.Join(s.chatDir(chatID), "checkpoints.jsonl")
+}
+
+// migrateCheckpoints переносит подписанные вершины из каталога чата. Вызывается под s.mu
+func (s *Store) migrateCheckpoints(chatID int64) error {
+	legacy := s.legacyCheckpointsPath(chatID)
+	if _, err := os.Stat(legacy); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	path := s.checkpointsPath(chatID)
+	if _, err := os.Stat(path); err == nil {
+		// перенос прервался после переименования
+		return os.Remove(legacy)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+	return os.Rename(legacy, path)
+}
+
+// writeMessage сохраняет сообщение и дописывает его версию в цепочку. Вызывается под s.mu
+func (s *Store) writeMessage(m *Message) error {
+	// baseline уже сохраненных сообщений до записи нового
+	if err := s.startChain(m.ChatID); err != nil {
+		return fmt.Errorf("start chain: %w", err)
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if err = s.writeSealedData(s.messagePath(m.ChatID, m.ID), chatKey(m.ChatID), data); err != nil {
+		return err
+	}
+	return s.appendChain(m.ChatID, ChainPut, m.ID, recordHash(data))
+}
+
+// StartChain начинает цепочку чата, если ее еще нет
+func (s *Store) StartChain(chatID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.startChain(chatID)
+}
+
+// startChain начинает цепочку чата, в котором уже есть сообщения: каждое
+// записывается как baseline. Вызывается под s.mu
+func (s *Store) startChain(chatID int64) error {
+	if _, ok := s.heads[chatID]; ok {
+		return nil
+	}
+	if _, err := s.loadHead(chatID); !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	// пустая вершина, чтобы appendChain не начинал цепочку повторно
+	s.heads[chatID] = ChainEntry{}
+	ids, err := s.MessageIDs(chatID)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		m, err := s.GetMessage(chatID, id)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		hash, err := RecordHash(m)
+		if err != nil {
+			return err
+		}
+		if err = s.appendChain(chatID, ChainBaseline, id, hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendChain дописывает запись в цепочку чата. Вызывается под s.mu
+func (s *Store) appendChain(chatID int64, op string, messageID int, record string) error {
+	if err := s.startChain(chatID); err != nil {
+		return fmt.Errorf("start chain: %w", err)
+	}
+	head := s.heads[chatID]
+	e := ChainEntry{
+		Seq:       head.Seq + 1,
+		Time:      time.Now().Unix(),
+		Op:        op,
+		MessageID: messageID,
+		Record:    record,
+		Prev:      head.Hash,
+	}
+	e.Hash = e.Sum()
+	if err := appendLine(s.chainPath(chatID), &e); err != nil {
+		return fmt.Errorf("append chain: %w", err)
+	}
+	s.heads[chatID] = e
+	return nil
+}
+
+// loadHead читает последнюю запись цепочки, fs.ErrNotExist если цепочки нет.
+// Недописанная после аварийного завершения строка отрезается
+func (s *Store) loadHead(chatID int64) (ChainEntry, error) {
+	var head ChainEntry
+	f, err := os.OpenFile(s.chainPath(chatID), os.O_RDWR, 0)
+	if err != nil {
+		return head, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return head, err
+	}
+
+	// строки короткие, поэтому достаточно хвоста файла
+	offset := max(info.Size()-8<<10, 0)
+	tail := make([]byte, info.Size()-offset)
+	if _, err = f.ReadAt(tail, offset); err != nil && !errors.Is(err, io.EOF) {
+		return head, err
+	}
+	end := bytes.LastIndexByte(tail, '\n')
+	if end+1 < len(tail) {
+		if err = f.Truncate(offset + int64(end) + 1); err != nil {
+			return head, err
+		}
+	}
+	if end < 0 {
+		s.heads[chatID] = head
+		return head, nil
+	}
+	line := tail[bytes.LastIndexByte(tail[:end], '\n')+1 : end]
+	if err = json.Unmarshal(line, &head); err != nil {
+		return head, fmt.Errorf("decode chain head of chat %d: %w", chatID, err)
+	}
+	s.heads[chatID] = head
+	return head, nil
+}
+
+// ChainHead последняя запись цепочки чата, false если цепочки еще нет
+func (s *Store) ChainHead(chatID int64) (ChainEntry, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if head, ok := s.heads[chatID]; ok {
+		return head, head.Seq > 0, nil
+	}
+	head, err := s.loadHead(chatID)
+	if errors.Is(err, fs.ErrNotExist) {
+		return head, false, nil
+	}
+	return head, head.Seq > 0, err
+}
+
+// Chain обходит записи цепочки чата по порядку. Строку, которую не удалось разобрать,
+// fn получает с ошибкой и номером строки в Seq
+func (s *Store) Chain(chatID int64, fn func(e ChainEntry, err error) error) error {
+	return readLines(s.chainPath(chatID), func(n int64, line []byte) error {
+		var e ChainEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return fn(ChainEntry{Seq: n}, err)
+		}
+		return fn(e, nil)
+	})
+}
+
+// AppendCheckpoint сохраняет подписанную вершину цепочки чата
+func (s *Store) AppendCheckpoint(cp Checkpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.migrateCheckpoints(cp.ChatID); err != nil {
+		return fmt.Errorf("move checkpoints: %w", err)
+	}
+	return appendLine(s.checkpointsPath(cp.ChatID), &cp)
+}
+
+// Checkpoints обходит подписанные вершины цепочки чата по порядку
+func (s *Store) Checkpoints(chatID int64, fn func(cp Checkpoint, err error) error) error {
+	read := func(_ int64, line []byte) error {
+		var cp Checkpoint
+		if err := json.Unmarshal(line, &cp); err != nil {
+			return fn(cp, err)
+		}
+		return fn(cp, nil)
+	}
+	// до первой новой подписи вершины могут лежать в каталоге чата
+	if err := readLines(s.legacyCheckpointsPath(chatID), read); err != nil {
+		return err
+	}
+	return readLines(s.checkpointsPath(chatID), read)
+}
+
+// CheckpointedChats чаты, вершины которых подписывались, включая удаленные
+func (s *Store) CheckpointedChats() ([]int64, error) {
+	entries, err := os.ReadDir(filepath.Join(s.dir, "checkpoints"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int64, 0, len(entries))
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".jsonl")
+		if !ok {
+			continue
+		}
+		if id, err := strconv.ParseInt(name, 10, 64); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+// MessageIDs идентификаторы сохраненных сообщений чата по возрастанию
+func (s *Store) MessageIDs(chatID int64) ([]int, error) {
+	entries, err := os.ReadDir(filepath.Join(s.chatDir(chatID), "messages"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int, 0, len(entries))
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok {
+			continue
+		}
+		if id, err := strconv.Atoi(name); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+func appendLine(path string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(data, '\n'))
+	return errors.Join(err, f.Close())
+}
+
+// readLines вызывает fn для каждой непустой строки файла, нумерация с 1.
+// Отсутствующий файл — пустой
+func readLines(path string, fn func(n int64, line []byte) error) error {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64<<10), 1<<20)
+	var n int64
+	for scanner.Scan() {
+		n++
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		if err = fn(n, scanner.Bytes()); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,403 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Зашифрованный файл: заголовок и последовательность блоков AES-GCM
+//
+//	"TGAE" | версия | длина имени ключа | имя ключа | префикс nonce (7 байт)
+//	блок: до chunkSize байт данных + 16 байт тега
+//
+// nonce блока — префикс, номер блока и признак последнего блока, заголовок входит
+// в проверяемые данные каждого блока. Поэтому блоки нельзя переставить, подменить
+// ключ в заголовке или незаметно обрезать файл
+const (
+	sealMagic   = "TGAE"
+	sealVersion = 1
+	chunkSize   = 64 << 10
+	prefixSize  = 7
+	keySize     = 32
+)
+
+// SealedSuffix суффикс имени зашифрованного файла с произвольным содержимым: вложения
+// и снимка индекса. Зашифрован файл или нет, видно по имени, поэтому незашифрованный
+// файл, который сам начинается с "TGAE", не принимается за зашифрованный
+const SealedSuffix = ".enc"
+
+// ErrEncrypted архив зашифрован, а мастер-ключ не задан
+var ErrEncrypted = errors.New("archive is encrypted: master key is not configured")
+
+// MasterKey ключ, которым шифруются ключи данных. В архиве хранится только его отпечаток
+type MasterKey struct {
+	key []byte
+}
+
+// ParseMasterKey разбирает мастер-ключ: 32 байта в base64 или hex
+func ParseMasterKey(text string) (*MasterKey, error) {
+	text = strings.TrimSpace(text)
+	for _, decode := range []func(string) ([]byte, error){
+		hex.DecodeString,
+		base64.StdEncoding.DecodeString,
+		base64.RawURLEncoding.DecodeString,
+	} {
+		if key, err := decode(text); err == nil && len(key) == keySize {
+			return &MasterKey{key: key}, nil
+		}
+	}
+	return nil, fmt.Errorf("master key must be %d bytes in hex or base64", keySize)
+}
+
+// LoadMasterKey читает мастер-ключ из файла или переменной окружения.
+// Если оба источника пусты, шифрование выключено и возвращается nil
+func LoadMasterKey(file, env string) (*MasterKey, error) {
+	switch {
+	case file != "":
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("read master key: %w", err)
+		}
+		return ParseMasterKey(string(data))
+	case env != "":
+		text, ok := os.LookupEnv(env)
+		if !ok {
+			return nil, fmt.Errorf("master key: environment variable %s is not set", env)
+		}
+		return ParseMasterKey(text)
+	}
+	return nil, nil
+}
+
+// Fingerprint отпечаток ключа, по которому видно, каким ключом зашифрованы ключи данных
+func (k *MasterKey) Fingerprint() string {
+	sum := sha256.Sum256(append([]byte("tg-archive-bot master key\x00"), k.key...))
+	return hex.EncodeToString(sum[:8])
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealHeader заголовок зашифрованного файла с новым случайным префиксом nonce
+func sealHeader(name string) ([]byte, error) {
+	if len(name) > 255 {
+		return nil, fmt.Errorf("key name %q is too long", name)
+	}
+	header := make([]byte, 0, len(sealMagic)+2+len(name)+prefixSize)
+	header = append(header, sealMagic...)
+	header = append(header, sealVersion, byte(len(name)))
+	header = append(header, name...)
+	prefix := make([]byte, prefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+	return append(header, prefix...), nil
+}
+
+// readSealHeader читает заголовок после сигнатуры и возвращает его целиком и имя ключа
+func readSealHeader(r io.Reader) ([]byte, string, error) {
+	head := make([]byte, len(sealMagic)+2)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, "", fmt.Errorf("read encryption header: %w", err)
+	}
+	if head[len(sealMagic)] != sealVersion {
+		return nil, "", fmt.Errorf("unsupported encryption version %d", head[len(sealMagic)])
+	}
+	rest := make([]byte, int(head[len(sealMagic)+1])+prefixSize)
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return nil, "", fmt.Errorf("read encryption header: %w", err)
+	}
+	name := string(rest[:len(rest)-prefixSize])
+	return append(head, rest...), name, nil
+}
+
+func chunkNonce(header []byte, n uint32, final bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, header[len(header)-prefixSize:])
+	binary.BigEndian.PutUint32(nonce[prefixSize:], n)
+	if final {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// sealWriter шифрует поток блоками. Последний блок пишется в Close
+type sealWriter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	header []byte
+	buf    []byte
+	n      uint32
+	err    error
+}
+
+func newSealWriter(w io.Writer, aead cipher.AEAD, name string) (*sealWriter, error) {
+	header, err := sealHeader(name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(header); err != nil {
+		return nil, err
+	}
+	return &sealWriter{w: w, aead: aead, header: header, buf: make([]byte, 0, chunkSize)}, nil
+}
+
+func (s *sealWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 && s.err == nil {
+		// полный блок пишется, только когда ясно, что он не последний
+		if len(s.buf) == chunkSize {
+			s.flush(false)
+			continue
+		}
+		n := copy(s.buf[len(s.buf):chunkSize], p)
+		s.buf = s.buf[:len(s.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, s.err
+}
+
+func (s *sealWriter) flush(final bool) {
+	out := s.aead.Seal(nil, chunkNonce(s.header, s.n, final), s.buf, s.header)
+	if _, err := s.w.Write(out); err != nil {
+		s.err = err
+	}
+	s.n++
+	s.buf = s.buf[:0]
+}
+
+// Close пишет последний блок, нижележащий поток не закрывается
+func (s *sealWriter) Close() error {
+	if s.err == nil {
+		s.flush(true)
+	}
+	return s.err
+}
+
+// openReader расшифровывает поток, записанный sealWriter
+type openReader struct {
+	r      *bufio.Reader
+	aead   cipher.AEAD
+	header []byte
+	buf    []byte
+	out    []byte
+	n      uint32
+	done   bool
+}
+
+func (o *openReader) Read(p []byte) (int, error) {
+	for len(o.out) == 0 {
+		if o.done {
+			return 0, io.EOF
+		}
+		if err := o.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, o.out)
+	o.out = o.out[n:]
+	return n, nil
+}
+
+func (o *openReader) next() error {
+	n, err := io.ReadFull(o.r, o.buf)
+	final := false
+	switch {
+	case errors.Is(err, io.EOF):
+		return fmt.Errorf("encrypted data is truncated: %w", io.ErrUnexpectedEOF)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		final = true
+	case err != nil:
+		return err
+	default:
+		_, err = o.r.Peek(1)
+		final = errors.Is(err, io.EOF)
+	}
+	out, err := o.aead.Open(o.buf[:0], chunkNonce(o.header, o.n, final), o.buf[:n], o.header)
+	if err != nil {
+		return fmt.Errorf("decrypt block %d: %w", o.n, err)
+	}
+	o.out = out
+	o.n++
+	o.done = final
+	return nil
+}
+
+// Encryption шифрование архива
+type Encryption struct {
+	// Master мастер-ключ, nil — архив не шифруется
+	Master *MasterKey
+	// ClearIndex снимок поискового индекса хранится незашифрованным
+	ClearIndex bool
+}
+
+func (s *Store) keyringPath() string {
+	return filepath.Join(s.dir, "keys.json")
+}
+
+// Encrypted в архиве есть зашифрованные ключи данных
+func (s *Store) Encrypted() bool {
+	_, err := os.Stat(s.keyringPath())
+	return err == nil
+}
+
+// SetEncryption включает шифрование новых записей. Записанное раньше без шифрования
+// читается как есть. Зашифрованный архив без мастер-ключа не открывается: ErrEncrypted
+func (s *Store) SetEncryption(enc Encryption) error {
+	if enc.Master == nil {
+		if s.Encrypted() {
+			return ErrEncrypted
+		}
+		return nil
+	}
+	keys, err := loadKeyring(s.keyringPath(), enc.Master)
+	if err != nil {
+		return err
+	}
+	s.keys = keys
+	s.clearIndex = enc.ClearIndex
+	return nil
+}
+
+// RotateMasterKey перешифровывает ключи данных мастер-ключом next. Сами сообщения
+// и вложения не переписываются. Возвращает число ключей данных
+func (s *Store) RotateMasterKey(next *MasterKey) (int, error) {
+	if s.keys == nil {
+		return 0, errors.New("archive encryption is not enabled")
+	}
+	return s.keys.rewrap(next)
+}
+
+// Sealed записи с ключом данных name шифруются
+func (s *Store) Sealed(name string) bool {
+	return s.keys != nil && !(name == KeyIndex && s.clearIndex)
+}
+
+// EncryptWriter шифрует запись в w ключом данных name. Если Sealed(name) false,
+// данные пишутся как есть. Close дописывает последний блок и не закрывает w
+func (s *Store) EncryptWriter(name string, w io.Writer) (io.WriteCloser, error) {
+	if !s.Sealed(name) {
+		return nopWriteCloser{w}, nil
+	}
+	aead, err := s.keys.aead(name, true)
+	if err != nil {
+		return nil, err
+	}
+	return newSealWriter(w, aead, name)
+}
+
+// OpenSealed расшифровывает r, записанный EncryptWriter с шифрованием.
+// Данные без заголовка шифрования — ошибка: решать, зашифрован ли файл, должен вызывающий
+func (s *Store) OpenSealed(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReaderSize(r, chunkSize+16)
+	magic, err := br.Peek(len(sealMagic))
+	if err != nil || string(magic) != sealMagic {
+		return nil, errors.New("encryption header not found")
+	}
+	if s.keys == nil {
+		return nil, ErrEncrypted
+	}
+	header, name, err := readSealHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := s.keys.aead(name, false)
+	if err != nil {
+		return nil, err
+	}
+	return &openReader{r: br, aead: aead, header: header, buf: make([]byte, chunkSize+aead.Overhead())}, nil
+}
+
+// readSealed читает JSON, зашифрованный или нет. Записи — JSON-объекты и без шифрования
+// начинаются с '{', поэтому с заголовком шифрования их не спутать
+func (s *Store) readSealed(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if data, err = s.openRecord(data); err != nil {
+		return fmt.Errorf("decrypt %s: %w", path, err)
+	}
+	if err = json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("decode %s: %w", path, err)
+	}
+	return nil
+}
+
+// openRecord содержимое записи: незашифрованный JSON как есть, зашифрованный расшифровывается
+func (s *Store) openRecord(data []byte) ([]byte, error) {
+	if plainRecord(data) {
+		return data, nil
+	}
+	r, err := s.OpenSealed(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// plainRecord запись хранится без шифрования
+func plainRecord(data []byte) bool {
+	trimmed := bytes.TrimLeft(data, " \t\r\n")
+	return len(trimmed) > 0 && trimmed[0] == '{'
+}
+
+// writeSealed атомарно записывает JSON, зашифрованный ключом данных name
+func (s *Store) writeSealed(path, name string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.writeSealedData(path, name, data)
+}
+
+func (s *Store) writeSealedData(path, name string, data []byte) error {
+	defer writeDuration.Since(time.Now(), "json")
+	if !s.Sealed(name) {
+		return writeFile(path, data)
+	}
+
+	var buf bytes.Buffer
+	w, err := s.EncryptWriter(name, &buf)
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(data); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return writeFile(path, buf.Bytes())
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
@@ -0,0 +1,315 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testAEAD(t *testing.T) cipher.AEAD {
+	t.Helper()
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return aead
+}
+
+func seal(t *testing.T, aead cipher.AEAD, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := newSealWriter(&buf, aead, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// запись частями разного размера, чтобы границы Write не совпадали с блоками
+	for rest := data; len(rest) > 0; {
+		n := min(len(rest), 1000+len(rest)%7000)
+		if _, err = w.Write(rest[:n]); err != nil {
+			t.Fatal(err)
+		}
+		rest = rest[n:]
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func unseal(aead cipher.AEAD, sealed []byte) ([]byte, error) {
+	br := bufio.NewReaderSize(bytes.NewReader(sealed), chunkSize+16)
+	header, _, err := readSealHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(&openReader{r: br, aead: aead, header: header, buf: make([]byte, chunkSize+aead.Overhead())})
+}
+
+func random(t *testing.T, n int) []byte {
+	t.Helper()
+	data := make([]byte, n)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestSealRoundTrip(t *testing.T) {
+	aead := testAEAD(t)
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 2 * chunkSize, 3*chunkSize + 5} {
+		data := random(t, size)
+		got, err := unseal(aead, seal(t, aead, data))
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("size %d: data differs after round trip", size)
+		}
+	}
+}
+
+func TestSealTruncated(t *testing.T) {
+	aead := testAEAD(t)
+	sealed := seal(t, aead, random(t, 3*chunkSize+5))
+	headerSize := len(sealMagic) + 2 + len("test") + prefixSize
+	block := chunkSize + aead.Overhead()
+
+	for name, cut := range map[string]int{
+		"header only":        headerSize,
+		"last block dropped": headerSize + 3*block,
+		"block boundary":     headerSize + block,
+		"inside block":       headerSize + block + 100,
+		"last byte":          len(sealed) - 1,
+	} {
+		if _, err := unseal(aead, sealed[:cut]); err == nil {
+			t.Errorf("%s: truncated data decrypted without error", name)
+		}
+	}
+}
+
+func TestSealReordered(t *testing.T) {
+	aead := testAEAD(t)
+	sealed := seal(t, aead, random(t, 3*chunkSize))
+	headerSize := len(sealMagic) + 2 + len("test") + prefixSize
+	block := chunkSize + aead.Overhead()
+
+	swapped := bytes.Clone(sealed)
+	first := swapped[headerSize : headerSize+block]
+	second := swapped[headerSize+block : headerSize+2*block]
+	tmp := bytes.Clone(first)
+	copy(first, second)
+	copy(second, tmp)
+	if _, err := unseal(aead, swapped); err == nil {
+		t.Error("reordered blocks decrypted without error")
+	}
+
+	flipped := bytes.Clone(sealed)
+	flipped[headerSize+block+10] ^= 1
+	if _, err := unseal(aead, flipped); err == nil {
+		t.Error("modified block decrypted without error")
+	}
+
+	// заголовок входит в проверяемые данные каждого блока
+	header := bytes.Clone(sealed)
+	header[headerSize-1] ^= 1
+	if _, err := unseal(aead, header); err == nil {
+		t.Error("modified header decrypted without error")
+	}
+}
+
+func testMasterKey(t *testing.T) *MasterKey {
+	t.Helper()
+	key, err := ParseMasterKey(hex.EncodeToString(random(t, keySize)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func openTestStore(t *testing.T, dir string, master *MasterKey) *Store {
+	t.Helper()
+	store, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	if err = store.SetEncryption(Encryption{Master: master}); err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestRotateMasterKey(t *testing.T) {
+	dir := t.TempDir()
+	oldKey, newKey := testMasterKey(t), testMasterKey(t)
+	store := openTestStore(t, dir, oldKey)
+	if err := store.PutMessage(&Message{ChatID: -1, ID: 1, Text: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := store.RotateMasterKey(newKey); err != nil || n != 1 {
+		t.Fatalf("rotate: %d keys, %v", n, err)
+	}
+	store.Close()
+
+	store, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if err = store.SetEncryption(Encryption{Master: oldKey}); err == nil {
+		t.Fatal("old master key accepted after rotation")
+	}
+	if err = store.SetEncryption(Encryption{}); !errors.Is(err, ErrEncrypted) {
+		t.Fatalf("no master key: got %v, want ErrEncrypted", err)
+	}
+	if err = store.SetEncryption(Encryption{Master: newKey}); err != nil {
+		t.Fatal(err)
+	}
+	m, err := store.GetMessage(-1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Text != "secret" {
+		t.Fatalf("got %q after rotation", m.Text)
+	}
+}
+
+// Открытый файл, который начинается с сигнатуры шифрования, читается как есть
+func TestPlainBlobWithMagic(t *testing.T) {
+	store := openTestStore(t, t.TempDir(), nil)
+	data := append([]byte(sealMagic+"\x01\x04chat"), random(t, 100)...)
+	hash, _, err := store.PutBlob(-1, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := store.OpenBlob(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("plain blob starting with the encryption magic was altered")
+	}
+}
+
+func TestEncryptExisting(t *testing.T) {
+	dir := t.TempDir()
+	store := openTestStore(t, dir, nil)
+	hash, _, err := store.PutBlob(-1, bytes.NewReader(random(t, chunkSize+10)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	orphan, _, err := store.PutBlob(-1, bytes.NewReader(random(t, 10)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = store.PutChat(&Chat{ID: -1, Title: "chat"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.PutMessage(&Message{ChatID: -1, ID: 1, Text: "plain", Media: []Media{{Kind: "photo", Blob: hash}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.PutUser(&User{ID: 1, FirstName: "user"}); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	store = openTestStore(t, dir, testMasterKey(t))
+	counted, err := store.EncryptExisting(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := EncryptReport{Chats: 1, Messages: 1, Users: 1, Blobs: 1, Orphans: 1}
+	if *counted != want {
+		t.Fatalf("dry run: got %+v, want %+v", *counted, want)
+	}
+	if _, err = store.EncryptExisting(false); err != nil {
+		t.Fatal(err)
+	}
+	again, err := store.EncryptExisting(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *again != (EncryptReport{Orphans: 1}) {
+		t.Fatalf("after encryption: got %+v, want only the orphan left", *again)
+	}
+
+	data, err := os.ReadFile(store.messagePath(-1, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plainRecord(data) || bytes.Contains(data, []byte("plain")) {
+		t.Fatal("message is stored in the clear")
+	}
+	if _, err = os.Stat(store.blobPath(hash)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("plain blob left: %v", err)
+	}
+	if _, err = os.Stat(store.blobPath(orphan)); err != nil {
+		t.Fatalf("orphan blob: %v", err)
+	}
+	m, err := store.GetMessage(-1, 1)
+	if err != nil || m.Text != "plain" {
+		t.Fatalf("read message: %+v, %v", m, err)
+	}
+	r, err := store.OpenBlob(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err = io.ReadAll(r); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(filepath.Dir(store.blobPath(hash)), hash+SealedSuffix)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReplaceBlob(t *testing.T) {
+	store := openTestStore(t, t.TempDir(), testMasterKey(t))
+	data := random(t, 100)
+	hash, _, err := store.PutBlob(-1, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := store.blobPath(hash) + SealedSuffix
+	corrupted := []byte("corrupted")
+	if err = os.WriteFile(path, corrupted, 0o640); err != nil {
+		t.Fatal(err)
+	}
+
+	// другое содержимое не заменяет прежний файл
+	if _, err = store.ReplaceBlob(-1, hash, bytes.NewReader(random(t, 100))); err == nil {
+		t.Fatal("blob replaced with different content")
+	}
+	if got, _ := os.ReadFile(path); !bytes.Equal(got, corrupted) {
+		t.Fatal("blob changed after a failed replacement")
+	}
+
+	if _, err = store.ReplaceBlob(-1, hash, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	r, err := store.OpenBlob(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	got, err := io.ReadAll(r)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("replaced blob differs: %v", err)
+	}
+}
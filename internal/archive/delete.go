@@ -0,0 +1,197 @@
+package archive
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DeleteMessage удаляет сообщение из архива и отмечает удаление в цепочке чата.
+// Вложения остаются, их удаляет DeleteBlob
+func (s *Store) DeleteMessage(chatID int64, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.startChain(chatID); err != nil {
+		return fmt.Errorf("start chain: %w", err)
+	}
+	if err := removeFile(s.messagePath(chatID, id)); err != nil {
+		return err
+	}
+	return s.appendChain(chatID, ChainDelete, id, "")
+}
+
+// DeleteChat удаляет описание чата и каталог чата, если в нем не осталось сообщений
+func (s *Store) DeleteChat(chatID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := removeFile(filepath.Join(s.chatDir(chatID), "chat.json")); err != nil {
+		return err
+	}
+	// os.Remove не удаляет непустые каталоги, поэтому сообщения, если они есть, останутся
+	if err := os.Remove(filepath.Join(s.chatDir(chatID), "messages")); err == nil || errors.Is(err, fs.ErrNotExist) {
+		// без сообщений цепочка хранит только хэши удаленных записей. Подписанные вершины
+		// остаются, к ним добавляется отметка об удалении, чтобы проверка отличала удаление
+		// ботом от удаления в обход него
+		if err := s.markChatDeleted(chatID); err != nil {
+			return fmt.Errorf("mark chat deleted: %w", err)
+		}
+		_ = os.Remove(s.chainPath(chatID))
+		delete(s.heads, chatID)
+	}
+	_ = os.Remove(s.chatDir(chatID))
+	return nil
+}
+
+// markChatDeleted дописывает неподписанную отметку об удалении чата, если его вершины
+// подписывались. Вызывается под s.mu
+func (s *Store) markChatDeleted(chatID int64) error {
+	if err := s.migrateCheckpoints(chatID); err != nil {
+		return err
+	}
+	if _, err := os.Stat(s.checkpointsPath(chatID)); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	head, err := s.loadHead(chatID)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return appendLine(s.checkpointsPath(chatID), &Checkpoint{ChatID: chatID, Seq: head.Seq, Hash: head.Hash, Time: time.Now().Unix(), Deleted: true})
+}
+
+// DeleteUser удаляет сохраненный профиль пользователя
+func (s *Store) DeleteUser(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return removeFile(s.userPath(id))
+}
+
+// DeleteBlob удаляет содержимое файла. Вызывающий проверяет, что на него
+// не ссылаются другие сообщения
+func (s *Store) DeleteBlob(hash string) error {
+	if len(hash) < 2 {
+		return ErrNotFound
+	}
+	// после прерванного encrypt-existing могут остаться обе копии
+	sealedErr := removeFile(s.blobPath(hash) + SealedSuffix)
+	plainErr := removeFile(s.blobPath(hash))
+	switch {
+	case sealedErr == nil:
+		return ignoreNotFound(plainErr)
+	case errors.Is(sealedErr, ErrNotFound):
+		return plainErr
+	}
+	return sealedErr
+}
+
+func ignoreNotFound(err error) error {
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// BlobRefs количество сообщений, ссылающихся на каждый сохраненный файл
+func (s *Store) BlobRefs() (map[string]int, error) {
+	chats, err := s.Chats()
+	if err != nil {
+		return nil, err
+	}
+	refs := make(map[string]int)
+	for _, chatID := range chats {
+		err = s.Messages(chatID, func(m *Message) error {
+			for _, media := range m.Media {
+				if media.Blob != "" {
+					refs[media.Blob]++
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return refs, nil
+}
+
+// Blobs обходит сохраненные файлы: sha256, размер и время записи
+func (s *Store) Blobs(fn func(hash string, size int64, modTime time.Time) error) error {
+	root := filepath.Join(s.dir, "blobs")
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// временные файлы недокачанных вложений начинаются с точки
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return fn(strings.TrimSuffix(d.Name(), SealedSuffix), info.Size(), info.ModTime())
+	})
+}
+
+// AppendAudit дописывает запись в журнал <dir>/audit.jsonl
+func (s *Store) AppendAudit(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.OpenFile(filepath.Join(s.dir, "audit.jsonl"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func removeFile(path string) error {
+	err := os.Remove(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// TempFiles обходит временные файлы, оставшиеся после аварийного завершения:
+// недокачанные вложения и недописанные записи
+func (s *Store) TempFiles(fn func(path string, modTime time.Time) error) error {
+	return filepath.WalkDir(s.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !isTempFile(d.Name()) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return fn(path, info.ModTime())
+	})
+}
+
+// RemoveTempFile удаляет временный файл, найденный TempFiles
+func (s *Store) RemoveTempFile(path string) error {
+	rel, err := filepath.Rel(s.dir, path)
+	if err != nil || strings.HasPrefix(rel, "..") || !isTempFile(filepath.Base(path)) {
+		return fmt.Errorf("%s is not a temporary file of the archive", path)
+	}
+	return removeFile(path)
+}
+
+// isTempFile PutBlob пишет во временный .tmp-*, writeFile — в <имя>.tmp
+func isTempFile(name string) bool {
+	return strings.HasPrefix(name, ".tmp-") || strings.HasSuffix(name, ".tmp")
+}
@@ -0,0 +1,3 @@
+package archive
+
+// модель и файловое хранилище архива сообщений
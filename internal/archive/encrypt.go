@@ -0,0 +1,180 @@
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// EncryptReport записи и вложения, сохраненные до включения шифрования
+type EncryptReport struct {
+	Chats    int `json:"chats"`
+	Messages int `json:"messages"`
+	Users    int `json:"users"`
+	Blobs    int `json:"blobs"`
+	// Renamed вложения, зашифрованные прежними версиями без суффикса .enc
+	Renamed int `json:"renamed"`
+	// Orphans незашифрованные вложения без ссылок из сообщений: неизвестно, ключом
+	// какого чата их шифровать. Их удаляет очистка или fsck -repair
+	Orphans int `json:"orphans"`
+}
+
+// EncryptExisting шифрует описания чатов, сообщения, профили и вложения, сохраненные
+// без шифрования. С dryRun только считает их. Цепочки целостности не меняются:
+// они считаются по незашифрованному содержимому
+func (s *Store) EncryptExisting(dryRun bool) (*EncryptReport, error) {
+	if s.keys == nil && !dryRun {
+		return nil, errors.New("archive encryption is not enabled")
+	}
+	report := &EncryptReport{}
+	chats, err := s.Chats()
+	if err != nil {
+		return nil, err
+	}
+	// владелец вложения — первый чат, который на него ссылается
+	owners := make(map[string]int64)
+	for _, chatID := range chats {
+		key := chatKey(chatID)
+		if err = s.encryptRecord(filepath.Join(s.chatDir(chatID), "chat.json"), key, dryRun, &report.Chats); err != nil {
+			return report, err
+		}
+		ids, err := s.MessageIDs(chatID)
+		if err != nil {
+			return report, err
+		}
+		for _, id := range ids {
+			if err = s.encryptRecord(s.messagePath(chatID, id), key, dryRun, &report.Messages); err != nil {
+				return report, err
+			}
+		}
+		err = s.Messages(chatID, func(m *Message) error {
+			for _, media := range m.Media {
+				if _, ok := owners[media.Blob]; media.Blob != "" && !ok {
+					owners[media.Blob] = chatID
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return report, err
+		}
+	}
+
+	users, err := os.ReadDir(filepath.Join(s.dir, "users"))
+	if err != nil {
+		return report, err
+	}
+	for _, e := range users {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		if err = s.encryptRecord(filepath.Join(s.dir, "users", e.Name()), keyUsers, dryRun, &report.Users); err != nil {
+			return report, err
+		}
+	}
+
+	err = filepath.WalkDir(filepath.Join(s.dir, "blobs"), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name := d.Name()
+		if d.IsDir() || strings.HasPrefix(name, ".") || strings.HasSuffix(name, SealedSuffix) {
+			return nil
+		}
+		return s.encryptBlob(path, owners, dryRun, report)
+	})
+	return report, err
+}
+
+// encryptRecord шифрует JSON-запись ключом name, если она хранится открыто
+func (s *Store) encryptRecord(path, name string, dryRun bool, count *int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !plainRecord(data) {
+		return nil
+	}
+	*count++
+	if dryRun {
+		return nil
+	}
+	if err = s.writeSealedData(path, name, data); err != nil {
+		return fmt.Errorf("encrypt %s: %w", path, err)
+	}
+	return nil
+}
+
+// encryptBlob шифрует вложение без суффикса .enc ключом чата-владельца. Файл прежнего
+// формата, который уже зашифрован, только переименовывается: это проверяется расшифровкой
+// целиком, одной сигнатуры в начале файла недостаточно
+func (s *Store) encryptBlob(path string, owners map[string]int64, dryRun bool, report *EncryptReport) error {
+	if s.keys != nil && s.sealedBlob(path) {
+		report.Renamed++
+		if dryRun {
+			return nil
+		}
+		return os.Rename(path, path+SealedSuffix)
+	}
+	owner, ok := owners[filepath.Base(path)]
+	if !ok {
+		report.Orphans++
+		return nil
+	}
+	report.Blobs++
+	if dryRun {
+		return nil
+	}
+
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	tmp, err := os.CreateTemp(filepath.Join(s.dir, "blobs"), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w, err := s.EncryptWriter(chatKey(owner), tmp)
+	if err == nil {
+		_, err = io.Copy(w, src)
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	if err = errors.Join(err, tmp.Close()); err != nil {
+		return fmt.Errorf("encrypt blob %s: %w", filepath.Base(path), err)
+	}
+	// зашифрованная копия появляется раньше, чем удаляется открытая: findBlob предпочитает ее
+	if err = os.Rename(tmp.Name(), path+SealedSuffix); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// sealedBlob файл полностью расшифровывается ключами архива
+func (s *Store) sealedBlob(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	r, err := s.OpenSealed(f)
+	if err != nil {
+		return false
+	}
+	_, err = io.Copy(io.Discard, r)
+	return err == nil
+}
@@ -0,0 +1,160 @@
+package archive
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+// KeyIndex ключ данных снимка поискового индекса
+const KeyIndex = "index"
+
+// keyUsers ключ данных профилей пользователей
+const keyUsers = "users"
+
+// chatKey ключ данных сообщений, описания и вложений чата
+func chatKey(chatID int64) string {
+	return "chat:" + strconv.FormatInt(chatID, 10)
+}
+
+// keyringData содержимое <dir>/keys.json
+type keyringData struct {
+	// Master отпечаток мастер-ключа, которым зашифрованы ключи данных
+	Master string `json:"master"`
+	// Keys ключи данных, зашифрованные мастер-ключом: base64(nonce || шифротекст)
+	Keys map[string]string `json:"keys"`
+}
+
+// keyring ключи данных архива. Ключ создается при первой записи, расшифрованные
+// ключи кэшируются в памяти
+type keyring struct {
+	path   string
+	master *MasterKey
+	mu     sync.Mutex
+	data   keyringData
+	keys   map[string]cipher.AEAD
+}
+
+func loadKeyring(path string, master *MasterKey) (*keyring, error) {
+	k := &keyring{
+		path:   path,
+		master: master,
+		data:   keyringData{Master: master.Fingerprint(), Keys: make(map[string]string)},
+		keys:   make(map[string]cipher.AEAD),
+	}
+	err := readJSON(path, &k.data)
+	if errors.Is(err, ErrNotFound) {
+		return k, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if k.data.Master != master.Fingerprint() {
+		return nil, fmt.Errorf("master key %s does not match data keys encrypted with %s", master.Fingerprint(), k.data.Master)
+	}
+	if k.data.Keys == nil {
+		k.data.Keys = make(map[string]string)
+	}
+	return k, nil
+}
+
+// aead шифр ключа данных name. Отсутствующий ключ создается, только если create
+func (k *keyring) aead(name string, create bool) (cipher.AEAD, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if aead, ok := k.keys[name]; ok {
+		return aead, nil
+	}
+	var key []byte
+	wrapped, ok := k.data.Keys[name]
+	switch {
+	case ok:
+		var err error
+		if key, err = unwrapKey(k.master, name, wrapped); err != nil {
+			return nil, err
+		}
+	case create:
+		key = make([]byte, keySize)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		wrapped, err := wrapKey(k.master, name, key)
+		if err != nil {
+			return nil, err
+		}
+		k.data.Keys[name] = wrapped
+		if err = writeJSON(k.path, &k.data); err != nil {
+			delete(k.data.Keys, name)
+			return nil, fmt.Errorf("save data key: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("data key %q not found", name)
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	k.keys[name] = aead
+	return aead, nil
+}
+
+// rewrap перешифровывает все ключи данных мастер-ключом next
+func (k *keyring) rewrap(next *MasterKey) (int, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	data := keyringData{Master: next.Fingerprint(), Keys: make(map[string]string, len(k.data.Keys))}
+	for name, wrapped := range k.data.Keys {
+		key, err := unwrapKey(k.master, name, wrapped)
+		if err != nil {
+			return 0, err
+		}
+		if data.Keys[name], err = wrapKey(next, name, key); err != nil {
+			return 0, err
+		}
+	}
+	if err := writeJSON(k.path, &data); err != nil {
+		return 0, fmt.Errorf("save data keys: %w", err)
+	}
+	k.master = next
+	k.data = data
+	return len(data.Keys), nil
+}
+
+// wrapKey шифрует ключ данных мастер-ключом, имя ключа проверяется при расшифровке
+func wrapKey(master *MasterKey, name string, key []byte) (string, error) {
+	aead, err := newAEAD(master.key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, key, []byte(name))), nil
+}
+
+func unwrapKey(master *MasterKey, name, wrapped string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("data key %q: %w", name, err)
+	}
+	aead, err := newAEAD(master.key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, fmt.Errorf("data key %q is corrupted", name)
+	}
+	key, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(name))
+	if err != nil {
+		return nil, fmt.Errorf("decrypt data key %q: %w", name, err)
+	}
+	return key, nil
+}
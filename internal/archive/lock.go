@@ -0,0 +1,47 @@
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ErrLocked хранилище открыто другим процессом
+var ErrLocked = errors.New("archive is locked by another process")
+
+// lockDir берет блокировку файла <dir>/lock и записывает в него pid процесса
+func lockDir(dir string) (*os.File, error) {
+	f, err := os.OpenFile(filepath.Join(dir, "lock"), os.O_CREATE|os.O_RDWR, 0o640)
+	if err != nil {
+		return nil, fmt.Errorf("open lock file: %w", err)
+	}
+	if err = lockFile(f); err != nil {
+		pid, _ := io.ReadAll(f)
+		f.Close()
+		if errors.Is(err, ErrLocked) {
+			return nil, fmt.Errorf("%w (pid %s)", ErrLocked, strings.TrimSpace(string(pid)))
+		}
+		return nil, fmt.Errorf("lock archive: %w", err)
+	}
+
+	if err = f.Truncate(0); err == nil {
+		_, err = f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	if err != nil {
+		unlockDir(f)
+		return nil, fmt.Errorf("write lock file: %w", err)
+	}
+	return f, nil
+}
+
+func unlockDir(f *os.File) error {
+	if err := unlockFile(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
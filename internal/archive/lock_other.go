@@ -0,0 +1,14 @@
+//go:build windows || plan9
+
+package archive
+
+import "os"
+
+// на этих платформах блокировка не поддерживается, запуск второго процесса не обнаруживается
+func lockFile(*os.File) error {
+	return nil
+}
+
+func unlockFile(*os.File) error {
+	return nil
+}
@@ -0,0 +1,136 @@
+package archive
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"tg-archive-bot/internal/metrics"
+)
+
+// usageTTL как долго используется посчитанный размер чатов: подсчет читает все сообщения,
+// чтобы учесть вложения
+const usageTTL = 10 * time.Minute
+
+var writeDuration = metrics.NewHistogramVec("tgarchive_storage_write_duration_seconds",
+	"Длительность записи в хранилище: json для записей, blob для вложений", metrics.DefBuckets, "kind")
+
+// chatUsage размер чата в архиве
+type chatUsage struct {
+	messages int
+	bytes    int64
+}
+
+// usageCache последний подсчет размера чатов открытых хранилищ
+var usageCache struct {
+	mu      sync.Mutex
+	stores  map[*Store]bool
+	updated time.Time
+	// chats размер чатов по имени хранилища
+	chats map[string]map[int64]chatUsage
+}
+
+func init() {
+	metrics.NewGaugeFunc("tgarchive_chat_messages", "Количество сообщений чата в архиве", []string{"bot", "chat_id"},
+		func(set func(v float64, values ...string)) {
+			for name, chats := range usage() {
+				for id, u := range chats {
+					set(float64(u.messages), name, strconv.FormatInt(id, 10))
+				}
+			}
+		})
+	metrics.NewGaugeFunc("tgarchive_chat_bytes",
+		"Размер чата в архиве: записи сообщений и файлы вложений. Вложение, общее для нескольких чатов, учитывается в каждом",
+		[]string{"bot", "chat_id"},
+		func(set func(v float64, values ...string)) {
+			for name, chats := range usage() {
+				for id, u := range chats {
+					set(float64(u.bytes), name, strconv.FormatInt(id, 10))
+				}
+			}
+		})
+}
+
+// registerMetrics включает хранилище в метрики размера чатов до вызова Close
+func (s *Store) registerMetrics() {
+	usageCache.mu.Lock()
+	defer usageCache.mu.Unlock()
+	if usageCache.stores == nil {
+		usageCache.stores = make(map[*Store]bool)
+	}
+	usageCache.stores[s] = true
+	usageCache.updated = time.Time{}
+}
+
+func (s *Store) unregisterMetrics() {
+	usageCache.mu.Lock()
+	defer usageCache.mu.Unlock()
+	delete(usageCache.stores, s)
+	usageCache.updated = time.Time{}
+}
+
+// SetName задает значение метки bot в метриках хранилища, по умолчанию это имя каталога
+func (s *Store) SetName(name string) {
+	usageCache.mu.Lock()
+	defer usageCache.mu.Unlock()
+	s.name = name
+	usageCache.updated = time.Time{}
+}
+
+// usage размер чатов по именам хранилищ
+func usage() map[string]map[int64]chatUsage {
+	usageCache.mu.Lock()
+	defer usageCache.mu.Unlock()
+	if time.Since(usageCache.updated) < usageTTL {
+		return usageCache.chats
+	}
+	all := make(map[string]map[int64]chatUsage, len(usageCache.stores))
+	for s := range usageCache.stores {
+		chats := make(map[int64]chatUsage)
+		ids, _ := s.Chats()
+		for _, id := range ids {
+			count, size, err := s.ChatUsage(id)
+			if err != nil {
+				continue
+			}
+			media, err := s.chatMediaSize(id)
+			if err != nil {
+				continue
+			}
+			chats[id] = chatUsage{messages: count, bytes: size + media}
+		}
+		name := s.name
+		if name == "" {
+			name = filepath.Base(s.dir)
+		}
+		all[name] = chats
+	}
+	usageCache.chats, usageCache.updated = all, time.Now()
+	return all
+}
+
+// chatMediaSize размер на диске файлов вложений, на которые ссылаются сообщения чата
+func (s *Store) chatMediaSize(chatID int64) (int64, error) {
+	blobs := make(map[string]bool)
+	err := s.Messages(chatID, func(m *Message) error {
+		for _, media := range m.Media {
+			if media.Blob != "" {
+				blobs[media.Blob] = true
+			}
+		}
+		return nil
+	})
+	var size int64
+	for hash := range blobs {
+		path, _, err := s.findBlob(hash)
+		if err != nil {
+			continue
+		}
+		if info, err := os.Stat(path); err == nil {
+			size += info.Size()
+		}
+	}
+	return size, err
+}
@@ -0,0 +1,98 @@
+package archive
+
+// Source происхождение записи в архиве
+type Source string
+
+const (
+	// SourceLive запись получена ботом в реальном времени
+	SourceLive Source = "live"
+	// SourceImport запись импортирована из экспорта Telegram Desktop
+	SourceImport Source = "import"
+)
+
+// MediaKind тип вложения
+type MediaKind string
+
+const (
+	MediaPhoto     MediaKind = "photo"
+	MediaVideo     MediaKind = "video"
+	MediaVideoNote MediaKind = "video_note"
+	MediaVoice     MediaKind = "voice"
+	MediaAudio     MediaKind = "audio"
+	MediaAnimation MediaKind = "animation"
+	MediaSticker   MediaKind = "sticker"
+	MediaDocument  MediaKind = "document"
+)
+
+// Chat чат, сообщения которого архивируются
+type Chat struct {
+	ID       int64  `json:"id"`
+	Type     string `json:"type"`
+	Title    string `json:"title,omitempty"`
+	Username string `json:"username,omitempty"`
+}
+
+// User автор сообщений
+type User struct {
+	ID           int64  `json:"id"`
+	IsBot        bool   `json:"is_bot,omitempty"`
+	FirstName    string `json:"first_name,omitempty"`
+	LastName     string `json:"last_name,omitempty"`
+	Username     string `json:"username,omitempty"`
+	LanguageCode string `json:"language_code,omitempty"`
+	Source       Source `json:"source"`
+	UpdatedAt    int64  `json:"updated_at"`
+}
+
+// Entity сущность в тексте сообщения (ссылка, упоминание, хэштег...)
+type Entity struct {
+	Type   string `json:"type"`
+	Text   string `json:"text"`
+	URL    string `json:"url,omitempty"`
+	UserID int64  `json:"user_id,omitempty"`
+}
+
+// Media вложение сообщения
+type Media struct {
+	Kind         MediaKind `json:"kind"`
+	FileID       string    `json:"file_id,omitempty"`
+	FileUniqueID string    `json:"file_unique_id,omitempty"`
+	FileName     string    `json:"file_name,omitempty"`
+	MimeType     string    `json:"mime_type,omitempty"`
+	Size         int64     `json:"size,omitempty"`
+	// Blob sha256 содержимого в хранилище, пусто пока файл не скачан
+	Blob string `json:"blob,omitempty"`
+	// Pruned файл удален по сроку хранения и больше не скачивается
+	Pruned bool `json:"pruned,omitempty"`
+}
+
+// Reaction количество реакций одного вида. Для пользовательских эмодзи
+// Emoji имеет вид custom:<custom_emoji_id>
+type Reaction struct {
+	Emoji string `json:"emoji"`
+	Count int    `json:"count"`
+}
+
+// Message архивная запись сообщения
+type Message struct {
+	ChatID      int64      `json:"chat_id"`
+	ID          int        `json:"id"`
+	ThreadID    int        `json:"thread_id,omitempty"`
+	FromID      int64      `json:"from_id,omitempty"`
+	FromName    string     `json:"from_name,omitempty"`
+	Date        int64      `json:"date"`
+	EditDate    int64      `json:"edit_date,omitempty"`
+	ReplyToID   int        `json:"reply_to_id,omitempty"`
+	ForwardFrom string     `json:"forward_from,omitempty"`
+	Text        string     `json:"text,omitempty"`
+	Entities    []Entity   `json:"entities,omitempty"`
+	Media       []Media    `json:"media,omitempty"`
+	Reactions   []Reaction `json:"reactions,omitempty"`
+	// Deleted сообщение удалено в Telegram, но сохранено в архиве
+	Deleted    bool   `json:"deleted,omitempty"`
+	Source     Source `json:"source"`
+	ArchivedAt int64  `json:"archived_at"`
+	// ExportID номер сообщения в экспорте Telegram Desktop, из которого оно импортировано.
+	// В обычных группах не совпадает с ID
+	ExportID int `json:"export_id,omitempty"`
+}
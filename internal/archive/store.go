@@ -0,0 +1,446 @@
+package archive
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotFound запись отсутствует в архиве
+var ErrNotFound = errors.New("not found")
+
+// Store файловое хранилище архива
+//
+//	<dir>/chats/<chat_id>/chat.json
+//	<dir>/chats/<chat_id>/messages/<message_id>.json
+//	<dir>/chats/<chat_id>/chain.jsonl
+//	<dir>/checkpoints/<chat_id>.jsonl
+//	<dir>/users/<user_id>.json
+//	<dir>/blobs/<sha256[:2]>/<sha256>[.enc]
+//	<dir>/checkpoint.json
+//	<dir>/state/<name>.json
+//	<dir>/audit.jsonl
+//	<dir>/keys.json
+//	<dir>/lock
+//
+// С шифрованием описания чатов, сообщения, профили и вложения хранятся зашифрованными
+// ключами данных из keys.json: свой ключ у каждого чата и общий для профилей
+type Store struct {
+	dir  string
+	name string
+	mu   sync.Mutex
+	lock *os.File
+
+	keys       *keyring
+	clearIndex bool
+	// heads последние записи цепочек чатов
+	heads map[int64]ChainEntry
+}
+
+// Open открывает хранилище в каталоге dir, создавая его при необходимости.
+// Хранилище открывается монопольно, второй процесс получит ErrLocked
+func Open(dir string) (*Store, error) {
+	for _, sub := range []string{"chats", "users", "blobs"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o750); err != nil {
+			return nil, fmt.Errorf("create archive dir: %w", err)
+		}
+	}
+	lock, err := lockDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{dir: dir, lock: lock, heads: make(map[int64]ChainEntry)}
+	s.registerMetrics()
+	return s, nil
+}
+
+// Close снимает блокировку хранилища
+func (s *Store) Close() error {
+	if s.lock == nil {
+		return nil
+	}
+	s.unregisterMetrics()
+	err := unlockDir(s.lock)
+	s.lock = nil
+	return err
+}
+
+// Dir корневой каталог хранилища
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+func (s *Store) chatDir(chatID int64) string {
+	return filepath.Join(s.dir, "chats", strconv.FormatInt(chatID, 10))
+}
+
+func (s *Store) messagePath(chatID int64, id int) string {
+	return filepath.Join(s.chatDir(chatID), "messages", strconv.Itoa(id)+".json")
+}
+
+func (s *Store) userPath(id int64) string {
+	return filepath.Join(s.dir, "users", strconv.FormatInt(id, 10)+".json")
+}
+
+func (s *Store) blobPath(hash string) string {
+	return filepath.Join(s.dir, "blobs", hash[:2], hash)
+}
+
+// findBlob путь к сохраненному файлу и зашифрован ли он, ErrNotFound если файла нет
+func (s *Store) findBlob(hash string) (string, bool, error) {
+	if len(hash) < 2 {
+		return "", false, ErrNotFound
+	}
+	for _, sealed := range []bool{true, false} {
+		path := s.blobPath(hash)
+		if sealed {
+			path += SealedSuffix
+		}
+		_, err := os.Stat(path)
+		if err == nil {
+			return path, sealed, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", false, err
+		}
+	}
+	return "", false, ErrNotFound
+}
+
+// PutChat сохраняет описание чата
+func (s *Store) PutChat(c *Chat) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.writeSealed(filepath.Join(s.chatDir(c.ID), "chat.json"), chatKey(c.ID), c)
+}
+
+// GetChat возвращает описание чата
+func (s *Store) GetChat(id int64) (*Chat, error) {
+	var c Chat
+	if err := s.readSealed(filepath.Join(s.chatDir(id), "chat.json"), &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Chats идентификаторы всех чатов в архиве
+func (s *Store) Chats() ([]int64, error) {
+	entries, err := os.ReadDir(filepath.Join(s.dir, "chats"))
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int64, 0, len(entries))
+	for _, e := range entries {
+		id, err := strconv.ParseInt(e.Name(), 10, 64)
+		if err != nil || !e.IsDir() {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+// checkpoint последнее обработанное обновление бота
+type checkpoint struct {
+	UpdateID int `json:"update_id"`
+}
+
+// Checkpoint идентификатор последнего обработанного обновления, 0 если его нет
+func (s *Store) Checkpoint() (int, error) {
+	var c checkpoint
+	err := readJSON(filepath.Join(s.dir, "checkpoint.json"), &c)
+	if errors.Is(err, ErrNotFound) {
+		return 0, nil
+	}
+	return c.UpdateID, err
+}
+
+// SaveCheckpoint запоминает последнее обработанное обновление
+func (s *Store) SaveCheckpoint(updateID int) error {
+	return writeJSON(filepath.Join(s.dir, "checkpoint.json"), checkpoint{UpdateID: updateID})
+}
+
+// GetState читает служебное состояние бота name, ErrNotFound если его еще нет
+func (s *Store) GetState(name string, v any) error {
+	return readJSON(filepath.Join(s.dir, "state", name+".json"), v)
+}
+
+// PutState сохраняет служебное состояние бота name
+func (s *Store) PutState(name string, v any) error {
+	return writeJSON(filepath.Join(s.dir, "state", name+".json"), v)
+}
+
+// ChatUsage количество сообщений чата и размер их записей на диске без вложений
+func (s *Store) ChatUsage(chatID int64) (int, int64, error) {
+	entries, err := os.ReadDir(filepath.Join(s.chatDir(chatID), "messages"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	var count int
+	var size int64
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		count++
+		size += info.Size()
+	}
+	return count, size, nil
+}
+
+// PutUser сохраняет пользователя, перезаписывая предыдущую версию
+func (s *Store) PutUser(u *User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.writeSealed(s.userPath(u.ID), keyUsers, u)
+}
+
+// GetUser возвращает пользователя
+func (s *Store) GetUser(id int64) (*User, error) {
+	var u User
+	if err := s.readSealed(s.userPath(id), &u); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// PutMessage сохраняет сообщение, перезаписывая предыдущую версию
+func (s *Store) PutMessage(m *Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.writeMessage(m)
+}
+
+// AddMessage сохраняет сообщение, только если его ещё нет в архиве.
+// Возвращает false, если сообщение уже было сохранено ранее
+func (s *Store) AddMessage(m *Message) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := os.Stat(s.messagePath(m.ChatID, m.ID))
+	if err == nil {
+		return false, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return false, err
+	}
+	return true, s.writeMessage(m)
+}
+
+// UpdateMessage атомарно изменяет сохраненное сообщение
+func (s *Store) UpdateMessage(chatID int64, id int, fn func(m *Message) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var m Message
+	if err := s.readSealed(s.messagePath(chatID, id), &m); err != nil {
+		return err
+	}
+	if err := fn(&m); err != nil {
+		return err
+	}
+	return s.writeMessage(&m)
+}
+
+// GetMessage возвращает сообщение
+func (s *Store) GetMessage(chatID int64, id int) (*Message, error) {
+	var m Message
+	if err := s.readSealed(s.messagePath(chatID, id), &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// Messages обходит сообщения чата в порядке возрастания идентификаторов
+func (s *Store) Messages(chatID int64, fn func(m *Message) error) error {
+	ids, err := s.MessageIDs(chatID)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		m, err := s.GetMessage(chatID, id)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err = fn(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PutBlob сохраняет содержимое файла из чата chatID и возвращает sha256 содержимого
+// и его размер. Одинаковые файлы хранятся в одном экземпляре, зашифрованном ключом
+// чата, который сохранил файл первым
+func (s *Store) PutBlob(chatID int64, r io.Reader) (string, int64, error) {
+	defer writeDuration.Since(time.Now(), "blob")
+
+	tmp, hash, size, err := s.writeBlobTemp(chatID, r)
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(tmp)
+
+	if _, _, err = s.findBlob(hash); err == nil {
+		return hash, size, nil
+	}
+	if err = s.placeBlob(chatID, hash, tmp); err != nil {
+		return "", 0, err
+	}
+	return hash, size, nil
+}
+
+// ReplaceBlob заменяет испорченный или потерянный файл hash содержимым из r. Файл
+// заменяется, только если sha256 содержимого совпадает с hash, иначе прежний остается
+func (s *Store) ReplaceBlob(chatID int64, hash string, r io.Reader) (int64, error) {
+	defer writeDuration.Since(time.Now(), "blob")
+
+	tmp, sum, size, err := s.writeBlobTemp(chatID, r)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp)
+
+	if sum != hash {
+		return 0, fmt.Errorf("content hash is %s, want %s", sum, hash)
+	}
+	// новый файл занимает место прежнего одним переименованием, вариант с другим
+	// суффиксом удаляется после, иначе findBlob может найти его первым
+	if err = s.placeBlob(chatID, hash, tmp); err != nil {
+		return 0, err
+	}
+	other := s.blobPath(hash)
+	if !s.Sealed(chatKey(chatID)) {
+		other += SealedSuffix
+	}
+	if err = ignoreNotFound(removeFile(other)); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// writeBlobTemp записывает содержимое во временный файл каталога blobs, зашифрованное
+// ключом чата, и возвращает его путь, sha256 и размер содержимого
+func (s *Store) writeBlobTemp(chatID int64, r io.Reader) (string, string, int64, error) {
+	tmp, err := os.CreateTemp(filepath.Join(s.dir, "blobs"), ".tmp-*")
+	if err != nil {
+		return "", "", 0, err
+	}
+
+	h := sha256.New()
+	w, err := s.EncryptWriter(chatKey(chatID), tmp)
+	var size int64
+	if err == nil {
+		size, err = io.Copy(io.MultiWriter(w, h), r)
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	if err = errors.Join(err, tmp.Close()); err != nil {
+		os.Remove(tmp.Name())
+		return "", "", 0, err
+	}
+	return tmp.Name(), hex.EncodeToString(h.Sum(nil)), size, nil
+}
+
+// placeBlob переносит временный файл на место файла hash
+func (s *Store) placeBlob(chatID int64, hash, tmp string) error {
+	path := s.blobPath(hash)
+	if s.Sealed(chatKey(chatID)) {
+		path += SealedSuffix
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// OpenBlob открывает сохраненный файл по его sha256, зашифрованный файл расшифровывается при чтении
+func (s *Store) OpenBlob(hash string) (io.ReadCloser, error) {
+	path, sealed, err := s.findBlob(hash)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !sealed {
+		return f, nil
+	}
+	r, err := s.OpenSealed(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("decrypt blob %s: %w", hash, err)
+	}
+	return readCloser{Reader: r, Closer: f}, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+func readJSON(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("decode %s: %w", path, err)
+	}
+	return nil
+}
+
+// writeJSON атомарно записывает v в path через временный файл
+func writeJSON(path string, v any) error {
+	defer writeDuration.Since(time.Now(), "json")
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return writeFile(path, data)
+}
+
+// writeFile атомарно записывает data в path через временный файл
+func writeFile(path string, data []byte) error {
+	err := os.MkdirAll(filepath.Dir(path), 0o750)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0o640); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,116 @@
+package archiver
+
+import (
+	"context"
+	"errors"
+
+	"tg-archive-bot/internal/access"
+	"tg-archive-bot/internal/archive"
+	"tg-archive-bot/internal/log"
+	"tg-archive-bot/internal/search"
+
+	"github.com/mymmrac/telego"
+	"go.uber.org/zap"
+)
+
+// Archiver сохраняет сообщения из обновлений в архив
+type Archiver struct {
+	store      *archive.Store
+	index      *search.Index
+	downloader *Downloader
+	archiving  *access.Archiving
+}
+
+// New создает архиватор, вложения скачиваются через downloader, если в настройках
+// чата не выбрано сохранение только текста
+func New(store *archive.Store, index *search.Index, downloader *Downloader, archiving *access.Archiving) *Archiver {
+	return &Archiver{
+		store:      store,
+		index:      index,
+		downloader: downloader,
+		archiving:  archiving,
+	}
+}
+
+// HandleUpdate сохраняет новые и отредактированные сообщения, реакции и отметки об удалении
+func (a *Archiver) HandleUpdate(ctx context.Context, update telego.Update) {
+	var msg *telego.Message
+	switch {
+	case update.Message != nil:
+		msg = update.Message
+	case update.EditedMessage != nil:
+		msg = update.EditedMessage
+	case update.ChannelPost != nil:
+		msg = update.ChannelPost
+	case update.EditedChannelPost != nil:
+		msg = update.EditedChannelPost
+	case update.BusinessMessage != nil:
+		msg = update.BusinessMessage
+	case update.EditedBusinessMessage != nil:
+		msg = update.EditedBusinessMessage
+	case update.DeletedBusinessMessages != nil:
+		a.markDeleted(ctx, update.DeletedBusinessMessages)
+		return
+	case update.MessageReactionCount != nil:
+		a.setReactions(ctx, update.MessageReactionCount)
+		return
+	case update.MessageReaction != nil:
+		a.changeReactions(ctx, update.MessageReaction)
+		return
+	default:
+		return
+	}
+
+	if err := a.saveMessage(ctx, msg); err != nil {
+		log.FromContext(ctx).Error("save message", zap.Error(err))
+	}
+}
+
+func (a *Archiver) saveMessage(ctx context.Context, msg *telego.Message) error {
+	m := convertMessage(msg)
+	if m == nil {
+		return nil
+	}
+
+	if err := a.store.PutChat(convertChat(&msg.Chat)); err != nil {
+		return err
+	}
+	if msg.From != nil {
+		if err := a.store.PutUser(convertUser(msg.From)); err != nil {
+			return err
+		}
+	}
+
+	// при редактировании сохраняем уже скачанные вложения и реакции
+	err := a.store.UpdateMessage(m.ChatID, m.ID, func(old *archive.Message) error {
+		keepArchived(m, old)
+		*old = *m
+		return nil
+	})
+	if errors.Is(err, archive.ErrNotFound) {
+		err = a.store.PutMessage(m)
+	}
+	if err != nil {
+		return err
+	}
+	if err = a.index.Update(m); err != nil {
+		return err
+	}
+
+	if !a.archiving.Get(m.ChatID).TextOnly {
+		a.downloader.Enqueue(ctx, m)
+	}
+	return nil
+}
+
+func keepArchived(m, old *archive.Message) {
+	m.Reactions = old.Reactions
+	for i := range m.Media {
+		for _, o := range old.Media {
+			if o.FileUniqueID == m.Media[i].FileUniqueID {
+				m.Media[i].Blob = o.Blob
+				m.Media[i].Pruned = o.Pruned
+			}
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package archiver
+
+import (
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"tg-archive-bot/internal/archive"
+
+	"github.com/mymmrac/telego"
+)
+
+// convertMessage переводит сообщение Bot API в архивную запись.
+// Возвращает nil для сообщений без текста и вложений (служебные, опросы и т.п.)
+func convertMessage(msg *telego.Message) *archive.Message {
+	m := &archive.Message{
+		ChatID:     msg.Chat.ID,
+		ID:         msg.MessageID,
+		Date:       msg.Date,
+		EditDate:   msg.EditDate,
+		Source:     archive.SourceLive,
+		ArchivedAt: time.Now().Unix(),
+	}
+	if msg.IsTopicMessage {
+		m.ThreadID = msg.MessageThreadID
+	}
+
+	switch {
+	case msg.From != nil:
+		m.FromID = msg.From.ID
+		m.FromName = userName(msg.From)
+	case msg.SenderChat != nil:
+		m.FromID = msg.SenderChat.ID
+		m.FromName = msg.SenderChat.Title
+	}
+
+	if msg.ReplyToMessage != nil {
+		m.ReplyToID = msg.ReplyToMessage.MessageID
+	}
+	m.ForwardFrom = forwardName(msg.ForwardOrigin)
+
+	if msg.Text != "" {
+		m.Text = msg.Text
+		m.Entities = convertEntities(msg.Text, msg.Entities)
+	} else {
+		m.Text = msg.Caption
+		m.Entities = convertEntities(msg.Caption, msg.CaptionEntities)
+	}
+	m.Media = convertMedia(msg)
+
+	if m.Text == "" && len(m.Media) == 0 {
+		return nil
+	}
+	return m
+}
+
+func convertChat(c *telego.Chat) *archive.Chat {
+	title := c.Title
+	if title == "" {
+		title = strings.TrimSpace(c.FirstName + " " + c.LastName)
+	}
+	return &archive.Chat{
+		ID:       c.ID,
+		Type:     c.Type,
+		Title:    title,
+		Username: c.Username,
+	}
+}
+
+func convertUser(u *telego.User) *archive.User {
+	return &archive.User{
+		ID:           u.ID,
+		IsBot:        u.IsBot,
+		FirstName:    u.FirstName,
+		LastName:     u.LastName,
+		Username:     u.Username,
+		LanguageCode: u.LanguageCode,
+		Source:       archive.SourceLive,
+		UpdatedAt:    time.Now().Unix(),
+	}
+}
+
+func userName(u *telego.User) string {
+	return strings.TrimSpace(u.FirstName + " " + u.LastName)
+}
+
+func forwardName(origin telego.MessageOrigin) string {
+	switch o := origin.(type) {
+	case *telego.MessageOriginUser:
+		return userName(&o.SenderUser)
+	case *telego.MessageOriginHiddenUser:
+		return o.SenderUserName
+	case *telego.MessageOriginChat:
+		return o.SenderChat.Title
+	case *telego.MessageOriginChannel:
+		return o.Chat.Title
+	}
+	return ""
+}
+
+// convertEntities вырезает текст сущностей, смещения в Bot API заданы в UTF-16
+func convertEntities(text string, entities []telego.MessageEntity) []archive.Entity {
+	if len(entities) == 0 {
+		return nil
+	}
+
+	units := utf16.Encode([]rune(text))
+	result := make([]archive.Entity, 0, len(entities))
+	for _, e := range entities {
+		if e.Offset < 0 || e.Length < 0 || e.Offset+e.Length > len(units) {
+			continue
+		}
+		entity := archive.Entity{
+			Type: e.Type,
+			Text: string(utf16.Decode(units[e.Offset : e.Offset+e.Length])),
+			URL:  e.URL,
+		}
+		if e.User != nil {
+			entity.UserID = e.User.ID
+		}
+		result = append(result, entity)
+	}
+	return result
+}
+
+func convertMedia(msg *telego.Message) []archive.Media {
+	switch {
+	case len(msg.Photo) > 0:
+		// последний размер самый большой
+		p := msg.Photo[len(msg.Photo)-1]
+		return []archive.Media{{
+			Kind: archive.MediaPhoto, FileID: p.FileID, FileUniqueID: p.FileUniqueID, Size: int64(p.FileSize),
+		}}
+	case msg.Video != nil:
+		v := msg.Video
+		return []archive.Media{{
+			Kind: archive.MediaVideo, FileID: v.FileID, FileUniqueID: v.FileUniqueID,
+			FileName: v.FileName, MimeType: v.MimeType, Size: v.FileSize,
+		}}
+	case msg.VideoNote != nil:
+		v := msg.VideoNote
+		return []archive.Media{{
+			Kind: archive.MediaVideoNote, FileID: v.FileID, FileUniqueID: v.FileUniqueID, Size: int64(v.FileSize),
+		}}
+	case msg.Voice != nil:
+		v := msg.Voice
+		return []archive.Media{{
+			Kind: archive.MediaVoice, FileID: v.FileID, FileUniqueID: v.FileUniqueID,
+			MimeType: v.MimeType, Size: v.FileSize,
+		}}
+	case msg.Audio != nil:
+		a := msg.Audio
+		return []archive.Media{{
+			Kind: archive.MediaAudio, FileID: a.FileID, FileUniqueID: a.FileUniqueID,
+			FileName: a.FileName, MimeType: a.MimeType, Size: a.FileSize,
+		}}
+	case msg.Animation != nil:
+		a := msg.Animation
+		return []archive.Media{{
+			Kind: archive.MediaAnimation, FileID: a.FileID, FileUniqueID: a.FileUniqueID,
+			FileName: a.FileName, MimeType: a.MimeType, Size: a.FileSize,
+		}}
+	case msg.Sticker != nil:
+		s := msg.Sticker
+		return []archive.Media{{
+			Kind: archive.MediaSticker, FileID: s.FileID, FileUniqueID: s.FileUniqueID, Size: int64(s.FileSize),
+		}}
+	case msg.Document != nil:
+		d := msg.Document
+		return []archive.Media{{
+			Kind: archive.MediaDocument, FileID: d.FileID, FileUniqueID: d.FileUniqueID,
+			FileName: d.FileName, MimeType: d.MimeType, Size: d.FileSize,
+		}}
+	}
+	return nil
+}
@@ -0,0 +1,3 @@
+package archiver
+
+// сохранение входящих обновлений бота в архив
@@ -0,0 +1,193 @@
+package archiver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"tg-archive-bot/internal/archive"
+	"tg-archive-bot/internal/log"
+	"tg-archive-bot/internal/metrics"
+
+	"github.com/mymmrac/telego"
+	"go.uber.org/zap"
+)
+
+// MaxDownloadSize ограничение Bot API на размер скачиваемого файла
+const MaxDownloadSize = 20 << 20
+
+var (
+	downloadedBytes = metrics.NewCounterVec("tgarchive_media_downloaded_bytes_total",
+		"Объем скачанных вложений", "bot")
+	downloadFailures = metrics.NewCounterVec("tgarchive_media_download_failures_total",
+		"Ошибки скачивания вложений по этапу: get_file, http, store, too_big, queue_full", "bot", "stage")
+	queueDepth = metrics.NewGaugeVec("tgarchive_download_queue_depth",
+		"Вложения в очереди на скачивание", "bot")
+)
+
+// downloadJob задание на скачивание вложения сообщения
+type downloadJob struct {
+	ChatID       int64
+	MessageID    int
+	FileID       string
+	FileUniqueID string
+	// Replace sha256 испорченного или потерянного файла, который заменяется скачанным
+	Replace string
+	log     *zap.Logger
+}
+
+// Downloader очередь скачивания вложений в хранилище
+type Downloader struct {
+	name   string
+	bot    *telego.Bot
+	store  *archive.Store
+	client *http.Client
+	jobs   chan downloadJob
+	wg     sync.WaitGroup
+}
+
+// NewDownloader запускает workers обработчиков очереди размером queueSize,
+// name значение метки bot в метриках
+func NewDownloader(name string, bot *telego.Bot, store *archive.Store, workers, queueSize int) *Downloader {
+	d := &Downloader{
+		name:   name,
+		bot:    bot,
+		store:  store,
+		client: &http.Client{Timeout: 5 * time.Minute},
+		jobs:   make(chan downloadJob, queueSize),
+	}
+	for i := 0; i < workers; i++ {
+		d.wg.Add(1)
+		go d.worker()
+	}
+	return d
+}
+
+// Enqueue ставит в очередь вложения сообщения, которые ещё не скачаны, и возвращает
+// число поставленных заданий. Задания пишут в лог логгером из ctx. Enqueue не блокируется:
+// при полной очереди задание отбрасывается, а вложение потом находит и скачивает fsck -repair
+func (d *Downloader) Enqueue(ctx context.Context, m *archive.Message) int {
+	logger := log.FromContext(ctx)
+	var n int
+	for _, media := range m.Media {
+		if media.Blob != "" || media.FileID == "" || media.Pruned {
+			continue
+		}
+		if d.push(logger, m, media, "") {
+			n++
+		}
+	}
+	return n
+}
+
+// Replace ставит в очередь повторное скачивание вложения hash сообщения. Сохраненный
+// файл заменяется, только когда скачанное содержимое совпадает с hash: до этого
+// он остается на месте, а запись сообщения не меняется
+func (d *Downloader) Replace(ctx context.Context, m *archive.Message, hash string) bool {
+	for _, media := range m.Media {
+		if media.Blob == hash && media.FileID != "" {
+			return d.push(log.FromContext(ctx), m, media, hash)
+		}
+	}
+	return false
+}
+
+// push ставит задание в очередь, false если файл слишком большой или очередь заполнена
+func (d *Downloader) push(logger *zap.Logger, m *archive.Message, media archive.Media, replace string) bool {
+	if media.Size > MaxDownloadSize {
+		logger.Warn("file is too big to download", zap.Int64("size", media.Size))
+		downloadFailures.Inc(d.name, "too_big")
+		return false
+	}
+	job := downloadJob{
+		ChatID:       m.ChatID,
+		MessageID:    m.ID,
+		FileID:       media.FileID,
+		FileUniqueID: media.FileUniqueID,
+		Replace:      replace,
+		log:          logger,
+	}
+	select {
+	case d.jobs <- job:
+	default:
+		logger.Warn("download queue is full, file skipped", zap.String("file_unique_id", media.FileUniqueID))
+		downloadFailures.Inc(d.name, "queue_full")
+		return false
+	}
+	queueDepth.Set(float64(d.Len()), d.name)
+	return true
+}
+
+// Len количество заданий в очереди
+func (d *Downloader) Len() int {
+	return len(d.jobs)
+}
+
+// Cap размер очереди
+func (d *Downloader) Cap() int {
+	return cap(d.jobs)
+}
+
+// Stop дожидается обработки уже поставленных заданий
+func (d *Downloader) Stop() {
+	close(d.jobs)
+	d.wg.Wait()
+}
+
+func (d *Downloader) worker() {
+	defer d.wg.Done()
+	for job := range d.jobs {
+		queueDepth.Set(float64(d.Len()), d.name)
+		if err := d.download(job); err != nil {
+			job.log.Error("download failed", zap.String("file_unique_id", job.FileUniqueID), zap.Error(err))
+		}
+	}
+}
+
+func (d *Downloader) download(job downloadJob) error {
+	file, err := d.bot.GetFile(&telego.GetFileParams{FileID: job.FileID})
+	if err != nil {
+		downloadFailures.Inc(d.name, "get_file")
+		return fmt.Errorf("get file: %w", err)
+	}
+
+	resp, err := d.client.Get(d.bot.FileDownloadURL(file.FilePath))
+	if err != nil {
+		downloadFailures.Inc(d.name, "http")
+		// в тексте ошибки url с токеном бота
+		return fmt.Errorf("download file %s: request failed", file.FilePath)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		downloadFailures.Inc(d.name, "http")
+		return fmt.Errorf("download file %s: status %d", file.FilePath, resp.StatusCode)
+	}
+
+	if job.Replace != "" {
+		size, err := d.store.ReplaceBlob(job.ChatID, job.Replace, resp.Body)
+		if err != nil {
+			downloadFailures.Inc(d.name, "store")
+			return fmt.Errorf("replace blob %s: %w", job.Replace, err)
+		}
+		downloadedBytes.Add(float64(size), d.name)
+		return nil
+	}
+
+	hash, size, err := d.store.PutBlob(job.ChatID, resp.Body)
+	if err != nil {
+		downloadFailures.Inc(d.name, "store")
+		return fmt.Errorf("store blob: %w", err)
+	}
+	downloadedBytes.Add(float64(size), d.name)
+
+	return d.store.UpdateMessage(job.ChatID, job.MessageID, func(m *archive.Message) error {
+		for i := range m.Media {
+			if m.Media[i].FileUniqueID == job.FileUniqueID {
+				m.Media[i].Blob = hash
+			}
+		}
+		return nil
+	})
+}
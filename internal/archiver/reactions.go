@@ -0,0 +1,83 @@
+package archiver
+
+import (
+	"context"
+	"errors"
+
+	"tg-archive-bot/internal/archive"
+	"tg-archive-bot/internal/log"
+
+	"github.com/mymmrac/telego"
+	"go.uber.org/zap"
+)
+
+// setReactions сохраняет итоговые счетчики анонимных реакций
+func (a *Archiver) setReactions(ctx context.Context, update *telego.MessageReactionCountUpdated) {
+	reactions := make([]archive.Reaction, 0, len(update.Reactions))
+	for _, r := range update.Reactions {
+		reactions = append(reactions, archive.Reaction{Emoji: reactionKey(r.Type), Count: r.TotalCount})
+	}
+
+	a.updateMessage(ctx, update.Chat.ID, update.MessageID, func(m *archive.Message) error {
+		m.Reactions = reactions
+		return nil
+	})
+}
+
+// changeReactions применяет изменение реакций одного пользователя
+func (a *Archiver) changeReactions(ctx context.Context, update *telego.MessageReactionUpdated) {
+	a.updateMessage(ctx, update.Chat.ID, update.MessageID, func(m *archive.Message) error {
+		for _, r := range update.OldReaction {
+			m.Reactions = addReaction(m.Reactions, reactionKey(r), -1)
+		}
+		for _, r := range update.NewReaction {
+			m.Reactions = addReaction(m.Reactions, reactionKey(r), 1)
+		}
+		return nil
+	})
+}
+
+// markDeleted отмечает удаленные сообщения, содержимое в архиве остается
+func (a *Archiver) markDeleted(ctx context.Context, update *telego.BusinessMessagesDeleted) {
+	for _, id := range update.MessageIDs {
+		a.updateMessage(ctx, update.Chat.ID, id, func(m *archive.Message) error {
+			m.Deleted = true
+			return nil
+		})
+	}
+}
+
+// updateMessage изменяет сообщение, если оно есть в архиве
+func (a *Archiver) updateMessage(ctx context.Context, chatID int64, id int, fn func(m *archive.Message) error) {
+	err := a.store.UpdateMessage(chatID, id, fn)
+	if err != nil && !errors.Is(err, archive.ErrNotFound) {
+		log.FromContext(ctx).Error("update message", zap.Int64("chat_id", chatID), zap.Int("message_id", id), zap.Error(err))
+	}
+}
+
+func reactionKey(r telego.ReactionType) string {
+	switch r := r.(type) {
+	case *telego.ReactionTypeEmoji:
+		return r.Emoji
+	case *telego.ReactionTypeCustomEmoji:
+		return "custom:" + r.CustomEmojiID
+	}
+	return r.ReactionType()
+}
+
+func addReaction(reactions []archive.Reaction, emoji string, delta int) []archive.Reaction {
+	for i := range reactions {
+		if reactions[i].Emoji != emoji {
+			continue
+		}
+		reactions[i].Count += delta
+		if reactions[i].Count <= 0 {
+			return append(reactions[:i], reactions[i+1:]...)
+		}
+		return reactions
+	}
+	if delta > 0 {
+		reactions = append(reactions, archive.Reaction{Emoji: emoji, Count: delta})
+	}
+	return reactions
+}
@@ -0,0 +1,397 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"tg-archive-bot/internal/archive"
+	"tg-archive-bot/internal/log"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// EnvPath переменная окружения с путем к файлу конфигурации
+const EnvPath = "TG_ARCHIVE_CONFIG"
+
+// DefaultPath файл конфигурации по умолчанию
+const DefaultPath = "config.json"
+
+// EnvToken переменная окружения с токеном единственного бота, если список bots пуст
+const EnvToken = "TG_ARCHIVE_TOKEN"
+
+// режимы получения обновлений
+const (
+	ModePolling = "polling"
+	ModeWebhook = "webhook"
+)
+
+// Config конфигурация бота
+type Config struct {
+	// DataDir каталог архива
+	DataDir string  `json:"data_dir"`
+	Bots    []Bot   `json:"bots"`
+	Log     Log     `json:"log"`
+	Admin   Admin   `json:"admin"`
+	Health  Health  `json:"health"`
+	Polling Polling `json:"polling"`
+	// RateLimits ограничения частоты вызовов Bot API
+	RateLimits RateLimits `json:"rate_limits"`
+	Access     Access     `json:"access"`
+	Retention  Retention  `json:"retention"`
+	Encryption Encryption `json:"encryption"`
+	Integrity  Integrity  `json:"integrity"`
+}
+
+// Integrity подпись цепочек изменений чатов. Цепочки ведутся всегда, без ключа
+// вершины не подписываются
+type Integrity struct {
+	// KeyFile закрытый ключ ed25519 в PEM
+	KeyFile string `json:"key_file,omitempty"`
+	// CheckpointInterval как часто подписывать вершины, по умолчанию 1h
+	CheckpointInterval string `json:"checkpoint_interval,omitempty"`
+}
+
+// Encryption шифрование архива. Мастер-ключ — 32 байта в hex или base64 из файла
+// или переменной окружения, без ключа архив не шифруется
+type Encryption struct {
+	KeyFile string `json:"key_file,omitempty"`
+	KeyEnv  string `json:"key_env,omitempty"`
+	// ClearIndex хранить снимок поискового индекса без шифрования: в нем слова сообщений,
+	// зато он не расшифровывается при каждом запуске
+	ClearIndex bool `json:"clear_index,omitempty"`
+}
+
+// Archive читает мастер-ключ и возвращает настройки шифрования хранилища
+func (e Encryption) Archive() (archive.Encryption, error) {
+	master, err := archive.LoadMasterKey(e.KeyFile, e.KeyEnv)
+	if err != nil {
+		return archive.Encryption{}, err
+	}
+	return archive.Encryption{Master: master, ClearIndex: e.ClearIndex}, nil
+}
+
+// Retention сроки хранения архива. Политика чата из chats заменяет default целиком
+type Retention struct {
+	// Interval период запуска очистки, по умолчанию 1h
+	Interval string `json:"interval,omitempty"`
+	// BatchSize сколько сообщений удалять за один запуск, по умолчанию 1000;
+	// остальное удаляется в следующие запуски
+	BatchSize int                       `json:"batch_size,omitempty"`
+	Default   RetentionPolicy           `json:"default"`
+	Chats     map[int64]RetentionPolicy `json:"chats,omitempty"`
+}
+
+// RetentionPolicy политика хранения чата. Нули означают бессрочное хранение
+type RetentionPolicy struct {
+	// MaxAgeDays через сколько дней удалять сообщения
+	MaxAgeDays int `json:"max_age_days,omitempty"`
+	// MediaMaxAgeDays через сколько дней удалять вложения по типам (photo, video, document...),
+	// "*" для всех остальных типов. Сами сообщения остаются
+	MediaMaxAgeDays map[string]int `json:"media_max_age_days,omitempty"`
+	// MaxMB наибольший размер архива чата с вложениями, сверх него удаляются старые сообщения
+	MaxMB int64 `json:"max_mb,omitempty"`
+	// LegalHold запрещает любое удаление из чата: очисткой, /forgetme и /purge
+	LegalHold bool `json:"legal_hold,omitempty"`
+}
+
+// Policy политика хранения чата
+func (r Retention) Policy(chatID int64) RetentionPolicy {
+	if p, ok := r.Chats[chatID]; ok {
+		return p
+	}
+	return r.Default
+}
+
+// Held удаление из чата запрещено
+func (r Retention) Held(chatID int64) bool {
+	return r.Policy(chatID).LegalHold
+}
+
+// Access какие чаты бот архивирует. Пустые owners и chats отключают проверку
+type Access struct {
+	// Owners пользователи, которые одобряют чаты; чат, в который бота добавил владелец,
+	// разрешается сразу
+	Owners []int64 `json:"owners,omitempty"`
+	// Chats разрешенные чаты
+	Chats []int64 `json:"chats,omitempty"`
+	// Approval ждать решения владельца вместо немедленного выхода из чата
+	Approval bool `json:"approval,omitempty"`
+	// ApprovalTimeout сколько ждать решения, по умолчанию 24h
+	ApprovalTimeout string `json:"approval_timeout,omitempty"`
+}
+
+// Enabled проверка чатов включена
+func (a Access) Enabled() bool {
+	return len(a.Owners) > 0 || len(a.Chats) > 0
+}
+
+// Bot бот, обновления которого архивирует процесс
+type Bot struct {
+	// Name имя бота в логах, метриках и командах, лучше совпадает с username
+	Name  string `json:"name"`
+	Token string `json:"token"`
+	// Mode получение обновлений: polling (по умолчанию) или webhook
+	Mode    string  `json:"mode,omitempty"`
+	Webhook Webhook `json:"webhook"`
+	// Namespace подкаталог data_dir с архивом бота, по умолчанию Name.
+	// Значение "." хранит архив прямо в data_dir, как до появления нескольких ботов
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// Webhook настройки получения обновлений через webhook
+type Webhook struct {
+	// URL публичный адрес, который Telegram вызывает с обновлениями
+	URL string `json:"url,omitempty"`
+	// Listen адрес HTTP сервера бота, путь берется из URL
+	Listen string `json:"listen,omitempty"`
+	// Secret значение заголовка X-Telegram-Bot-Api-Secret-Token
+	Secret string `json:"secret,omitempty"`
+}
+
+// RateLimits ограничения частоты вызовов Bot API, нули означают значения по умолчанию
+type RateLimits struct {
+	// GlobalRate вызовов в секунду для всего бота
+	GlobalRate float64 `json:"global_rate,omitempty"`
+	// PrivateRate и GroupRate сообщений в секунду в один чат
+	PrivateRate float64 `json:"private_rate,omitempty"`
+	GroupRate   float64 `json:"group_rate,omitempty"`
+	// MaxAttempts попыток одного вызова
+	MaxAttempts int `json:"max_attempts,omitempty"`
+}
+
+// Polling настройки получения обновлений
+type Polling struct {
+	// ExitOnConflict завершить процесс, если обновления бота получает другой процесс (ответ 409)
+	ExitOnConflict bool `json:"exit_on_conflict"`
+}
+
+// Health пороги проверки готовности
+type Health struct {
+	// Listen адрес host:port отдельного сервера проб /healthz и /readyz без токена
+	Listen string `json:"listen,omitempty"`
+	// MinFreeMB минимальное свободное место на диске с архивом
+	MinFreeMB int `json:"min_free_mb"`
+}
+
+// Admin настройки HTTP сервера администрирования
+type Admin struct {
+	// Listen адрес host:port или unix:/путь/к/сокету, пустой адрес отключает сервер
+	Listen string `json:"listen,omitempty"`
+	// Token токен для заголовка Authorization: Bearer, обязателен для адресов не на localhost
+	Token string `json:"token,omitempty"`
+}
+
+// Log настройки логирования
+type Log struct {
+	// Env development или production
+	Env   string           `json:"env"`
+	Level string           `json:"level"`
+	Sinks []log.SinkConfig `json:"sinks,omitempty"`
+	// Payload вывод содержимого обновлений на уровне debug
+	Payload log.PayloadConfig `json:"payload"`
+}
+
+// Default конфигурация без файла
+func Default() *Config {
+	return &Config{
+		DataDir: "data",
+		Log: Log{
+			Env:     "development",
+			Level:   "debug",
+			Payload: log.PayloadConfig{SampleEvery: 1, MaxBytes: 4096},
+		},
+		Health: Health{MinFreeMB: 512},
+	}
+}
+
+// Path путь к файлу конфигурации из окружения или по умолчанию
+func Path() string {
+	if path := os.Getenv(EnvPath); path != "" {
+		return path
+	}
+	return DefaultPath
+}
+
+// Load читает конфигурацию поверх значений по умолчанию.
+// Отсутствие файла по пути по умолчанию не считается ошибкой
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && path == DefaultPath {
+		cfg.envBot()
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("decode config %s: %w", path, err)
+	}
+	cfg.envBot()
+	if err = cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// envBot единственный бот с токеном из окружения и архивом прямо в data_dir
+func (c *Config) envBot() {
+	if token := os.Getenv(EnvToken); token != "" && len(c.Bots) == 0 {
+		c.Bots = []Bot{{Name: "default", Token: token, Namespace: "."}}
+	}
+}
+
+// BotDataDir каталог архива бота
+func (c *Config) BotDataDir(b Bot) string {
+	namespace := b.Namespace
+	if namespace == "" {
+		namespace = b.Name
+	}
+	return filepath.Join(c.DataDir, namespace)
+}
+
+// FindBot бот с именем name
+func (c *Config) FindBot(name string) (Bot, bool) {
+	for _, b := range c.Bots {
+		if b.Name == name {
+			return b, true
+		}
+	}
+	return Bot{}, false
+}
+
+// Validate проверяет значения, которые иначе обнаружатся только при применении
+func (c *Config) Validate() error {
+	if c.DataDir == "" {
+		return errors.New("data_dir is required")
+	}
+	names := make(map[string]bool, len(c.Bots))
+	dirs := make(map[string]string, len(c.Bots))
+	for i, b := range c.Bots {
+		switch {
+		case b.Name == "":
+			return fmt.Errorf("bots[%d].name is required", i)
+		case names[b.Name]:
+			return fmt.Errorf("bots[%d]: duplicate name %q", i, b.Name)
+		case b.Token == "":
+			return fmt.Errorf("bots[%d].token is required", i)
+		}
+		names[b.Name] = true
+		dir := c.BotDataDir(b)
+		if other, ok := dirs[dir]; ok {
+			return fmt.Errorf("bots[%d]: namespace is already used by %q", i, other)
+		}
+		dirs[dir] = b.Name
+
+		switch b.Mode {
+		case "", ModePolling:
+		case ModeWebhook:
+			if b.Webhook.URL == "" || b.Webhook.Listen == "" {
+				return fmt.Errorf("bots[%d].webhook: url and listen are required", i)
+			}
+		default:
+			return fmt.Errorf("bots[%d].mode: unknown mode %q", i, b.Mode)
+		}
+	}
+	if _, err := zapcore.ParseLevel(c.Log.Level); err != nil {
+		return fmt.Errorf("log.level: %w", err)
+	}
+	for i, sink := range c.Log.Sinks {
+		if sink.Level == "" {
+			continue
+		}
+		if _, err := zapcore.ParseLevel(sink.Level); err != nil {
+			return fmt.Errorf("log.sinks[%d].level: %w", i, err)
+		}
+	}
+	if c.Log.Payload.SampleEvery < 0 || c.Log.Payload.MaxBytes < 0 {
+		return errors.New("log.payload: values must not be negative")
+	}
+	if c.Health.MinFreeMB < 0 {
+		return errors.New("health.min_free_mb must not be negative")
+	}
+	if c.Health.Listen != "" {
+		if _, _, err := net.SplitHostPort(c.Health.Listen); err != nil {
+			return fmt.Errorf("health.listen: %w", err)
+		}
+	}
+	if c.Access.ApprovalTimeout != "" {
+		if _, err := time.ParseDuration(c.Access.ApprovalTimeout); err != nil {
+			return fmt.Errorf("access.approval_timeout: %w", err)
+		}
+	}
+	if c.Access.Approval && len(c.Access.Owners) == 0 {
+		return errors.New("access.approval requires owners")
+	}
+	if c.Encryption.KeyFile != "" && c.Encryption.KeyEnv != "" {
+		return errors.New("encryption: key_file and key_env are mutually exclusive")
+	}
+	if c.Integrity.CheckpointInterval != "" {
+		if _, err := time.ParseDuration(c.Integrity.CheckpointInterval); err != nil {
+			return fmt.Errorf("integrity.checkpoint_interval: %w", err)
+		}
+	}
+	if err := c.Retention.validate(); err != nil {
+		return fmt.Errorf("retention: %w", err)
+	}
+	r := c.RateLimits
+	if r.GlobalRate < 0 || r.PrivateRate < 0 || r.GroupRate < 0 || r.MaxAttempts < 0 {
+		return errors.New("rate_limits: values must not be negative")
+	}
+	return nil
+}
+
+func (r Retention) validate() error {
+	if r.Interval != "" {
+		if d, err := time.ParseDuration(r.Interval); err != nil || d <= 0 {
+			return fmt.Errorf("interval: invalid duration %q", r.Interval)
+		}
+	}
+	if r.BatchSize < 0 {
+		return errors.New("batch_size must not be negative")
+	}
+	check := func(p RetentionPolicy) error {
+		if p.MaxAgeDays < 0 || p.MaxMB < 0 {
+			return errors.New("values must not be negative")
+		}
+		for kind, days := range p.MediaMaxAgeDays {
+			if days < 0 {
+				return fmt.Errorf("media_max_age_days.%s must not be negative", kind)
+			}
+		}
+		return nil
+	}
+	if err := check(r.Default); err != nil {
+		return fmt.Errorf("default: %w", err)
+	}
+	for chatID, p := range r.Chats {
+		if err := check(p); err != nil {
+			return fmt.Errorf("chats.%d: %w", chatID, err)
+		}
+	}
+	return nil
+}
+
+// Masked копия конфигурации, в которой секреты заменены на log.Redacted
+func (c *Config) Masked() *Config {
+	masked := *c
+	masked.Log.Sinks = append([]log.SinkConfig(nil), c.Log.Sinks...)
+	masked.Bots = append([]Bot(nil), c.Bots...)
+	for i := range masked.Bots {
+		masked.Bots[i].Token = log.Redacted
+		if masked.Bots[i].Webhook.Secret != "" {
+			masked.Bots[i].Webhook.Secret = log.Redacted
+		}
+	}
+	if masked.Admin.Token != "" {
+		masked.Admin.Token = log.Redacted
+	}
+	return &masked
+}
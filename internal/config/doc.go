@@ -0,0 +1,3 @@
+package config
+
+// конфигурация бота из JSON-файла
@@ -0,0 +1,113 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+)
+
+// Reloader хранит действующую конфигурацию и перечитывает файл по запросу.
+// Без перезапуска применяются уровень логирования, вывод содержимого обновлений,
+// ограничения частоты, поведение при конфликте long polling, доступ к чатам, хранение,
+// список ботов (добавленные запускаются, удаленные и измененные останавливаются
+// или перезапускаются) и токен сервера администрирования; изменения остальных
+// настроек сохраняются, но вступают в силу после перезапуска
+type Reloader struct {
+	path      string
+	current   atomic.Pointer[Config]
+	overrides []func(cfg *Config)
+
+	mu        sync.Mutex
+	listeners []func(cfg *Config) error
+}
+
+// ErrApply новая конфигурация принята, но применилась не полностью, например не запустился бот
+var ErrApply = errors.New("config applied with errors")
+
+// NewReloader начинает с уже загруженной конфигурации cfg. overrides применяются к каждой
+// перечитанной конфигурации, например чтобы сохранить значения из флагов командной строки
+func NewReloader(path string, cfg *Config, overrides ...func(cfg *Config)) *Reloader {
+	r := &Reloader{path: path, overrides: overrides}
+	for _, override := range overrides {
+		override(cfg)
+	}
+	r.current.Store(cfg)
+	return r
+}
+
+// Current действующая конфигурация, ее нельзя изменять
+func (r *Reloader) Current() *Config {
+	return r.current.Load()
+}
+
+// OnReload добавляет обработчик, который применяет новую конфигурацию.
+// Ошибка обработчика не отменяет конфигурацию и возвращается из Reload
+func (r *Reloader) OnReload(fn func(cfg *Config) error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.listeners = append(r.listeners, fn)
+}
+
+// Reload перечитывает файл. Если конфигурация не читается или не проходит проверку,
+// действующая остается без изменений и возвращается ошибка. Если новая конфигурация
+// применилась не полностью, она остается действующей, а ошибка оборачивает ErrApply
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cfg, err := Load(r.path)
+	if err != nil {
+		return err
+	}
+	for _, override := range r.overrides {
+		override(cfg)
+	}
+	old := r.current.Load()
+	if restart := restartRequired(old, cfg); len(restart) > 0 {
+		zap.L().Warn("config changes require restart", zap.Strings("fields", restart))
+	}
+
+	r.current.Store(cfg)
+	var errs []error
+	for _, fn := range r.listeners {
+		if err := fn(cfg); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%w: %w", ErrApply, errors.Join(errs...))
+	}
+	zap.L().Info("config reloaded", zap.String("path", r.path))
+	return nil
+}
+
+// restartRequired измененные настройки, которые применяются только при запуске
+func restartRequired(old, cfg *Config) []string {
+	var fields []string
+	if old.DataDir != cfg.DataDir {
+		fields = append(fields, "data_dir")
+	}
+	if old.Log.Env != cfg.Log.Env {
+		fields = append(fields, "log.env")
+	}
+	if !reflect.DeepEqual(old.Log.Sinks, cfg.Log.Sinks) {
+		fields = append(fields, "log.sinks")
+	}
+	if old.Admin.Listen != cfg.Admin.Listen {
+		fields = append(fields, "admin.listen")
+	}
+	if old.Health != cfg.Health {
+		fields = append(fields, "health")
+	}
+	if old.Encryption != cfg.Encryption {
+		fields = append(fields, "encryption")
+	}
+	if old.Integrity != cfg.Integrity {
+		fields = append(fields, "integrity")
+	}
+	return fields
+}
@@ -0,0 +1,251 @@
+package fsck
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"tg-archive-bot/internal/access"
+	"tg-archive-bot/internal/archive"
+	"tg-archive-bot/internal/archiver"
+	"tg-archive-bot/internal/search"
+)
+
+// Виды проблем
+const (
+	// BlobMissing сообщение ссылается на файл, которого нет
+	BlobMissing = "blob_missing"
+	// BlobCorrupted содержимое файла не совпадает с его sha256 или не расшифровывается
+	BlobCorrupted = "blob_corrupted"
+	// BlobOrphaned на файл не ссылается ни одно сообщение
+	BlobOrphaned = "blob_orphaned"
+	// DownloadUnfinished вложение не скачано: задание потерялось при остановке бота
+	DownloadUnfinished = "download_unfinished"
+	// TempFile временный файл после аварийного завершения
+	TempFile = "temp_file"
+	// MessageGap пропуск в номерах сообщений чата. Проверяется только по запросу:
+	// пропуски бывают и без сбоев
+	MessageGap = "message_gap"
+	// MessageUnreadable запись сообщения не читается
+	MessageUnreadable = "message_unreadable"
+	// IndexDangling запись индекса указывает на отсутствующее сообщение
+	IndexDangling = "index_dangling"
+	// IndexMissing сообщения нет в индексе
+	IndexMissing = "index_missing"
+)
+
+// Исправления
+const (
+	// FixRedownload скачать вложение заново через getFile
+	FixRedownload = "redownload"
+	// FixGC удалить файл
+	FixGC = "gc"
+	// FixReindex исправить запись индекса или перестроить индекс командой reindex
+	FixReindex = "reindex"
+	// FixImport догрузить сообщения из экспорта Telegram Desktop командой import
+	FixImport = "import"
+	// FixRestore восстановить из резервной копии
+	FixRestore = "restore"
+)
+
+// tempGrace временные файлы моложе этого могут принадлежать работающей записи
+const tempGrace = time.Hour
+
+// Problem проблема и способ ее исправить
+type Problem struct {
+	Kind      string
+	ChatID    int64
+	MessageID int
+	Blob      string
+	Path      string
+	Detail    string
+	Fix       string
+	// Safe исправление применяется в режиме repair
+	Safe bool
+}
+
+// Report итог проверки
+type Report struct {
+	Chats    int
+	Messages int
+	Blobs    int
+	Problems []Problem
+}
+
+// Redownloads число вложений, которые Repair поставит на скачивание
+func (r *Report) Redownloads() int {
+	var n int
+	for _, p := range r.Problems {
+		if p.Safe && p.Fix == FixRedownload {
+			n++
+		}
+	}
+	return n
+}
+
+// Count число проблем вида kind
+func (r *Report) Count(kind string) int {
+	var n int
+	for _, p := range r.Problems {
+		if p.Kind == kind {
+			n++
+		}
+	}
+	return n
+}
+
+// Check проверяет архив, ничего не изменяя. Вложения чатов, в которых
+// сохраняется только текст, недокачанными не считаются. С gaps сообщает
+// и о пропусках в номерах сообщений
+func Check(store *archive.Store, index *search.Index, archiving *access.Archiving, gaps bool) (*Report, error) {
+	report := &Report{}
+	add := func(p Problem) {
+		report.Problems = append(report.Problems, p)
+	}
+
+	chats, err := store.Chats()
+	if err != nil {
+		return nil, err
+	}
+	refs := make(map[string][]search.DocKey)
+	messages := make(map[search.DocKey]bool)
+	// unreadable сообщений, ссылки которых на вложения неизвестны
+	var unreadable int
+	for _, chatID := range chats {
+		report.Chats++
+		ids, err := store.MessageIDs(chatID)
+		if err != nil {
+			return nil, err
+		}
+		textOnly := archiving.Get(chatID).TextOnly
+		for i, id := range ids {
+			report.Messages++
+			if gaps && i > 0 && id-ids[i-1] > 1 {
+				add(Problem{
+					Kind:      MessageGap,
+					ChatID:    chatID,
+					MessageID: id,
+					Detail:    fmt.Sprintf("messages %d-%d are missing", ids[i-1]+1, id-1),
+					Fix:       FixImport,
+				})
+			}
+			m, err := store.GetMessage(chatID, id)
+			if errors.Is(err, archive.ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				add(Problem{Kind: MessageUnreadable, ChatID: chatID, MessageID: id, Detail: err.Error(), Fix: FixRestore})
+				unreadable++
+				continue
+			}
+
+			key := search.DocKey{ChatID: chatID, MessageID: id}
+			messages[key] = true
+			for _, media := range m.Media {
+				switch {
+				case media.Blob != "":
+					refs[media.Blob] = append(refs[media.Blob], key)
+				case media.FileID != "" && !media.Pruned && !textOnly && media.Size <= archiver.MaxDownloadSize:
+					add(Problem{Kind: DownloadUnfinished, ChatID: chatID, MessageID: id, Fix: FixRedownload, Safe: true})
+				}
+			}
+			if search.Indexable(m) && !index.Has(key) {
+				add(Problem{Kind: IndexMissing, ChatID: chatID, MessageID: id, Fix: FixReindex, Safe: true})
+			}
+		}
+	}
+
+	for _, key := range index.Keys() {
+		if !messages[key] {
+			add(Problem{Kind: IndexDangling, ChatID: key.ChatID, MessageID: key.MessageID, Fix: FixReindex, Safe: true})
+		}
+	}
+
+	stored := make(map[string]bool)
+	err = store.Blobs(func(hash string, _ int64, _ time.Time) error {
+		report.Blobs++
+		stored[hash] = true
+		keys, ok := refs[hash]
+		if !ok {
+			p := Problem{Kind: BlobOrphaned, Blob: hash, Fix: FixGC, Safe: unreadable == 0}
+			// на файл может ссылаться сообщение, которое не удалось прочитать
+			if !p.Safe {
+				p.Detail = fmt.Sprintf("%d messages are unreadable and may reference it", unreadable)
+			}
+			add(p)
+			return nil
+		}
+		if err := verifyBlob(store, hash); err != nil {
+			for _, key := range keys {
+				add(Problem{Kind: BlobCorrupted, ChatID: key.ChatID, MessageID: key.MessageID, Blob: hash,
+					Detail: err.Error(), Fix: FixRedownload, Safe: redownloadable(store, key, hash)})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list blobs: %w", err)
+	}
+	for hash, keys := range refs {
+		if stored[hash] {
+			continue
+		}
+		for _, key := range keys {
+			add(Problem{Kind: BlobMissing, ChatID: key.ChatID, MessageID: key.MessageID, Blob: hash,
+				Fix: FixRedownload, Safe: redownloadable(store, key, hash)})
+		}
+	}
+
+	now := time.Now()
+	err = store.TempFiles(func(path string, modTime time.Time) error {
+		add(Problem{Kind: TempFile, Path: path, Fix: FixGC, Safe: now.Sub(modTime) > tempGrace})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list temporary files: %w", err)
+	}
+
+	sort.SliceStable(report.Problems, func(i, j int) bool {
+		a, b := report.Problems[i], report.Problems[j]
+		if a.ChatID != b.ChatID {
+			return a.ChatID < b.ChatID
+		}
+		return a.MessageID < b.MessageID
+	})
+	return report, nil
+}
+
+// verifyBlob сверяет содержимое файла с его sha256
+func verifyBlob(store *archive.Store, hash string) error {
+	r, err := store.OpenBlob(hash)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, r); err != nil {
+		return err
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != hash {
+		return fmt.Errorf("content hash is %s", sum)
+	}
+	return nil
+}
+
+// redownloadable вложение можно скачать заново: у него сохранен file_id
+func redownloadable(store *archive.Store, key search.DocKey, hash string) bool {
+	m, err := store.GetMessage(key.ChatID, key.MessageID)
+	if err != nil {
+		return false
+	}
+	for _, media := range m.Media {
+		if media.Blob == hash && media.FileID != "" && media.Size <= archiver.MaxDownloadSize {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+package fsck
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"tg-archive-bot/internal/access"
+	"tg-archive-bot/internal/archive"
+	"tg-archive-bot/internal/search"
+)
+
+const testChat = -100
+
+func check(t *testing.T, store *archive.Store, gaps bool) *Report {
+	t.Helper()
+	index, err := search.Open(filepath.Join(store.Dir(), "index"), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+	archiving, err := access.NewArchiving(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := Check(store, index, archiving, gaps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func openStore(t *testing.T) *archive.Store {
+	t.Helper()
+	store, err := archive.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	for _, id := range []int{1, 2, 5} {
+		if err = store.PutMessage(&archive.Message{ChatID: testChat, ID: id, Text: "text"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store
+}
+
+func TestCheckGapsOptIn(t *testing.T) {
+	store := openStore(t)
+	if n := check(t, store, false).Count(MessageGap); n != 0 {
+		t.Fatalf("%d gaps reported without -gaps", n)
+	}
+	if n := check(t, store, true).Count(MessageGap); n != 1 {
+		t.Fatalf("%d gaps reported, want 1", n)
+	}
+}
+
+// Файл без ссылок может принадлежать сообщению, которое не удалось прочитать
+func TestCheckOrphanUnsafeWithUnreadable(t *testing.T) {
+	store := openStore(t)
+	if _, _, err := store.PutBlob(testChat, bytes.NewReader([]byte("orphan"))); err != nil {
+		t.Fatal(err)
+	}
+	orphanSafe := func(r *Report) bool {
+		for _, p := range r.Problems {
+			if p.Kind == BlobOrphaned {
+				return p.Safe
+			}
+		}
+		t.Fatal("orphan blob is not reported")
+		return false
+	}
+	if !orphanSafe(check(t, store, false)) {
+		t.Fatal("orphan blob is unsafe to remove in a readable archive")
+	}
+
+	path := filepath.Join(store.Dir(), "chats", "-100", "messages", "2.json")
+	if err := os.WriteFile(path, []byte("{broken"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	report := check(t, store, false)
+	if report.Count(MessageUnreadable) != 1 {
+		t.Fatalf("unreadable message is not reported: %+v", report.Problems)
+	}
+	if orphanSafe(report) {
+		t.Fatal("orphan blob is safe to remove while a message is unreadable")
+	}
+}
@@ -0,0 +1,3 @@
+package fsck
+
+// проверка согласованности архива: сообщения, файлы вложений и поисковый индекс
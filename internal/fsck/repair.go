@@ -0,0 +1,106 @@
+package fsck
+
+import (
+	"context"
+	"fmt"
+
+	"tg-archive-bot/internal/archive"
+	"tg-archive-bot/internal/archiver"
+	"tg-archive-bot/internal/search"
+)
+
+// Repaired итог исправления
+type Repaired struct {
+	Removed     int
+	Redownloads int
+	Reindexed   int
+	// Skipped безопасные исправления, которые нельзя применить: нет загрузчика
+	// или его очередь заполнена
+	Skipped int
+}
+
+// Repair применяет безопасные исправления из report: удаляет временные и лишние
+// файлы, исправляет индекс и ставит вложения на повторное скачивание через getFile.
+// Испорченный или потерянный файл заменяется только скачанным с тем же sha256.
+// Без downloader вложения не скачиваются. Очередь downloader должна вмещать
+// report.Redownloads() заданий. Downloader.Stop вызывает тот, кто его создал
+func Repair(ctx context.Context, store *archive.Store, index *search.Index, downloader *archiver.Downloader, report *Report) (*Repaired, error) {
+	done := &Repaired{}
+	// unfinished число недокачанных вложений по сообщениям, replacing файлы,
+	// уже поставленные на замену: на один файл ссылаются несколько сообщений
+	unfinished := make(map[search.DocKey]int)
+	replacing := make(map[string]bool)
+	for _, p := range report.Problems {
+		if !p.Safe {
+			continue
+		}
+		key := search.DocKey{ChatID: p.ChatID, MessageID: p.MessageID}
+		switch p.Kind {
+		case TempFile:
+			if err := store.RemoveTempFile(p.Path); err != nil {
+				return done, err
+			}
+			done.Removed++
+		case BlobOrphaned:
+			if err := store.DeleteBlob(p.Blob); err != nil {
+				return done, fmt.Errorf("delete blob %s: %w", p.Blob, err)
+			}
+			done.Removed++
+		case BlobCorrupted, BlobMissing:
+			if replacing[p.Blob] {
+				continue
+			}
+			if downloader == nil {
+				done.Skipped++
+				continue
+			}
+			m, err := store.GetMessage(p.ChatID, p.MessageID)
+			if err != nil {
+				return done, fmt.Errorf("message %d in chat %d: %w", p.MessageID, p.ChatID, err)
+			}
+			if !downloader.Replace(ctx, m, p.Blob) {
+				done.Skipped++
+				continue
+			}
+			replacing[p.Blob] = true
+			done.Redownloads++
+		case DownloadUnfinished:
+			if downloader == nil {
+				done.Skipped++
+				continue
+			}
+			unfinished[key]++
+		case IndexDangling:
+			if err := index.Delete(p.ChatID, p.MessageID); err != nil {
+				return done, err
+			}
+			done.Reindexed++
+		case IndexMissing:
+			m, err := store.GetMessage(p.ChatID, p.MessageID)
+			if err != nil {
+				return done, err
+			}
+			if err = index.Update(m); err != nil {
+				return done, err
+			}
+			done.Reindexed++
+		}
+	}
+
+	for key, n := range unfinished {
+		m, err := store.GetMessage(key.ChatID, key.MessageID)
+		if err != nil {
+			return done, fmt.Errorf("message %d in chat %d: %w", key.MessageID, key.ChatID, err)
+		}
+		queued := downloader.Enqueue(ctx, m)
+		done.Redownloads += queued
+		done.Skipped += max(n-queued, 0)
+	}
+
+	if done.Reindexed > 0 {
+		if err := index.Save(); err != nil {
+			return done, fmt.Errorf("save search index: %w", err)
+		}
+	}
+	return done, nil
+}
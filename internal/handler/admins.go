@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mymmrac/telego"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+// adminsTTL время, в течение которого список администраторов чата считается актуальным
+const adminsTTL = 5 * time.Minute
+
+type adminsEntry struct {
+	ids     map[int64]bool
+	expires time.Time
+}
+
+// admins кэш списков администраторов чатов
+type admins struct {
+	api *telego.Bot
+
+	mu    sync.Mutex
+	cache map[int64]adminsEntry
+}
+
+func newAdmins(api *telego.Bot) *admins {
+	return &admins{
+		api:   api,
+		cache: make(map[int64]adminsEntry),
+	}
+}
+
+// IsAdmin проверяет, является ли пользователь владельцем или администратором чата.
+// Ошибка API не кэшируется
+func (c *admins) IsAdmin(chatID, userID int64) (bool, error) {
+	c.mu.Lock()
+	entry, ok := c.cache[chatID]
+	c.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.ids[userID], nil
+	}
+
+	list, err := c.api.GetChatAdministrators(&telego.GetChatAdministratorsParams{ChatID: tu.ID(chatID)})
+	if err != nil {
+		return false, err
+	}
+	entry = adminsEntry{ids: make(map[int64]bool, len(list)), expires: time.Now().Add(adminsTTL)}
+	for _, member := range list {
+		entry.ids[member.MemberUser().ID] = true
+	}
+
+	c.mu.Lock()
+	c.cache[chatID] = entry
+	c.mu.Unlock()
+	return entry.ids[userID], nil
+}
+
+// Forget сбрасывает кэш чата, например после изменения прав
+func (c *admins) Forget(chatID int64) {
+	c.mu.Lock()
+	delete(c.cache, chatID)
+	c.mu.Unlock()
+}
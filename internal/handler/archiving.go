@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"tg-archive-bot/internal/access"
+	"tg-archive-bot/internal/i18n"
+	"tg-archive-bot/internal/log"
+
+	"github.com/mymmrac/telego"
+	tu "github.com/mymmrac/telego/telegoutil"
+	"go.uber.org/zap"
+)
+
+// maxPause наибольшая пауза архивирования
+const maxPause = 30 * 24 * time.Hour
+
+// archive команда /archive: администраторы группы включают, выключают и приостанавливают
+// архивирование своего чата
+func (h *Handler) archive(ctx context.Context, msg *telego.Message, args commandArgs) error {
+	lang := h.lang(msg.Chat.ID, msg.From)
+	action := args.fields[0]
+	if action == "status" {
+		h.reply(ctx, msg, archivingStatus(lang, h.archiving.Get(msg.Chat.ID)), nil)
+		return nil
+	}
+
+	var disabled bool
+	var pausedUntil time.Time
+	switch action {
+	case "on", "off":
+		if len(args.fields) != 1 {
+			return errUsage
+		}
+		disabled = action == "off"
+	case "pause":
+		if len(args.fields) != 2 {
+			return errUsage
+		}
+		d, err := time.ParseDuration(args.fields[1])
+		if err != nil || d <= 0 || d > maxPause {
+			h.reply(ctx, msg, i18n.T(lang, "archive.pause_invalid"), nil)
+			return nil
+		}
+		pausedUntil = time.Now().Add(d)
+	default:
+		return errUsage
+	}
+
+	if !h.requireAdmin(ctx, msg, lang) {
+		return nil
+	}
+
+	wasActive := h.archiving.Active(msg.Chat.ID)
+	state, err := h.archiving.Update(msg.Chat.ID, func(c *access.ChatArchiving) {
+		c.Disabled = disabled
+		c.PausedUntil = pausedUntil
+		c.ChangedBy = msg.From.ID
+		c.ChangedAt = time.Now()
+	})
+	if err != nil {
+		h.reply(ctx, msg, i18n.T(lang, "settings.save_failed"), nil)
+		return fmt.Errorf("save archiving state: %w", err)
+	}
+	log.FromContext(ctx).Info("chat archiving changed", zap.String("archiving", action), zap.Time("paused_until", state.PausedUntil))
+
+	if state.Active(state.ChangedAt) && !wasActive {
+		h.announce(ctx, msg.Chat.ID, lang)
+		return nil
+	}
+	h.reply(ctx, msg, archivingStatus(lang, state), nil)
+	return nil
+}
+
+// requireAdmin в группах пропускает только администраторов и отвечает отказом остальным.
+// В личном чате пользователь сам себе администратор
+func (h *Handler) requireAdmin(ctx context.Context, msg *telego.Message, lang string) bool {
+	if msg.Chat.Type == telego.ChatTypePrivate {
+		return true
+	}
+	if key, ok := h.checkAdmin(ctx, msg.Chat.ID, msg.From, msg.SenderChat); !ok {
+		h.reply(ctx, msg, i18n.T(lang, key), nil)
+		return false
+	}
+	return true
+}
+
+// groupAnonymousBotID @GroupAnonymousBot, от его имени приходят действия анонимных администраторов
+const groupAnonymousBotID int64 = 1087968824
+
+// checkAdmin проверяет, что пользователь администратор чата, иначе возвращает ключ текста отказа.
+// Анонимный администратор пишет от имени самого чата (senderChat) или от @GroupAnonymousBot
+func (h *Handler) checkAdmin(ctx context.Context, chatID int64, from *telego.User, senderChat *telego.Chat) (string, bool) {
+	if senderChat != nil && senderChat.ID == chatID {
+		return "", true
+	}
+	if from == nil {
+		return "admin.required", false
+	}
+	if from.ID == groupAnonymousBotID {
+		return "", true
+	}
+	ok, err := h.admins.IsAdmin(chatID, from.ID)
+	if err != nil {
+		log.FromContext(ctx).Error("get chat administrators", zap.Error(err))
+		return "admin.check_failed", false
+	}
+	if !ok {
+		return "admin.required", false
+	}
+	return "", true
+}
+
+// announce публикует и пытается закрепить уведомление о начале архивирования
+func (h *Handler) announce(ctx context.Context, chatID int64, lang string) {
+	logger := log.FromContext(ctx)
+	sent, err := h.api.SendMessage(tu.Message(tu.ID(chatID), i18n.T(lang, "archive.notice")))
+	if err != nil {
+		logger.Error("send message", zap.Int64("chat_id", chatID), zap.Error(err))
+		return
+	}
+	// закрепить можно только с правом can_pin_messages, без него уведомление остается обычным сообщением
+	err = h.api.PinChatMessage(&telego.PinChatMessageParams{
+		ChatID:              tu.ID(chatID),
+		MessageID:           sent.MessageID,
+		DisableNotification: true,
+	})
+	if err != nil {
+		logger.Debug("pin archiving notice", zap.Error(err))
+	}
+}
+
+func archivingStatus(lang string, state access.ChatArchiving) string {
+	switch {
+	case state.Disabled:
+		return i18n.T(lang, "archive.status_off")
+	case time.Now().Before(state.PausedUntil):
+		return i18n.T(lang, "archive.status_paused", state.PausedUntil.UTC().Format("2006-01-02 15:04"))
+	}
+	return i18n.T(lang, "archive.status_on")
+}
@@ -0,0 +1,284 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"slices"
+	"strings"
+
+	"tg-archive-bot/internal/i18n"
+	"tg-archive-bot/internal/log"
+
+	"github.com/mymmrac/telego"
+	tu "github.com/mymmrac/telego/telegoutil"
+	"go.uber.org/zap"
+)
+
+// errUsage команда вызвана с неверными аргументами, в ответ отправляется справка по ней
+var errUsage = errors.New("usage")
+
+// scope где команда доступна и показывается в меню. Соответствует BotCommandScope
+type scope struct {
+	kind   string
+	chatID int64
+}
+
+var (
+	scopePrivate     = scope{kind: telego.ScopeTypeAllPrivateChats}
+	scopeGroups      = scope{kind: telego.ScopeTypeAllGroupChats}
+	scopeGroupAdmins = scope{kind: telego.ScopeTypeAllChatAdministrators}
+)
+
+// scopeChat команда только для одного чата
+func scopeChat(chatID int64) scope {
+	return scope{kind: telego.ScopeTypeChat, chatID: chatID}
+}
+
+// commandArgs аргументы команды: весь текст после команды и он же, разбитый по пробелам
+type commandArgs struct {
+	raw    string
+	fields []string
+}
+
+// command описание команды бота. Описание для меню, аргументы и подробная справка
+// берутся из каталогов по ключам cmd.<name>.description, cmd.<name>.usage и cmd.<name>.details
+type command struct {
+	name    string
+	scopes  []scope
+	minArgs int
+	maxArgs int // -1 без ограничения
+	run     func(ctx context.Context, msg *telego.Message, args commandArgs) error
+}
+
+// registerCommands список команд бота. Меню Telegram строится из него же
+func (h *Handler) registerCommands() []*command {
+	return []*command{
+		{
+			name:    "search",
+			scopes:  []scope{scopePrivate, scopeGroups},
+			minArgs: 1,
+			maxArgs: -1,
+			run:     h.search,
+		},
+		{
+			name:    "archive",
+			scopes:  []scope{scopeGroupAdmins},
+			minArgs: 1,
+			maxArgs: 2,
+			run:     h.archive,
+		},
+		{
+			name:    "language",
+			scopes:  []scope{scopePrivate, scopeGroupAdmins},
+			maxArgs: 1,
+			run:     h.language,
+		},
+		{
+			name:   "forgetme",
+			scopes: []scope{scopePrivate},
+			run:    h.forgetMe,
+		},
+		{
+			name:    "purge",
+			scopes:  []scope{scopeGroupAdmins},
+			maxArgs: 1,
+			run:     h.purgeUser,
+		},
+		{
+			name:   "help",
+			scopes: []scope{scopePrivate, scopeGroups},
+			run:    h.help,
+		},
+	}
+}
+
+// handleCommand находит команду в реестре, проверяет аргументы и выполняет ее
+func (h *Handler) handleCommand(ctx context.Context, msg *telego.Message) {
+	if msg.From == nil || msg.Text == "" {
+		return
+	}
+	name, username, payload := tu.ParseCommandPayload(msg.Text)
+	if name == "" {
+		return
+	}
+	private := msg.Chat.Type == telego.ChatTypePrivate
+	// в группах команда вида /search@other_bot адресована другому боту
+	if username != "" && !strings.EqualFold(username, h.username) {
+		return
+	}
+
+	cmd := h.findCommand(name)
+	if cmd == nil || !cmd.availableIn(msg.Chat) {
+		if private {
+			h.reply(ctx, msg, i18n.T(h.lang(msg.Chat.ID, msg.From), "command.unknown"), nil)
+		}
+		return
+	}
+
+	args := commandArgs{raw: strings.TrimSpace(payload), fields: strings.Fields(payload)}
+	err := cmd.checkArgs(args)
+	if err == nil {
+		err = cmd.run(ctx, msg, args)
+	}
+	if errors.Is(err, errUsage) {
+		h.reply(ctx, msg, cmd.help(h.lang(msg.Chat.ID, msg.From)), nil)
+		return
+	}
+	if err != nil {
+		log.FromContext(ctx).Error("command failed", zap.String("command", name), zap.Error(err))
+	}
+}
+
+func (h *Handler) findCommand(name string) *command {
+	for _, cmd := range h.commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+func (c *command) checkArgs(args commandArgs) error {
+	if len(args.fields) < c.minArgs || (c.maxArgs >= 0 && len(args.fields) > c.maxArgs) {
+		return errUsage
+	}
+	return nil
+}
+
+// availableIn можно ли выполнить команду в чате. Права администратора проверяет сама команда
+func (c *command) availableIn(chat telego.Chat) bool {
+	if slices.Contains(c.scopes, scopeChat(chat.ID)) {
+		return true
+	}
+	if chat.Type == telego.ChatTypePrivate {
+		return slices.Contains(c.scopes, scopePrivate)
+	}
+	return slices.Contains(c.scopes, scopeGroups) || slices.Contains(c.scopes, scopeGroupAdmins)
+}
+
+// describe описание команды для меню
+func (c *command) describe(lang string) string {
+	return i18n.T(lang, "cmd."+c.name+".description")
+}
+
+// usage команда с аргументами, например <code>/archive on|off</code>
+func (c *command) usage(lang string) string {
+	text := "/" + c.name
+	if args := i18n.T(lang, "cmd."+c.name+".usage"); args != "" {
+		text += " " + html.EscapeString(args)
+	}
+	return "<code>" + text + "</code>"
+}
+
+// help справка по команде для ответа на ошибку в аргументах
+func (c *command) help(lang string) string {
+	text := i18n.T(lang, "command.usage", c.usage(lang))
+	if details := i18n.T(lang, "cmd."+c.name+".details"); details != "" {
+		text += "\n\n" + details
+	}
+	return text
+}
+
+// help команда /help: команды, доступные в этом чате
+func (h *Handler) help(ctx context.Context, msg *telego.Message, _ commandArgs) error {
+	lang := h.lang(msg.Chat.ID, msg.From)
+	var text strings.Builder
+	text.WriteString(i18n.T(lang, "help.title") + "\n")
+	for _, cmd := range h.commands {
+		if !cmd.availableIn(msg.Chat) {
+			continue
+		}
+		fmt.Fprintf(&text, "\n%s — %s", cmd.usage(lang), html.EscapeString(cmd.describe(lang)))
+		if !slices.Contains(cmd.scopes, scopeGroups) && slices.Contains(cmd.scopes, scopeGroupAdmins) &&
+			msg.Chat.Type != telego.ChatTypePrivate {
+			text.WriteString(i18n.T(lang, "help.admins"))
+		}
+	}
+	h.reply(ctx, msg, text.String(), nil)
+	return nil
+}
+
+// PublishCommands публикует меню команд для каждой области видимости и языка, чтобы
+// меню Telegram совпадало с реестром. Области без команд очищаются
+func (h *Handler) PublishCommands() error {
+	targets := []scope{scopePrivate, scopeGroups, scopeGroupAdmins}
+	for _, cmd := range h.commands {
+		for _, s := range cmd.scopes {
+			if s.kind == telego.ScopeTypeChat && !slices.Contains(targets, s) {
+				targets = append(targets, s)
+				if s.chatID < 0 {
+					// меню чата перекрывает меню всех администраторов, поэтому для
+					// администраторов этого чата нужен свой список
+					targets = append(targets, scope{kind: telego.ScopeTypeChatAdministrators, chatID: s.chatID})
+				}
+			}
+		}
+	}
+
+	for _, target := range targets {
+		for i, language := range i18n.Languages() {
+			params := &telego.SetMyCommandsParams{Scope: target.botScope()}
+			// язык по умолчанию публикуется и без language_code для всех остальных языков
+			codes := []string{language}
+			if i == 0 {
+				codes = append(codes, "")
+			}
+			for _, cmd := range h.commands {
+				if target.shows(cmd) {
+					params.Commands = append(params.Commands, telego.BotCommand{Command: cmd.name, Description: cmd.describe(language)})
+				}
+			}
+			for _, code := range codes {
+				var err error
+				if len(params.Commands) == 0 {
+					err = h.api.DeleteMyCommands(&telego.DeleteMyCommandsParams{Scope: target.botScope(), LanguageCode: code})
+				} else {
+					params.LanguageCode = code
+					err = h.api.SetMyCommands(params)
+				}
+				if err != nil {
+					return fmt.Errorf("publish %s commands: %w", target.kind, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// shows показывать ли команду в меню этой области. Telegram показывает меню самой
+// узкой подходящей области, поэтому меню администраторов включает команды групп
+func (s scope) shows(cmd *command) bool {
+	has := func(other scope) bool { return slices.Contains(cmd.scopes, other) }
+	switch s.kind {
+	case telego.ScopeTypeAllGroupChats:
+		return has(scopeGroups)
+	case telego.ScopeTypeAllChatAdministrators:
+		return has(scopeGroups) || has(scopeGroupAdmins)
+	case telego.ScopeTypeChat:
+		if s.chatID > 0 {
+			return has(s) || has(scopePrivate)
+		}
+		return has(s) || has(scopeGroups)
+	case telego.ScopeTypeChatAdministrators:
+		return has(scopeChat(s.chatID)) || has(scopeGroups) || has(scopeGroupAdmins)
+	}
+	return has(s)
+}
+
+func (s scope) botScope() telego.BotCommandScope {
+	switch s.kind {
+	case telego.ScopeTypeAllPrivateChats:
+		return tu.ScopeAllPrivateChats()
+	case telego.ScopeTypeAllGroupChats:
+		return tu.ScopeAllGroupChats()
+	case telego.ScopeTypeAllChatAdministrators:
+		return tu.ScopeAllChatAdministrators()
+	case telego.ScopeTypeChat:
+		return tu.ScopeChat(tu.ID(s.chatID))
+	case telego.ScopeTypeChatAdministrators:
+		return tu.ScopeChatAdministrators(tu.ID(s.chatID))
+	}
+	return tu.ScopeDefault()
+}
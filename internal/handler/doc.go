@@ -0,0 +1,3 @@
+package handler
+
+// команды и интерактивные функции бота: поиск, inline-режим, кнопки
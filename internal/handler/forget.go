@@ -0,0 +1,220 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"tg-archive-bot/internal/i18n"
+	"tg-archive-bot/internal/log"
+	"tg-archive-bot/internal/purge"
+
+	"github.com/mymmrac/telego"
+	tu "github.com/mymmrac/telego/telegoutil"
+	"go.uber.org/zap"
+)
+
+const (
+	forgetCallbackPrefix = "forget:"
+	// forgetTTL время, за которое нужно подтвердить удаление
+	forgetTTL = 10 * time.Minute
+)
+
+// forgetRequest запрос на удаление, ожидающий подтверждения
+type forgetRequest struct {
+	requester int64
+	userID    int64
+	chatID    int64
+	action    string
+	expires   time.Time
+}
+
+// forgetRequests запросы на удаление по идентификатору из callback data
+type forgetRequests struct {
+	mu   sync.Mutex
+	byID map[string]forgetRequest
+}
+
+func (r *forgetRequests) add(req forgetRequest) string {
+	buf := make([]byte, 6)
+	_, _ = rand.Read(buf)
+	id := hex.EncodeToString(buf)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.byID == nil {
+		r.byID = make(map[string]forgetRequest)
+	}
+	now := time.Now()
+	for key, old := range r.byID {
+		if now.After(old.expires) {
+			delete(r.byID, key)
+		}
+	}
+	req.expires = now.Add(forgetTTL)
+	r.byID[id] = req
+	return id
+}
+
+// take возвращает запрос и удаляет его, чтобы подтверждение нельзя было нажать дважды.
+// Чужой запрос не удаляется, own для него false
+func (r *forgetRequests) take(id string, userID int64) (req forgetRequest, ok, own bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	req, ok = r.byID[id]
+	if !ok || time.Now().After(req.expires) {
+		delete(r.byID, id)
+		return forgetRequest{}, false, false
+	}
+	if req.requester != userID {
+		return forgetRequest{}, true, false
+	}
+	delete(r.byID, id)
+	return req, true, true
+}
+
+// forgetMe команда /forgetme в личном чате: удалить все сообщения пользователя из архива
+func (h *Handler) forgetMe(ctx context.Context, msg *telego.Message, _ commandArgs) error {
+	return h.confirmForget(ctx, msg, forgetRequest{
+		requester: msg.From.ID,
+		userID:    msg.From.ID,
+		action:    purge.ActionForgetMe,
+	})
+}
+
+// purgeUser команда /purge в группе: администратор удаляет сообщения пользователя
+// в этом чате. Пользователь задается ответом на его сообщение или идентификатором
+func (h *Handler) purgeUser(ctx context.Context, msg *telego.Message, args commandArgs) error {
+	var userID int64
+	switch {
+	case len(args.fields) == 1:
+		id, err := strconv.ParseInt(args.fields[0], 10, 64)
+		if err != nil || id <= 0 {
+			return errUsage
+		}
+		userID = id
+	case msg.ReplyToMessage != nil && msg.ReplyToMessage.From != nil:
+		userID = msg.ReplyToMessage.From.ID
+	default:
+		return errUsage
+	}
+
+	if !h.requireAdmin(ctx, msg, h.lang(msg.Chat.ID, msg.From)) {
+		return nil
+	}
+	return h.confirmForget(ctx, msg, forgetRequest{
+		requester: msg.From.ID,
+		userID:    userID,
+		chatID:    msg.Chat.ID,
+		action:    purge.ActionPurge,
+	})
+}
+
+// confirmForget показывает, что будет удалено, и спрашивает подтверждение
+func (h *Handler) confirmForget(ctx context.Context, msg *telego.Message, req forgetRequest) error {
+	lang := h.lang(msg.Chat.ID, msg.From)
+	plan, err := purge.Prepare(h.store, req.userID, req.chatID, h.held)
+	if err != nil {
+		return fmt.Errorf("prepare purge: %w", err)
+	}
+	if plan.Empty() {
+		h.reply(ctx, msg, i18n.T(lang, "forget.nothing"), nil)
+		return nil
+	}
+
+	id := h.forgets.add(req)
+	markup := tu.InlineKeyboard(tu.InlineKeyboardRow(
+		tu.InlineKeyboardButton(i18n.T(lang, "forget.confirm")).WithCallbackData(forgetCallbackPrefix+"yes:"+id),
+		tu.InlineKeyboardButton(i18n.T(lang, "forget.cancel")).WithCallbackData(forgetCallbackPrefix+"no:"+id),
+	))
+	h.reply(ctx, msg, describePlan(lang, plan)+"\n\n"+i18n.T(lang, "forget.warning"), markup)
+	return nil
+}
+
+// forgetCallback подтверждение или отмена удаления
+func (h *Handler) forgetCallback(ctx context.Context, query *telego.CallbackQuery) {
+	lang := h.callbackLang(query)
+	answer, id, _ := strings.Cut(strings.TrimPrefix(query.Data, forgetCallbackPrefix), ":")
+
+	req, ok, own := h.forgets.take(id, query.From.ID)
+	switch {
+	case !ok:
+		h.answer(ctx, query, i18n.T(lang, "forget.expired"))
+		return
+	case !own:
+		h.answer(ctx, query, i18n.T(lang, "forget.not_requester"))
+		return
+	}
+	if answer != "yes" {
+		h.answer(ctx, query, "")
+		h.editCallbackMessage(ctx, query, i18n.T(lang, "forget.cancelled"))
+		return
+	}
+
+	logger := log.FromContext(ctx)
+	// план составляется заново: за время подтверждения могли прийти новые сообщения
+	plan, err := purge.Prepare(h.store, req.userID, req.chatID, h.held)
+	if err != nil {
+		logger.Error("prepare purge", zap.Error(err))
+		h.answer(ctx, query, i18n.T(lang, "forget.failed"))
+		return
+	}
+	var requestedBy int64
+	if req.action == purge.ActionPurge {
+		requestedBy = req.requester
+	}
+	audit, err := purge.Apply(h.store, h.index, plan, req.action, requestedBy)
+	if err != nil {
+		logger.Error("purge user data", zap.Error(err))
+	}
+	// копии сообщений в результатах поиска живут до sessionTTL, их нельзя листать после удаления.
+	// При частичной ошибке большая часть сообщений тоже удалена
+	h.sessions.Forget(plan.Messages)
+	logger.Info("user data purged", zap.String("action", req.action),
+		zap.Int("messages", audit.Messages), zap.Int("blobs", audit.Blobs), zap.Bool("profile", audit.Profile))
+
+	text := i18n.N(lang, "forget.done", audit.Messages, audit.Messages, audit.Blobs)
+	if err != nil {
+		text += "\n" + i18n.T(lang, "forget.partial")
+	}
+	h.answer(ctx, query, "")
+	h.editCallbackMessage(ctx, query, text)
+}
+
+// describePlan что будет удалено, без содержимого сообщений
+func describePlan(lang string, plan *purge.Plan) string {
+	lines := []string{
+		i18n.T(lang, "forget.plan_title"),
+		"• " + i18n.N(lang, "forget.plan_messages", len(plan.Messages), len(plan.Messages), plan.Chats),
+		"• " + i18n.N(lang, "forget.plan_blobs", len(plan.Blobs), len(plan.Blobs)),
+	}
+	if plan.Profile {
+		lines = append(lines, "• "+i18n.T(lang, "forget.plan_profile"))
+	}
+	if plan.HeldChats > 0 {
+		lines = append(lines, i18n.N(lang, "forget.plan_held", plan.HeldChats, plan.HeldChats))
+	}
+	lines = append(lines, i18n.T(lang, "forget.plan_reactions"))
+	return strings.Join(lines, "\n")
+}
+
+// editCallbackMessage заменяет текст сообщения с кнопками, убирая кнопки
+func (h *Handler) editCallbackMessage(ctx context.Context, query *telego.CallbackQuery, text string) {
+	if query.Message == nil || !query.Message.IsAccessible() {
+		return
+	}
+	_, err := h.api.EditMessageText(&telego.EditMessageTextParams{
+		ChatID:    tu.ID(query.Message.GetChat().ID),
+		MessageID: query.Message.GetMessageID(),
+		Text:      text,
+		ParseMode: telego.ModeHTML,
+	})
+	if err != nil {
+		log.FromContext(ctx).Error("edit message", zap.Error(err))
+	}
+}
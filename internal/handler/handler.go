@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"context"
+	"strings"
+
+	"tg-archive-bot/internal/access"
+	"tg-archive-bot/internal/archive"
+	"tg-archive-bot/internal/config"
+	"tg-archive-bot/internal/log"
+	"tg-archive-bot/internal/search"
+
+	"github.com/mymmrac/telego"
+	tu "github.com/mymmrac/telego/telegoutil"
+	"go.uber.org/zap"
+)
+
+// Handler обрабатывает команды, нажатия кнопок и inline-запросы
+type Handler struct {
+	name      string
+	api       *telego.Bot
+	username  string
+	store     *archive.Store
+	index     *search.Index
+	archiving *access.Archiving
+	// policy правила хранения чата из конфигурации
+	policy   func(chatID int64) config.RetentionPolicy
+	members  *members
+	admins   *admins
+	sessions *sessions
+	setup    setupChecks
+	forgets  forgetRequests
+	commands []*command
+}
+
+// New создает обработчик команд, name значение метки bot в метриках, username нужен, чтобы отличать свои команды от команд других ботов.
+// policy правила хранения из конфигурации: legal_hold запрещает /forgetme и /purge удалять сообщения,
+// а max_age_days ограничивает срок, который выбирают администраторы чата
+func New(name string, api *telego.Bot, username string, store *archive.Store, index *search.Index, archiving *access.Archiving, policy func(chatID int64) config.RetentionPolicy) *Handler {
+	h := &Handler{
+		name:      name,
+		api:       api,
+		username:  username,
+		store:     store,
+		index:     index,
+		archiving: archiving,
+		policy:    policy,
+		members:   newMembers(api),
+		admins:    newAdmins(api),
+		sessions:  newSessions(),
+	}
+	h.commands = h.registerCommands()
+	return h
+}
+
+// held удаление данных из чата запрещено legal_hold
+func (h *Handler) held(chatID int64) bool {
+	return h.policy(chatID).LegalHold
+}
+
+// HandleUpdate выполняет команду из сообщения или обрабатывает нажатие кнопки
+func (h *Handler) HandleUpdate(ctx context.Context, update telego.Update) {
+	switch {
+	case update.Message != nil:
+		h.handleCommand(ctx, update.Message)
+	case update.CallbackQuery != nil:
+		h.handleCallback(ctx, update.CallbackQuery)
+	case update.InlineQuery != nil:
+		h.inlineQuery(ctx, update.InlineQuery)
+	case update.ChosenInlineResult != nil:
+		h.chosenInlineResult(ctx, update.ChosenInlineResult)
+	case update.MyChatMember != nil:
+		h.admins.Forget(update.MyChatMember.Chat.ID)
+		h.onboard(ctx, update.MyChatMember)
+	}
+}
+
+func (h *Handler) handleCallback(ctx context.Context, query *telego.CallbackQuery) {
+	switch {
+	case isSearchCallback(query.Data):
+		h.searchPage(ctx, query)
+	case strings.HasPrefix(query.Data, setupCallbackPrefix):
+		h.setupCallback(ctx, query)
+	case strings.HasPrefix(query.Data, forgetCallbackPrefix):
+		h.forgetCallback(ctx, query)
+	default:
+		h.answer(ctx, query, "")
+	}
+}
+
+// reply отвечает на сообщение текстом в HTML
+func (h *Handler) reply(ctx context.Context, msg *telego.Message, text string, markup *telego.InlineKeyboardMarkup) {
+	params := tu.Message(tu.ID(msg.Chat.ID), text).
+		WithParseMode(telego.ModeHTML).
+		WithLinkPreviewOptions(&telego.LinkPreviewOptions{IsDisabled: true}).
+		WithReplyParameters(&telego.ReplyParameters{MessageID: msg.MessageID, AllowSendingWithoutReply: true})
+	if msg.IsTopicMessage {
+		params = params.WithMessageThreadID(msg.MessageThreadID)
+	}
+	if markup != nil {
+		params = params.WithReplyMarkup(markup)
+	}
+	if _, err := h.api.SendMessage(params); err != nil {
+		log.FromContext(ctx).Error("send message", zap.Int64("chat_id", msg.Chat.ID), zap.Error(err))
+	}
+}
+
+// answer отвечает на нажатие кнопки, text показывается всплывающим уведомлением
+func (h *Handler) answer(ctx context.Context, query *telego.CallbackQuery, text string) {
+	params := tu.CallbackQuery(query.ID)
+	if text != "" {
+		params = params.WithText(text)
+	}
+	if err := h.api.AnswerCallbackQuery(params); err != nil {
+		log.FromContext(ctx).Error("answer callback query", zap.Error(err))
+	}
+}
@@ -0,0 +1,156 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+
+	"tg-archive-bot/internal/archive"
+	"tg-archive-bot/internal/log"
+	"tg-archive-bot/internal/metrics"
+	"tg-archive-bot/internal/query"
+
+	"github.com/mymmrac/telego"
+	"go.uber.org/zap"
+)
+
+const (
+	// inlinePageSize максимальное количество результатов в одном ответе Bot API
+	inlinePageSize = 50
+	// inlineCacheTime секунды, которые Telegram кэширует персональный ответ
+	inlineCacheTime = 30
+)
+
+// inlineChosen отправленные через inline-режим сообщения по типу результата
+var inlineChosen = metrics.NewCounterVec("tgarchive_inline_chosen_total",
+	"Отправленные через inline-режим сообщения по типу результата", "bot", "kind")
+
+// inlineQuery поиск по архиву из любого чата: @bot запрос.
+// Ищем только в чатах, где состоит пользователь, ответ не кэшируется для других
+func (h *Handler) inlineQuery(ctx context.Context, iq *telego.InlineQuery) {
+	params := &telego.AnswerInlineQueryParams{
+		InlineQueryID: iq.ID,
+		Results:       []telego.InlineQueryResult{},
+		CacheTime:     inlineCacheTime,
+		IsPersonal:    true,
+	}
+	defer func() {
+		if err := h.api.AnswerInlineQuery(params); err != nil {
+			log.FromContext(ctx).Error("answer inline query", zap.Error(err))
+		}
+	}()
+
+	text := strings.TrimSpace(iq.Query)
+	if text == "" {
+		return
+	}
+	q, err := query.Parse(text)
+	if err != nil {
+		return
+	}
+	chats, err := h.userChats(iq.From.ID)
+	if err != nil {
+		log.FromContext(ctx).Error("list chats", zap.Error(err))
+		return
+	}
+	if len(chats) == 0 {
+		return
+	}
+	messages, err := query.Run(h.store, h.index, q, chats)
+	if err != nil {
+		return
+	}
+	messages = h.visible(iq.From.ID, messages)
+
+	offset, _ := strconv.Atoi(iq.Offset)
+	if offset < 0 || offset > len(messages) {
+		offset = len(messages)
+	}
+	end := min(offset+inlinePageSize, len(messages))
+	if end < len(messages) {
+		params.NextOffset = strconv.Itoa(end)
+	}
+
+	titles := make(map[int64]*archive.Chat)
+	for _, m := range messages[offset:end] {
+		chat, ok := titles[m.ChatID]
+		if !ok {
+			chat, _ = h.store.GetChat(m.ChatID)
+			titles[m.ChatID] = chat
+		}
+		params.Results = append(params.Results, inlineResult(chat, m))
+	}
+}
+
+// inlineResult выбирает тип результата: сохраненные фото и документы отправляются
+// по file_id, остальное текстом со ссылкой на оригинал
+func inlineResult(chat *archive.Chat, m *archive.Message) telego.InlineQueryResult {
+	id := fmt.Sprintf("%d_%d", m.ChatID, m.ID)
+	title := m.FromName
+	if chat != nil && chat.Title != "" {
+		title += " · " + chat.Title
+	}
+	caption := truncate(m.Text, 1024)
+
+	for _, media := range m.Media {
+		if media.FileID == "" {
+			continue
+		}
+		switch media.Kind {
+		case archive.MediaPhoto:
+			return &telego.InlineQueryResultCachedPhoto{
+				Type:        telego.ResultTypePhoto,
+				ID:          id,
+				PhotoFileID: media.FileID,
+				Title:       title,
+				Description: snippet(m),
+				Caption:     caption,
+			}
+		case archive.MediaDocument:
+			return &telego.InlineQueryResultCachedDocument{
+				Type:           telego.ResultTypeDocument,
+				ID:             id,
+				Title:          media.FileName,
+				DocumentFileID: media.FileID,
+				Description:    title,
+				Caption:        caption,
+			}
+		}
+	}
+
+	text := html.EscapeString(truncate(snippetText(m), 3500))
+	if link := messageLink(chat, m); link != "" {
+		text += fmt.Sprintf("\n\n<a href=\"%s\">%s</a>", link, html.EscapeString(title))
+	} else {
+		text += "\n\n— " + html.EscapeString(title)
+	}
+	return &telego.InlineQueryResultArticle{
+		Type:        telego.ResultTypeArticle,
+		ID:          id,
+		Title:       title,
+		Description: snippet(m),
+		InputMessageContent: &telego.InputTextMessageContent{
+			MessageText:        text,
+			ParseMode:          telego.ModeHTML,
+			LinkPreviewOptions: &telego.LinkPreviewOptions{IsDisabled: true},
+		},
+	}
+}
+
+// chosenInlineResult учитывает отправленный из inline-режима результат
+func (h *Handler) chosenInlineResult(ctx context.Context, result *telego.ChosenInlineResult) {
+	chatText, msgText, _ := strings.Cut(result.ResultID, "_")
+	chatID, _ := strconv.ParseInt(chatText, 10, 64)
+	messageID, _ := strconv.Atoi(msgText)
+
+	kind := telego.ResultTypeArticle
+	if m, err := h.store.GetMessage(chatID, messageID); err == nil {
+		kind = inlineResult(nil, m).ResultType()
+	}
+	inlineChosen.Inc(h.name, kind)
+
+	log.FromContext(ctx).Info("inline result chosen", zap.Int64("user_id", result.From.ID), zap.Int64("chat_id", chatID),
+		zap.Int("message_id", messageID), zap.String("kind", kind))
+}
@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"tg-archive-bot/internal/access"
+	"tg-archive-bot/internal/i18n"
+	"tg-archive-bot/internal/log"
+
+	"github.com/mymmrac/telego"
+	"go.uber.org/zap"
+)
+
+// lang язык ответа: выбранный для чата, иначе язык пользователя, иначе язык по умолчанию
+func (h *Handler) lang(chatID int64, user *telego.User) string {
+	var userLang string
+	if user != nil {
+		userLang = user.LanguageCode
+	}
+	return i18n.Pick(h.archiving.Get(chatID).Language, userLang)
+}
+
+// callbackLang язык ответа на нажатие кнопки
+func (h *Handler) callbackLang(query *telego.CallbackQuery) string {
+	var chatID int64
+	if query.Message != nil {
+		chatID = query.Message.GetChat().ID
+	}
+	return h.lang(chatID, &query.From)
+}
+
+// language команда /language: язык ответов бота в чате
+func (h *Handler) language(ctx context.Context, msg *telego.Message, args commandArgs) error {
+	if len(args.fields) == 0 {
+		h.reply(ctx, msg, i18n.T(h.lang(msg.Chat.ID, msg.From), "language.current"), nil)
+		return nil
+	}
+
+	choice := args.fields[0]
+	if choice != "auto" {
+		lang, ok := i18n.Supported(choice)
+		if !ok {
+			return errUsage
+		}
+		choice = lang
+	}
+	if !h.requireAdmin(ctx, msg, h.lang(msg.Chat.ID, msg.From)) {
+		return nil
+	}
+
+	_, err := h.archiving.Update(msg.Chat.ID, func(c *access.ChatArchiving) {
+		c.Language = choice
+		if choice == "auto" {
+			c.Language = ""
+		}
+		c.ChangedBy = msg.From.ID
+		c.ChangedAt = time.Now()
+	})
+	lang := h.lang(msg.Chat.ID, msg.From)
+	if err != nil {
+		h.reply(ctx, msg, i18n.T(lang, "settings.save_failed"), nil)
+		return fmt.Errorf("save chat language: %w", err)
+	}
+	log.FromContext(ctx).Info("chat language changed", zap.String("language", choice))
+
+	if choice == "auto" {
+		h.reply(ctx, msg, i18n.T(lang, "language.auto"), nil)
+	} else {
+		h.reply(ctx, msg, i18n.T(lang, "language.changed"), nil)
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package handler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"tg-archive-bot/internal/archive"
+)
+
+// messageLink ссылка на сообщение в Telegram. Для обычных групп и личных чатов
+// ссылок не бывает, возвращается пустая строка
+func messageLink(chat *archive.Chat, m *archive.Message) string {
+	var base string
+	switch {
+	case chat != nil && chat.Username != "" && chat.Type != "private":
+		base = "https://t.me/" + chat.Username
+	case strings.HasPrefix(strconv.FormatInt(m.ChatID, 10), "-100"):
+		base = "https://t.me/c/" + strings.TrimPrefix(strconv.FormatInt(m.ChatID, 10), "-100")
+	default:
+		return ""
+	}
+
+	if m.ThreadID != 0 {
+		return fmt.Sprintf("%s/%d/%d", base, m.ThreadID, m.ID)
+	}
+	return fmt.Sprintf("%s/%d", base, m.ID)
+}
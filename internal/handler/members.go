@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mymmrac/telego"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+// membersTTL время, в течение которого ответ GetChatMember считается актуальным
+const membersTTL = 10 * time.Minute
+
+type memberKey struct {
+	chatID int64
+	userID int64
+}
+
+type memberEntry struct {
+	isMember bool
+	expires  time.Time
+}
+
+// members кэш проверок членства пользователей в чатах
+type members struct {
+	api *telego.Bot
+
+	mu    sync.Mutex
+	cache map[memberKey]memberEntry
+}
+
+func newMembers(api *telego.Bot) *members {
+	return &members{
+		api:   api,
+		cache: make(map[memberKey]memberEntry),
+	}
+}
+
+// IsMember проверяет, состоит ли пользователь в чате. Ошибки API (бот удален из чата
+// и т.п.) считаются отказом
+func (c *members) IsMember(chatID, userID int64) bool {
+	key := memberKey{chatID: chatID, userID: userID}
+
+	c.mu.Lock()
+	entry, ok := c.cache[key]
+	c.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.isMember
+	}
+
+	entry = memberEntry{expires: time.Now().Add(membersTTL)}
+	member, err := c.api.GetChatMember(&telego.GetChatMemberParams{ChatID: tu.ID(chatID), UserID: userID})
+	if err == nil {
+		entry.isMember = member.MemberIsMember()
+	}
+
+	c.mu.Lock()
+	c.cache[key] = entry
+	c.mu.Unlock()
+	return entry.isMember
+}
@@ -0,0 +1,250 @@
+package handler
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"tg-archive-bot/internal/access"
+	"tg-archive-bot/internal/config"
+	"tg-archive-bot/internal/i18n"
+	"tg-archive-bot/internal/log"
+
+	"github.com/mymmrac/telego"
+	tu "github.com/mymmrac/telego/telegoutil"
+	"go.uber.org/zap"
+)
+
+const setupCallbackPrefix = "setup:"
+
+// setupChecks последние найденные проблемы с правами бота по чатам, чтобы не повторять
+// одно и то же сообщение при каждом изменении прав
+type setupChecks struct {
+	mu       sync.Mutex
+	problems map[int64]string
+}
+
+// swap запоминает проблемы чата и возвращает предыдущие
+func (s *setupChecks) swap(chatID int64, problems string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.problems == nil {
+		s.problems = make(map[int64]string)
+	}
+	prev, ok := s.problems[chatID]
+	s.problems[chatID] = problems
+	return prev, ok
+}
+
+func (s *setupChecks) forget(chatID int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.problems, chatID)
+}
+
+// onboard проверяет права бота, когда его добавляют в группу или меняют ему права,
+// и объясняет администраторам, что нужно настроить
+func (h *Handler) onboard(ctx context.Context, upd *telego.ChatMemberUpdated) {
+	if !access.IsPresent(upd.NewChatMember) {
+		h.setup.forget(upd.Chat.ID)
+		return
+	}
+	h.checkSetup(ctx, upd.Chat, h.lang(upd.Chat.ID, &upd.From), !access.IsPresent(upd.OldChatMember))
+}
+
+// Onboard объясняет настройку чата, который владелец одобрил уже после добавления бота:
+// обновление о добавлении пришло, пока чат ждал решения, и до команд не дошло
+func (h *Handler) Onboard(ctx context.Context, chat telego.Chat) {
+	h.checkSetup(log.WithLogger(ctx, log.FromContext(ctx).With(zap.Int64("chat_id", chat.ID))), chat, h.lang(chat.ID, nil), true)
+}
+
+// checkSetup сообщает в чат о недостающих правах бота. joined — бот только что добавлен,
+// тогда отправляется полное описание настройки с кнопками
+func (h *Handler) checkSetup(ctx context.Context, chat telego.Chat, lang string, joined bool) {
+	if chat.Type != telego.ChatTypeGroup && chat.Type != telego.ChatTypeSupergroup {
+		return
+	}
+
+	logger := log.FromContext(ctx)
+	problems, canPost, err := h.setupProblems(chat.ID)
+	if err != nil {
+		logger.Error("check bot rights", zap.Error(err))
+		return
+	}
+	if !canPost {
+		logger.Warn("bot cannot send messages to chat")
+		return
+	}
+	key := strings.Join(problems, "\n")
+	prev, known := h.setup.swap(chat.ID, key)
+
+	var text string
+	var markup *telego.InlineKeyboardMarkup
+	switch {
+	case joined || !known:
+		text, markup = setupMessage(lang, problems), setupKeyboard(lang)
+	case key == prev:
+		return
+	case len(problems) == 0:
+		text = i18n.T(lang, "setup.fixed")
+	default:
+		text = i18n.T(lang, "setup.changed", bullets(lang, problems))
+	}
+	logger.Info("chat setup checked", zap.Bool("joined", joined), zap.Int("problems", len(problems)))
+
+	params := tu.Message(tu.ID(chat.ID), text).WithParseMode(telego.ModeHTML)
+	if markup != nil {
+		params = params.WithReplyMarkup(markup)
+	}
+	if _, err = h.api.SendMessage(params); err != nil {
+		logger.Error("send message", zap.Int64("chat_id", chat.ID), zap.Error(err))
+	}
+}
+
+// setupProblems ключи описаний прав бота в чате, которых не хватает для полного архива.
+// canPost false, если бот не может писать в чат
+func (h *Handler) setupProblems(chatID int64) (problems []string, canPost bool, err error) {
+	// GetMe каждый раз: режим приватности меняется в @BotFather без перезапуска бота
+	me, err := h.api.GetMe()
+	if err != nil {
+		return nil, false, err
+	}
+	member, err := h.api.GetChatMember(&telego.GetChatMemberParams{ChatID: tu.ID(chatID), UserID: me.ID})
+	if err != nil {
+		return nil, false, err
+	}
+
+	var admin bool
+	canPost = true
+	switch m := member.(type) {
+	case *telego.ChatMemberAdministrator:
+		admin = true
+		if !m.CanPinMessages {
+			problems = append(problems, "setup.problem_pin")
+		}
+	case *telego.ChatMemberRestricted:
+		canPost = m.CanSendMessages
+	}
+	if !admin {
+		if !me.CanReadAllGroupMessages {
+			problems = append(problems, "setup.problem_privacy")
+		}
+		problems = append(problems, "setup.problem_reactions")
+	}
+	return problems, canPost, nil
+}
+
+func setupMessage(lang string, problems []string) string {
+	text := i18n.T(lang, "setup.intro") + "\n\n"
+	if len(problems) > 0 {
+		text += i18n.T(lang, "setup.todo", bullets(lang, problems)) + "\n\n"
+	} else {
+		text += i18n.T(lang, "setup.all_good") + "\n\n"
+	}
+	return text + i18n.T(lang, "setup.outro")
+}
+
+func setupKeyboard(lang string) *telego.InlineKeyboardMarkup {
+	button := func(text, data string) telego.InlineKeyboardButton {
+		return tu.InlineKeyboardButton(text).WithCallbackData(setupCallbackPrefix + data)
+	}
+	return tu.InlineKeyboard(
+		tu.InlineKeyboardRow(
+			button(i18n.T(lang, "setup.scope_all"), "scope:all"),
+			button(i18n.T(lang, "setup.scope_text"), "scope:text"),
+			button(i18n.T(lang, "setup.scope_off"), "scope:off"),
+		),
+		tu.InlineKeyboardRow(
+			button(i18n.T(lang, "setup.keep_forever"), "keep:0"),
+			button(i18n.T(lang, "setup.keep_year"), "keep:365"),
+			button(i18n.N(lang, "setup.keep_days", 90, 90), "keep:90"),
+		),
+	)
+}
+
+// setupCallback нажатие кнопки в сообщении о настройке чата
+func (h *Handler) setupCallback(ctx context.Context, query *telego.CallbackQuery) {
+	if query.Message == nil {
+		h.answer(ctx, query, "")
+		return
+	}
+	chatID := query.Message.GetChat().ID
+	lang := h.callbackLang(query)
+	if key, ok := h.checkAdmin(ctx, chatID, &query.From, nil); !ok {
+		h.answer(ctx, query, i18n.T(lang, key))
+		return
+	}
+
+	option, value, _ := strings.Cut(strings.TrimPrefix(query.Data, setupCallbackPrefix), ":")
+	var change func(c *access.ChatArchiving)
+	var result string
+	switch option {
+	case "scope":
+		switch value {
+		case "all":
+			change = func(c *access.ChatArchiving) { c.Disabled, c.TextOnly, c.PausedUntil = false, false, time.Time{} }
+			result = i18n.T(lang, "setup.result_all")
+		case "text":
+			change = func(c *access.ChatArchiving) { c.Disabled, c.TextOnly, c.PausedUntil = false, true, time.Time{} }
+			result = i18n.T(lang, "setup.result_text")
+		case "off":
+			change = func(c *access.ChatArchiving) { c.Disabled = true }
+			result = i18n.T(lang, "setup.result_off")
+		}
+	case "keep":
+		days, err := strconv.Atoi(value)
+		if err == nil && days >= 0 {
+			change = func(c *access.ChatArchiving) { c.RetentionDays = days }
+			result = retentionResult(lang, days, h.policy(chatID))
+		}
+	}
+	if change == nil {
+		h.answer(ctx, query, "")
+		return
+	}
+
+	_, err := h.archiving.Update(chatID, func(c *access.ChatArchiving) {
+		change(c)
+		c.ChangedBy = query.From.ID
+		c.ChangedAt = time.Now()
+	})
+	if err != nil {
+		log.FromContext(ctx).Error("save archiving state", zap.Error(err))
+		h.answer(ctx, query, i18n.T(lang, "settings.save_failed"))
+		return
+	}
+	log.FromContext(ctx).Info("chat setup changed", zap.String("option", option), zap.String("value", value))
+	h.answer(ctx, query, result)
+}
+
+// retentionResult ответ на выбор срока хранения. Срок из конфигурации короче выбранного
+// продолжает действовать, max_mb удаляет старые сообщения при любом сроке, а под legal_hold
+// сообщения не удаляются вовсе
+func retentionResult(lang string, days int, policy config.RetentionPolicy) string {
+	var result string
+	switch {
+	case policy.LegalHold:
+		return i18n.T(lang, "setup.result_held")
+	case policy.MaxAgeDays > 0 && (days == 0 || days > policy.MaxAgeDays):
+		result = i18n.N(lang, "setup.result_limited", policy.MaxAgeDays, policy.MaxAgeDays)
+	case days == 0:
+		result = i18n.T(lang, "setup.result_forever")
+	default:
+		result = i18n.N(lang, "setup.result_days", days, days)
+	}
+	if policy.MaxMB > 0 {
+		result += " " + i18n.T(lang, "setup.result_max_mb", policy.MaxMB)
+	}
+	return result
+}
+
+// bullets список строк каталога по ключам
+func bullets(lang string, keys []string) string {
+	items := make([]string, len(keys))
+	for i, key := range keys {
+		items[i] = "• " + i18n.T(lang, key)
+	}
+	return strings.Join(items, "\n")
+}
@@ -0,0 +1,30 @@
+package handler
+
+import (
+	"testing"
+
+	"tg-archive-bot/internal/config"
+)
+
+// Ответ на выбор срока называет ограничение из конфигурации, которое продолжает действовать
+func TestRetentionResult(t *testing.T) {
+	tests := []struct {
+		days   int
+		policy config.RetentionPolicy
+		want   string
+	}{
+		{0, config.RetentionPolicy{}, "The archive is kept forever."},
+		{90, config.RetentionPolicy{}, "Messages are kept for 90 days."},
+		{90, config.RetentionPolicy{MaxAgeDays: 365}, "Messages are kept for 90 days."},
+		{0, config.RetentionPolicy{MaxAgeDays: 30}, "The bot's settings keep messages for at most 30 days, older ones are deleted."},
+		{365, config.RetentionPolicy{MaxAgeDays: 30}, "The bot's settings keep messages for at most 30 days, older ones are deleted."},
+		{0, config.RetentionPolicy{MaxMB: 500},
+			"The archive is kept forever. Old messages are also deleted once the chat archive exceeds 500 MB."},
+		{90, config.RetentionPolicy{MaxAgeDays: 30, LegalHold: true}, "The chat is under legal hold: messages are not deleted."},
+	}
+	for _, tt := range tests {
+		if got := retentionResult("en", tt.days, tt.policy); got != tt.want {
+			t.Errorf("keep %d with %+v: %q, want %q", tt.days, tt.policy, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+	"time"
+
+	"tg-archive-bot/internal/archive"
+	"tg-archive-bot/internal/i18n"
+	"tg-archive-bot/internal/log"
+	"tg-archive-bot/internal/query"
+
+	"github.com/mymmrac/telego"
+	tu "github.com/mymmrac/telego/telegoutil"
+	"go.uber.org/zap"
+)
+
+const (
+	searchPageSize       = 5
+	searchSnippetLen     = 200
+	searchCallbackPrefix = "search:"
+)
+
+// search команда /search: в группе ищет по этому чату, в личке по всем чатам, где состоит пользователь
+func (h *Handler) search(ctx context.Context, msg *telego.Message, args commandArgs) error {
+	lang := h.lang(msg.Chat.ID, msg.From)
+	payload := args.raw
+	q, err := query.Parse(payload)
+	if err != nil {
+		h.reply(ctx, msg, i18n.T(lang, "search.query_error", html.EscapeString(err.Error())), nil)
+		return nil
+	}
+
+	chats, err := h.accessibleChats(msg.Chat, msg.From.ID)
+	if err != nil {
+		return fmt.Errorf("list chats: %w", err)
+	}
+	if len(chats) == 0 {
+		h.reply(ctx, msg, i18n.T(lang, "search.no_archives"), nil)
+		return nil
+	}
+
+	messages, err := query.Run(h.store, h.index, q, chats)
+	if err != nil {
+		h.reply(ctx, msg, i18n.T(lang, "search.query_error", html.EscapeString(err.Error())), nil)
+		return nil
+	}
+	messages = h.visible(msg.From.ID, messages)
+	if len(messages) == 0 {
+		h.reply(ctx, msg, i18n.T(lang, "search.not_found"), nil)
+		return nil
+	}
+
+	id := h.sessions.Add(msg.From.ID, lang, payload, messages)
+	sess, _ := h.sessions.Get(id)
+	text, markup := h.renderSearchPage(sess, id, 0)
+	h.reply(ctx, msg, text, markup)
+	return nil
+}
+
+// accessibleChats чаты архива, в которых можно искать из чата chat. Из лички это
+// все чаты, в которых пользователь может состоять: найденное фильтрует visible
+func (h *Handler) accessibleChats(chat telego.Chat, userID int64) ([]int64, error) {
+	if chat.Type != telego.ChatTypePrivate {
+		if !h.members.IsMember(chat.ID, userID) {
+			return nil, nil
+		}
+		return []int64{chat.ID}, nil
+	}
+
+	return h.userChats(userID)
+}
+
+// userChats чаты архива, в которых может состоять пользователь: его личный чат с ботом
+// и все группы. Членство проверяется позже и только для чатов с найденными сообщениями,
+// чтобы не запрашивать GetChatMember по каждому чату архива
+func (h *Handler) userChats(userID int64) ([]int64, error) {
+	ids, err := h.store.Chats()
+	if err != nil {
+		return nil, err
+	}
+	var chats []int64
+	for _, id := range ids {
+		if id == userID || id < 0 {
+			chats = append(chats, id)
+		}
+	}
+	return chats, nil
+}
+
+// visible оставляет сообщения чатов, в которых состоит пользователь
+func (h *Handler) visible(userID int64, messages []*archive.Message) []*archive.Message {
+	member := make(map[int64]bool)
+	result := messages[:0]
+	for _, m := range messages {
+		ok, checked := member[m.ChatID]
+		if !checked {
+			ok = m.ChatID == userID || h.members.IsMember(m.ChatID, userID)
+			member[m.ChatID] = ok
+		}
+		if ok {
+			result = append(result, m)
+		}
+	}
+	return result
+}
+
+func isSearchCallback(data string) bool {
+	return strings.HasPrefix(data, searchCallbackPrefix)
+}
+
+// searchPage листание результатов поиска, data вида search:<id>:<страница>
+func (h *Handler) searchPage(ctx context.Context, query *telego.CallbackQuery) {
+	id, pageText, _ := strings.Cut(strings.TrimPrefix(query.Data, searchCallbackPrefix), ":")
+	page, err := strconv.Atoi(pageText)
+	if err != nil {
+		h.answer(ctx, query, "")
+		return
+	}
+
+	sess, ok := h.sessions.Get(id)
+	if !ok {
+		h.answer(ctx, query, i18n.T(h.callbackLang(query), "search.expired"))
+		return
+	}
+	if sess.userID != query.From.ID {
+		h.answer(ctx, query, i18n.T(sess.lang, "search.not_author"))
+		return
+	}
+	if query.Message == nil || !query.Message.IsAccessible() {
+		h.answer(ctx, query, "")
+		return
+	}
+
+	text, markup := h.renderSearchPage(sess, id, page)
+	_, err = h.api.EditMessageText(&telego.EditMessageTextParams{
+		ChatID:             tu.ID(query.Message.GetChat().ID),
+		MessageID:          query.Message.GetMessageID(),
+		Text:               text,
+		ParseMode:          telego.ModeHTML,
+		LinkPreviewOptions: &telego.LinkPreviewOptions{IsDisabled: true},
+		ReplyMarkup:        markup,
+	})
+	if err != nil {
+		log.FromContext(ctx).Error("edit search results", zap.Error(err))
+	}
+	h.answer(ctx, query, "")
+}
+
+// renderSearchPage текст страницы результатов и кнопки листания
+func (h *Handler) renderSearchPage(sess *session, id string, page int) (string, *telego.InlineKeyboardMarkup) {
+	pages := (len(sess.messages) + searchPageSize - 1) / searchPageSize
+	page = max(0, min(page, pages-1))
+
+	var text strings.Builder
+	var more string
+	if len(sess.messages) == query.MaxResults {
+		more = "+"
+	}
+	text.WriteString(i18n.N(sess.lang, "search.found", len(sess.messages), html.EscapeString(sess.query), len(sess.messages), more))
+	if pages > 1 {
+		text.WriteString(i18n.T(sess.lang, "search.page", page+1, pages))
+	}
+	text.WriteString("\n")
+
+	chats := make(map[int64]*archive.Chat)
+	start := page * searchPageSize
+	for i, m := range sess.messages[start:min(start+searchPageSize, len(sess.messages))] {
+		chat, ok := chats[m.ChatID]
+		if !ok {
+			chat, _ = h.store.GetChat(m.ChatID)
+			chats[m.ChatID] = chat
+		}
+		text.WriteString("\n")
+		writeHit(&text, start+i+1, chat, m)
+	}
+
+	var buttons []telego.InlineKeyboardButton
+	if page > 0 {
+		buttons = append(buttons, tu.InlineKeyboardButton(i18n.T(sess.lang, "search.prev")).
+			WithCallbackData(fmt.Sprintf("%s%s:%d", searchCallbackPrefix, id, page-1)))
+	}
+	if page < pages-1 {
+		buttons = append(buttons, tu.InlineKeyboardButton(i18n.T(sess.lang, "search.next")).
+			WithCallbackData(fmt.Sprintf("%s%s:%d", searchCallbackPrefix, id, page+1)))
+	}
+	if len(buttons) == 0 {
+		return text.String(), nil
+	}
+	return text.String(), tu.InlineKeyboard(tu.InlineKeyboardRow(buttons...))
+}
+
+// writeHit одна строка результатов: автор, чат, дата, фрагмент текста и ссылка
+func writeHit(w *strings.Builder, n int, chat *archive.Chat, m *archive.Message) {
+	fmt.Fprintf(w, "<b>%d.</b> %s", n, html.EscapeString(m.FromName))
+	if chat != nil && chat.Title != "" {
+		fmt.Fprintf(w, " · %s", html.EscapeString(chat.Title))
+	}
+	fmt.Fprintf(w, " · %s\n", time.Unix(m.Date, 0).Format("02.01.2006 15:04"))
+
+	w.WriteString(html.EscapeString(snippet(m)))
+	if link := messageLink(chat, m); link != "" {
+		fmt.Fprintf(w, " <a href=\"%s\">→</a>", link)
+	}
+	w.WriteString("\n")
+}
+
+// snippet начало текста сообщения или описание вложений в одну строку
+func snippet(m *archive.Message) string {
+	return truncate(strings.Join(strings.Fields(snippetText(m)), " "), searchSnippetLen)
+}
+
+// snippetText текст сообщения, для вложений без подписи их описание
+func snippetText(m *archive.Message) string {
+	if m.Text != "" {
+		return m.Text
+	}
+	var parts []string
+	for _, media := range m.Media {
+		parts = append(parts, "["+strings.TrimSpace(string(media.Kind)+" "+media.FileName)+"]")
+	}
+	return strings.Join(parts, " ")
+}
+
+// truncate обрезает текст до n символов
+func truncate(text string, n int) string {
+	if runes := []rune(text); len(runes) > n {
+		return string(runes[:n]) + "…"
+	}
+	return text
+}
@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"tg-archive-bot/internal/archive"
+	"tg-archive-bot/internal/search"
+)
+
+// sessionTTL время жизни результатов поиска для листания страниц
+const sessionTTL = 30 * time.Minute
+
+// session результаты поиска пользователя
+type session struct {
+	userID   int64
+	lang     string
+	query    string
+	messages []*archive.Message
+	expires  time.Time
+}
+
+// sessions результаты поиска по идентификатору, который передается в callback data
+type sessions struct {
+	mu   sync.Mutex
+	byID map[string]*session
+}
+
+func newSessions() *sessions {
+	return &sessions{byID: make(map[string]*session)}
+}
+
+// Add сохраняет результаты и возвращает их идентификатор
+func (s *sessions) Add(userID int64, lang, query string, messages []*archive.Message) string {
+	buf := make([]byte, 6)
+	_, _ = rand.Read(buf)
+	id := hex.EncodeToString(buf)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for key, old := range s.byID {
+		if now.After(old.expires) {
+			delete(s.byID, key)
+		}
+	}
+	s.byID[id] = &session{userID: userID, lang: lang, query: query, messages: messages, expires: now.Add(sessionTTL)}
+	return id
+}
+
+// Get возвращает результаты, если они ещё не устарели
+func (s *sessions) Get(id string) (*session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sess, ok := s.byID[id]
+	if !ok || time.Now().After(sess.expires) {
+		return nil, false
+	}
+	return sess, true
+}
+
+// Forget убирает удаленные сообщения из результатов поиска, сессии без сообщений удаляются.
+// Сессия заменяется копией, список, который сейчас показывается, не меняется
+func (s *sessions) Forget(keys []search.DocKey) {
+	if len(keys) == 0 {
+		return
+	}
+	removed := make(map[search.DocKey]bool, len(keys))
+	for _, key := range keys {
+		removed[key] = true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, sess := range s.byID {
+		var kept []*archive.Message
+		for _, m := range sess.messages {
+			if !removed[search.DocKey{ChatID: m.ChatID, MessageID: m.ID}] {
+				kept = append(kept, m)
+			}
+		}
+		switch {
+		case len(kept) == len(sess.messages):
+		case len(kept) == 0:
+			delete(s.byID, id)
+		default:
+			filtered := *sess
+			filtered.messages = kept
+			s.byID[id] = &filtered
+		}
+	}
+}
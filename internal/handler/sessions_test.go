@@ -0,0 +1,31 @@
+package handler
+
+import (
+	"testing"
+
+	"tg-archive-bot/internal/archive"
+	"tg-archive-bot/internal/search"
+)
+
+// После удаления данных пользователя его сообщения нельзя долистать в старых результатах
+func TestSessionsForget(t *testing.T) {
+	s := newSessions()
+	kept := &archive.Message{ChatID: -1, ID: 1}
+	purged := &archive.Message{ChatID: -1, ID: 2}
+	mixed := s.Add(1, "en", "query", []*archive.Message{kept, purged})
+	only := s.Add(1, "en", "query", []*archive.Message{purged})
+	before, _ := s.Get(mixed)
+
+	s.Forget([]search.DocKey{{ChatID: -1, MessageID: 2}})
+
+	sess, ok := s.Get(mixed)
+	if !ok || len(sess.messages) != 1 || sess.messages[0] != kept {
+		t.Fatalf("session after purge: %+v", sess)
+	}
+	if _, ok = s.Get(only); ok {
+		t.Fatal("session with only purged messages is kept")
+	}
+	if len(before.messages) != 2 {
+		t.Fatal("session shown before purge modified in place")
+	}
+}
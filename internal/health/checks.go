@@ -0,0 +1,167 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mymmrac/telego"
+	"go.uber.org/zap"
+)
+
+// Loop следит, что цикл обработки обновлений не завис на одном обновлении
+type Loop struct {
+	stuckAfter time.Duration
+	busySince  atomic.Int64
+}
+
+// NewLoop цикл считается зависшим, если обновление обрабатывается дольше stuckAfter
+func NewLoop(stuckAfter time.Duration) *Loop {
+	return &Loop{stuckAfter: stuckAfter}
+}
+
+// Begin начало обработки обновления
+func (l *Loop) Begin() {
+	l.busySince.Store(time.Now().UnixNano())
+}
+
+// End конец обработки обновления
+func (l *Loop) End() {
+	l.busySince.Store(0)
+}
+
+// Check ошибка, если текущее обновление обрабатывается слишком долго
+func (l *Loop) Check() error {
+	since := l.busySince.Load()
+	if since == 0 {
+		return nil
+	}
+	if busy := time.Since(time.Unix(0, since)); busy > l.stuckAfter {
+		return fmt.Errorf("update is being processed for %s", busy.Round(time.Second))
+	}
+	return nil
+}
+
+// Polling следит, что long polling продолжает получать ответы getUpdates:
+// цикл обработки может быть свободен и тогда, когда получение обновлений остановилось
+type Polling struct {
+	maxAge time.Duration
+	lastOK atomic.Int64
+}
+
+// NewPolling проверка считается проваленной, если getUpdates не отвечал успешно дольше maxAge
+func NewPolling(maxAge time.Duration) *Polling {
+	p := &Polling{maxAge: maxAge}
+	p.OK()
+	return p
+}
+
+// OK отмечает успешный ответ getUpdates
+func (p *Polling) OK() {
+	p.lastOK.Store(time.Now().UnixNano())
+}
+
+// Check ошибка, если getUpdates давно не отвечал успешно
+func (p *Polling) Check() error {
+	if age := time.Since(time.Unix(0, p.lastOK.Load())); age > p.maxAge {
+		return fmt.Errorf("no successful getUpdates for %s", age.Round(time.Second))
+	}
+	return nil
+}
+
+// BotPinger периодически вызывает getMe и помнит время последнего успешного ответа
+type BotPinger struct {
+	bot      *telego.Bot
+	interval time.Duration
+	maxAge   time.Duration
+
+	mu      sync.Mutex
+	lastOK  time.Time
+	lastErr error
+}
+
+// NewBotPinger проверка считается проваленной, если getMe не отвечал успешно дольше maxAge
+func NewBotPinger(bot *telego.Bot, interval, maxAge time.Duration) *BotPinger {
+	return &BotPinger{bot: bot, interval: interval, maxAge: maxAge, lastOK: time.Now()}
+}
+
+// Run вызывает getMe каждые interval до отмены ctx
+func (p *BotPinger) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, err := p.bot.GetMe()
+			p.mu.Lock()
+			p.lastErr = err
+			if err == nil {
+				p.lastOK = time.Now()
+			}
+			p.mu.Unlock()
+			if err != nil {
+				zap.L().Warn("bot ping failed", zap.Error(err))
+			}
+		}
+	}
+}
+
+// Check ошибка, если getMe давно не отвечал успешно
+func (p *BotPinger) Check() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if age := time.Since(p.lastOK); age > p.maxAge {
+		return fmt.Errorf("no successful getMe for %s: %v", age.Round(time.Second), p.lastErr)
+	}
+	return nil
+}
+
+// Writable проверка записи в каталог dir
+func Writable(dir string) Check {
+	return func() error {
+		f, err := os.CreateTemp(dir, ".health-*")
+		if err != nil {
+			return err
+		}
+		name := f.Name()
+		_, err = f.WriteString("ok")
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if removeErr := os.Remove(name); err == nil {
+			err = removeErr
+		}
+		return err
+	}
+}
+
+// DiskSpace проверка, что на диске с каталогом dir свободно не меньше minFree байт
+func DiskSpace(dir string, minFree uint64) Check {
+	return func() error {
+		free, err := freeSpace(filepath.Clean(dir))
+		if err != nil {
+			return err
+		}
+		if free < minFree {
+			return fmt.Errorf("free space %d MB is below %d MB", free>>20, minFree>>20)
+		}
+		return nil
+	}
+}
+
+// Queue проверка, что очередь заполнена не больше чем на maxRatio
+func Queue(length, capacity func() int, maxRatio float64) Check {
+	return func() error {
+		n, c := length(), capacity()
+		if c > 0 && float64(n) >= float64(c)*maxRatio {
+			return fmt.Errorf("queue is saturated: %d of %d", n, c)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,10 @@
+//go:build windows || plan9
+
+package health
+
+import "math"
+
+// freeSpace на этих платформах не проверяется
+func freeSpace(string) (uint64, error) {
+	return math.MaxUint64, nil
+}
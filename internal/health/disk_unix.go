@@ -0,0 +1,14 @@
+//go:build !windows && !plan9
+
+package health
+
+import "syscall"
+
+// freeSpace свободное место для непривилегированного пользователя
+func freeSpace(dir string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
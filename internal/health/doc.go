@@ -0,0 +1,3 @@
+package health
+
+// проверки живости и готовности процесса для /healthz и /readyz
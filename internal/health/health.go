@@ -0,0 +1,95 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// статусы проверок
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// Check проверка компонента, nil означает, что компонент в порядке
+type Check func() error
+
+// Component результат проверки компонента
+type Component struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Report результат всех проверок
+type Report struct {
+	Status     string               `json:"status"`
+	Components map[string]Component `json:"components"`
+}
+
+// Probe набор проверок одного эндпоинта
+type Probe struct {
+	mu     sync.Mutex
+	checks map[string]Check
+}
+
+// NewProbe создает пустой набор проверок
+func NewProbe() *Probe {
+	return &Probe{checks: make(map[string]Check)}
+}
+
+// Add добавляет проверку компонента name
+func (p *Probe) Add(name string, check Check) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.checks[name] = check
+}
+
+// Remove удаляет проверку компонента name
+func (p *Probe) Remove(name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.checks, name)
+}
+
+// Run выполняет все проверки
+func (p *Probe) Run() Report {
+	p.mu.Lock()
+	names := make([]string, 0, len(p.checks))
+	for name := range p.checks {
+		names = append(names, name)
+	}
+	p.mu.Unlock()
+	sort.Strings(names)
+
+	report := Report{Status: StatusOK, Components: make(map[string]Component, len(names))}
+	for _, name := range names {
+		p.mu.Lock()
+		check, ok := p.checks[name]
+		p.mu.Unlock()
+		if !ok {
+			continue
+		}
+
+		if err := check(); err != nil {
+			report.Status = StatusFail
+			report.Components[name] = Component{Status: StatusFail, Error: err.Error()}
+			continue
+		}
+		report.Components[name] = Component{Status: StatusOK}
+	}
+	return report
+}
+
+// ServeHTTP отвечает 200 если все проверки прошли и 503 иначе
+func (p *Probe) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	report := p.Run()
+	status := http.StatusOK
+	if report.Status != StatusOK {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(report)
+}
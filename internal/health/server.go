@@ -0,0 +1,49 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Server отдельный HTTP сервер проб /healthz и /readyz без авторизации: сервер
+// администрирования по умолчанию слушает только localhost и недоступен Kubernetes
+type Server struct {
+	listen string
+	server *http.Server
+}
+
+// NewServer создает сервер проб на адресе listen
+func NewServer(listen string, liveness, readiness *Probe) *Server {
+	mux := http.NewServeMux()
+	mux.Handle("GET /healthz", liveness)
+	mux.Handle("GET /readyz", readiness)
+	return &Server{
+		listen: listen,
+		server: &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second},
+	}
+}
+
+// Start начинает принимать соединения в отдельной горутине
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.listen)
+	if err != nil {
+		return err
+	}
+	zap.L().Info("health server started", zap.String("listen", s.listen))
+	go func() {
+		if err := s.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			zap.L().Error("health server stopped", zap.Error(err))
+		}
+	}()
+	return nil
+}
+
+// Shutdown останавливает сервер, дожидаясь завершения запросов
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
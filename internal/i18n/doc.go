@@ -0,0 +1,3 @@
+package i18n
+
+// каталоги сообщений бота на русском и английском и выбор языка ответа
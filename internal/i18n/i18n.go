@@ -0,0 +1,189 @@
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Default язык, если для чата и пользователя не нашлось поддерживаемого
+const Default = "ru"
+
+//go:embed locales/*.json
+var files embed.FS
+
+// entry строка каталога: обычная или набор форм множественного числа
+type entry struct {
+	text  string
+	forms map[string]string
+}
+
+func (e *entry) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &e.text); err == nil {
+		return nil
+	}
+	return json.Unmarshal(data, &e.forms)
+}
+
+// catalogs строки по языку и ключу
+var catalogs = map[string]map[string]entry{}
+
+func init() {
+	names, err := files.ReadDir("locales")
+	if err != nil {
+		panic(err)
+	}
+	for _, f := range names {
+		data, err := files.ReadFile(path.Join("locales", f.Name()))
+		if err != nil {
+			panic(err)
+		}
+		catalog := make(map[string]entry)
+		if err = json.Unmarshal(data, &catalog); err != nil {
+			panic(fmt.Sprintf("i18n: %s: %v", f.Name(), err))
+		}
+		catalogs[strings.TrimSuffix(f.Name(), ".json")] = catalog
+	}
+}
+
+// Check проверяет, что во всех каталогах есть все ключи каталога по умолчанию,
+// нет лишних и у строк с числом есть все формы множественного числа языка.
+// Вызывается из тестов; при запуске недостающие строки берутся из каталога по умолчанию
+func Check() error {
+	base, ok := catalogs[Default]
+	if !ok {
+		return fmt.Errorf("i18n: no catalog for default language %s", Default)
+	}
+	var problems []string
+	for lang, catalog := range catalogs {
+		for key, e := range base {
+			got, ok := catalog[key]
+			switch {
+			case !ok:
+				problems = append(problems, lang+": missing "+key)
+			case (e.forms == nil) != (got.forms == nil):
+				problems = append(problems, lang+": "+key+" plural mismatch")
+			case got.forms != nil:
+				for _, form := range rules[lang].forms {
+					if _, ok := got.forms[form]; !ok {
+						problems = append(problems, lang+": "+key+" missing form "+form)
+					}
+				}
+			}
+		}
+		for key := range catalog {
+			if _, ok := base[key]; !ok {
+				problems = append(problems, lang+": unknown "+key)
+			}
+		}
+		if _, ok := rules[lang]; !ok {
+			problems = append(problems, lang+": no plural rule")
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("i18n: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Languages поддерживаемые языки, язык по умолчанию первый
+func Languages() []string {
+	langs := make([]string, 0, len(catalogs))
+	for lang := range catalogs {
+		if lang != Default {
+			langs = append(langs, lang)
+		}
+	}
+	sort.Strings(langs)
+	return append([]string{Default}, langs...)
+}
+
+// Supported поддерживаемый язык для кода вида en или en-US
+func Supported(code string) (string, bool) {
+	lang, _, _ := strings.Cut(strings.ToLower(code), "-")
+	_, ok := catalogs[lang]
+	return lang, ok
+}
+
+// Pick первый поддерживаемый язык из codes, иначе Default
+func Pick(codes ...string) string {
+	for _, code := range codes {
+		if lang, ok := Supported(code); ok {
+			return lang
+		}
+	}
+	return Default
+}
+
+// T строка key на языке lang, аргументы подставляются как в fmt.Sprintf
+func T(lang, key string, args ...any) string {
+	e, lang := lookup(lang, key)
+	if e.forms != nil {
+		return N(lang, key, 0, args...)
+	}
+	return format(e.text, args)
+}
+
+// N строка key в форме множественного числа для n. n не подставляется сам,
+// его нужно передать в args
+func N(lang, key string, n int, args ...any) string {
+	e, lang := lookup(lang, key)
+	if e.forms == nil {
+		return format(e.text, args)
+	}
+	return format(e.forms[rules[lang].form(n)], args)
+}
+
+// lookup строка на языке lang или, если ее нет, на языке по умолчанию
+func lookup(lang, key string) (entry, string) {
+	if e, ok := catalogs[lang][key]; ok {
+		return e, lang
+	}
+	if e, ok := catalogs[Default][key]; ok {
+		return e, Default
+	}
+	return entry{text: key}, Default
+}
+
+func format(text string, args []any) string {
+	if len(args) == 0 {
+		return text
+	}
+	return fmt.Sprintf(text, args...)
+}
+
+// rule правило выбора формы множественного числа по CLDR
+type rule struct {
+	forms []string
+	form  func(n int) string
+}
+
+var rules = map[string]rule{
+	"ru": {
+		forms: []string{"one", "few", "many"},
+		form: func(n int) string {
+			n = max(n, -n)
+			switch {
+			case n%10 == 1 && n%100 != 11:
+				return "one"
+			case slices.Contains([]int{2, 3, 4}, n%10) && !slices.Contains([]int{12, 13, 14}, n%100):
+				return "few"
+			}
+			return "many"
+		},
+	},
+	"en": {
+		forms: []string{"one", "other"},
+		form: func(n int) string {
+			if n == 1 {
+				return "one"
+			}
+			return "other"
+		},
+	},
+}
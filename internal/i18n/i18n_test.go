@@ -0,0 +1,46 @@
+package i18n
+
+import "testing"
+
+func TestCheck(t *testing.T) {
+	if err := Check(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPluralForms(t *testing.T) {
+	tests := []struct {
+		lang string
+		n    int
+		want string
+	}{
+		{"ru", 1, "one"},
+		{"ru", 21, "one"},
+		{"ru", 11, "many"},
+		{"ru", 2, "few"},
+		{"ru", 24, "few"},
+		{"ru", 12, "many"},
+		{"ru", 5, "many"},
+		{"ru", 0, "many"},
+		{"ru", -1, "one"},
+		{"en", 1, "one"},
+		{"en", 0, "other"},
+		{"en", 21, "other"},
+	}
+	for _, tt := range tests {
+		if got := rules[tt.lang].form(tt.n); got != tt.want {
+			t.Errorf("%s form(%d) = %s, want %s", tt.lang, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestFallback(t *testing.T) {
+	if got := T("xx", "no.such.key"); got != "no.such.key" {
+		t.Errorf("unknown key: got %q, want the key itself", got)
+	}
+	for key := range catalogs[Default] {
+		if got, want := T("xx", key), T(Default, key); got != want {
+			t.Errorf("unsupported language %s: got %q, want default %q", key, got, want)
+		}
+	}
+}
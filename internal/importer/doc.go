@@ -0,0 +1,3 @@
+package importer
+
+// импорт истории чатов из экспорта Telegram Desktop (result.json)
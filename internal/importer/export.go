@@ -0,0 +1,157 @@
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// exportChat чат в формате экспорта Telegram Desktop
+type exportChat struct {
+	Name     string          `json:"name"`
+	Type     string          `json:"type"`
+	ID       int64           `json:"id"`
+	Messages []exportMessage `json:"messages"`
+}
+
+// exportFull экспорт всего аккаунта, чаты лежат в chats.list
+type exportFull struct {
+	Chats struct {
+		List []exportChat `json:"list"`
+	} `json:"chats"`
+}
+
+type exportMessage struct {
+	ID               int              `json:"id"`
+	Type             string           `json:"type"`
+	DateUnix         string           `json:"date_unixtime"`
+	EditedUnix       string           `json:"edited_unixtime"`
+	From             string           `json:"from"`
+	FromID           string           `json:"from_id"`
+	ReplyToMessageID int              `json:"reply_to_message_id"`
+	ForwardedFrom    string           `json:"forwarded_from"`
+	TextEntities     []exportText     `json:"text_entities"`
+	Reactions        []exportReaction `json:"reactions"`
+
+	Photo         string `json:"photo"`
+	PhotoFileSize int64  `json:"photo_file_size"`
+	File          string `json:"file"`
+	FileName      string `json:"file_name"`
+	FileSize      int64  `json:"file_size"`
+	MediaType     string `json:"media_type"`
+	MimeType      string `json:"mime_type"`
+}
+
+type exportText struct {
+	Type   string `json:"type"`
+	Text   string `json:"text"`
+	Href   string `json:"href"`
+	UserID int64  `json:"user_id"`
+}
+
+type exportReaction struct {
+	Type       string `json:"type"`
+	Count      int    `json:"count"`
+	Emoji      string `json:"emoji"`
+	DocumentID string `json:"document_id"`
+}
+
+// botChatID переводит идентификатор чата из экспорта в идентификатор Bot API
+func botChatID(c *exportChat) int64 {
+	switch c.Type {
+	case "public_supergroup", "private_supergroup", "public_channel", "private_channel":
+		id, _ := strconv.ParseInt("-100"+strconv.FormatInt(c.ID, 10), 10, 64)
+		return id
+	case "private_group":
+		return -c.ID
+	}
+	return c.ID
+}
+
+// basicGroup обычная группа: номера сообщений в ней свои у каждого участника,
+// и номера из экспорта не совпадают с номерами, которые видит бот
+func basicGroup(c *exportChat) bool {
+	return c.Type == "private_group"
+}
+
+// importedBase сдвиг номеров сообщений обычных групп из экспорта
+const importedBase = 1 << 30
+
+// messageID номер сообщения из экспорта в архиве. Сообщения обычных групп хранятся
+// под отрицательными номерами id-importedBase, чтобы не занять номера сообщений,
+// сохраненных ботом. Номера растут вместе с номерами экспорта, поэтому порядок
+// по номеру внутри импорта хронологический, а импортированные сообщения идут
+// раньше сохраненных ботом
+func messageID(c *exportChat, id int) int {
+	if basicGroup(c) && id != 0 {
+		return id - importedBase
+	}
+	return id
+}
+
+// legacyMessageID номер, под которым прежние версии хранили сообщение обычной группы
+func legacyMessageID(id int) int {
+	return -id
+}
+
+// botChatType тип чата в терминах Bot API
+func botChatType(exportType string) string {
+	switch exportType {
+	case "public_supergroup", "private_supergroup":
+		return "supergroup"
+	case "public_channel", "private_channel":
+		return "channel"
+	case "private_group":
+		return "group"
+	}
+	return "private"
+}
+
+// parsePeerID переводит from_id вида user123 / channel123 в идентификатор Bot API
+func parsePeerID(s string) (int64, bool, error) {
+	switch {
+	case strings.HasPrefix(s, "user"):
+		id, err := strconv.ParseInt(strings.TrimPrefix(s, "user"), 10, 64)
+		return id, true, err
+	case strings.HasPrefix(s, "channel"):
+		id, err := strconv.ParseInt("-100"+strings.TrimPrefix(s, "channel"), 10, 64)
+		return id, false, err
+	case strings.HasPrefix(s, "chat"):
+		id, err := strconv.ParseInt(strings.TrimPrefix(s, "chat"), 10, 64)
+		return -id, false, err
+	case s == "":
+		return 0, false, nil
+	}
+	return 0, false, fmt.Errorf("unknown peer id %q", s)
+}
+
+func parseUnix(s string) int64 {
+	v, _ := strconv.ParseInt(s, 10, 64)
+	return v
+}
+
+// selectChat находит чат в экспорте. Для экспорта аккаунта chatID обязателен
+func selectChat(data []byte, chatID int64) (*exportChat, error) {
+	var single exportChat
+	if err := json.Unmarshal(data, &single); err != nil {
+		return nil, fmt.Errorf("decode result.json: %w", err)
+	}
+	if single.Messages != nil {
+		return &single, nil
+	}
+
+	var full exportFull
+	if err := json.Unmarshal(data, &full); err != nil {
+		return nil, fmt.Errorf("decode result.json: %w", err)
+	}
+	if chatID == 0 {
+		return nil, fmt.Errorf("account export contains %d chats, chat id is required", len(full.Chats.List))
+	}
+	for i := range full.Chats.List {
+		if botChatID(&full.Chats.List[i]) == chatID {
+			return &full.Chats.List[i], nil
+		}
+	}
+	return nil, fmt.Errorf("chat %d not found in export", chatID)
+}
@@ -0,0 +1,301 @@
+package importer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"tg-archive-bot/internal/archive"
+	"tg-archive-bot/internal/log"
+	"tg-archive-bot/internal/search"
+
+	"go.uber.org/zap"
+)
+
+// Report итог импорта
+type Report struct {
+	ChatID       int64
+	Imported     int
+	Duplicates   int
+	Skipped      int
+	MediaCopied  int
+	MediaMissing int
+}
+
+// Importer загружает экспорт Telegram Desktop в архив
+type Importer struct {
+	store *archive.Store
+	index *search.Index
+	log   *zap.Logger
+}
+
+// New создает импортер в хранилище store, импортированные сообщения добавляются в index
+func New(store *archive.Store, index *search.Index) *Importer {
+	return &Importer{
+		store: store,
+		index: index,
+		log:   log.Named("importer"),
+	}
+}
+
+// Import загружает чат из каталога экспорта dir (result.json и папки с медиа).
+// chatID выбирает чат в экспорте всего аккаунта, а для экспорта одного чата
+// переопределяет его идентификатор (например, после миграции группы в супергруппу).
+// Сообщения, уже сохраненные ботом, не перезаписываются. В обычных группах номера
+// из экспорта не совпадают с номерами Bot API, поэтому дубликаты сохраненных ботом
+// сообщений ищутся по дате, автору и тексту
+func (im *Importer) Import(dir string, chatID int64) (*Report, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "result.json"))
+	if err != nil {
+		return nil, err
+	}
+	chat, err := selectChat(data, chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{ChatID: botChatID(chat)}
+	if chatID != 0 {
+		report.ChatID = chatID
+	}
+
+	if _, err = im.store.GetChat(report.ChatID); errors.Is(err, archive.ErrNotFound) {
+		err = im.store.PutChat(&archive.Chat{ID: report.ChatID, Type: botChatType(chat.Type), Title: chat.Name})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var live map[string]bool
+	if basicGroup(chat) {
+		if live, err = im.liveMessages(report.ChatID); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range chat.Messages {
+		em := &chat.Messages[i]
+		if em.Type != "message" {
+			report.Skipped++
+			continue
+		}
+		// дубликаты отсекаем до копирования медиа
+		stored, err := im.stored(chat, report.ChatID, em.ID)
+		if err != nil {
+			return report, fmt.Errorf("message %d: %w", em.ID, err)
+		}
+		if stored {
+			report.Duplicates++
+			continue
+		}
+
+		m, err := im.convert(dir, report, chat, em)
+		if err != nil {
+			return report, fmt.Errorf("message %d: %w", em.ID, err)
+		}
+		if m.Text == "" && len(m.Media) == 0 {
+			report.Skipped++
+			continue
+		}
+		if live[liveKey(m)] {
+			report.Duplicates++
+			continue
+		}
+
+		added, err := im.store.AddMessage(m)
+		if err != nil {
+			return report, fmt.Errorf("message %d: %w", em.ID, err)
+		}
+		if !added {
+			report.Duplicates++
+			continue
+		}
+		if err = im.index.Update(m); err != nil {
+			return report, fmt.Errorf("index message %d: %w", em.ID, err)
+		}
+		report.Imported++
+	}
+
+	im.log.Info("import finished", zap.Int64("chat_id", report.ChatID), zap.Int("imported", report.Imported),
+		zap.Int("duplicates", report.Duplicates), zap.Int("skipped", report.Skipped),
+		zap.Int("media_copied", report.MediaCopied), zap.Int("media_missing", report.MediaMissing))
+	return report, nil
+}
+
+// stored сообщение экспорта с номером id уже есть в архиве
+func (im *Importer) stored(chat *exportChat, chatID int64, id int) (bool, error) {
+	ids := []int{messageID(chat, id)}
+	if basicGroup(chat) {
+		ids = append(ids, legacyMessageID(id))
+	}
+	for _, id := range ids {
+		_, err := im.store.GetMessage(chatID, id)
+		if err == nil {
+			return true, nil
+		}
+		if !errors.Is(err, archive.ErrNotFound) {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// liveMessages ключи сообщений чата, сохраненных ботом
+func (im *Importer) liveMessages(chatID int64) (map[string]bool, error) {
+	live := make(map[string]bool)
+	err := im.store.Messages(chatID, func(m *archive.Message) error {
+		if m.Source == archive.SourceLive {
+			live[liveKey(m)] = true
+		}
+		return nil
+	})
+	return live, err
+}
+
+// liveKey ключ для поиска дубликата сообщения без номера
+func liveKey(m *archive.Message) string {
+	return fmt.Sprintf("%d|%d|%s", m.Date, m.FromID, m.Text)
+}
+
+func (im *Importer) convert(dir string, report *Report, chat *exportChat, em *exportMessage) (*archive.Message, error) {
+	fromID, isUser, err := parsePeerID(em.FromID)
+	if err != nil {
+		return nil, err
+	}
+	if isUser {
+		if err = im.addUser(fromID, em.From); err != nil {
+			return nil, err
+		}
+	}
+
+	m := &archive.Message{
+		ChatID:      report.ChatID,
+		ID:          messageID(chat, em.ID),
+		FromID:      fromID,
+		FromName:    em.From,
+		Date:        parseUnix(em.DateUnix),
+		EditDate:    parseUnix(em.EditedUnix),
+		ReplyToID:   messageID(chat, em.ReplyToMessageID),
+		ForwardFrom: em.ForwardedFrom,
+		Source:      archive.SourceImport,
+		ArchivedAt:  time.Now().Unix(),
+		ExportID:    em.ID,
+	}
+
+	var text strings.Builder
+	for _, t := range em.TextEntities {
+		text.WriteString(t.Text)
+		if t.Type == "plain" {
+			continue
+		}
+		m.Entities = append(m.Entities, archive.Entity{
+			Type:   entityType(t.Type),
+			Text:   t.Text,
+			URL:    t.Href,
+			UserID: t.UserID,
+		})
+	}
+	m.Text = text.String()
+
+	for _, r := range em.Reactions {
+		emoji := r.Emoji
+		if r.Type == "custom_emoji" {
+			emoji = "custom:" + r.DocumentID
+		}
+		m.Reactions = append(m.Reactions, archive.Reaction{Emoji: emoji, Count: r.Count})
+	}
+
+	switch {
+	case em.Photo != "":
+		media := archive.Media{Kind: archive.MediaPhoto, Size: em.PhotoFileSize}
+		im.copyMedia(dir, em.Photo, m.ChatID, &media, report)
+		m.Media = append(m.Media, media)
+	case em.File != "":
+		media := archive.Media{
+			Kind:     mediaKind(em.MediaType),
+			FileName: em.FileName,
+			MimeType: em.MimeType,
+			Size:     em.FileSize,
+		}
+		im.copyMedia(dir, em.File, m.ChatID, &media, report)
+		m.Media = append(m.Media, media)
+	}
+
+	return m, nil
+}
+
+// addUser сохраняет автора из экспорта, если бот ещё не видел его вживую
+func (im *Importer) addUser(id int64, name string) error {
+	_, err := im.store.GetUser(id)
+	if !errors.Is(err, archive.ErrNotFound) {
+		return err
+	}
+	return im.store.PutUser(&archive.User{
+		ID:        id,
+		FirstName: name,
+		Source:    archive.SourceImport,
+		UpdatedAt: time.Now().Unix(),
+	})
+}
+
+// copyMedia копирует файл из экспорта в хранилище. Если файл не был выгружен
+// (Telegram Desktop пишет вместо пути пояснение в скобках), вложение остается без содержимого
+func (im *Importer) copyMedia(dir, path string, chatID int64, media *archive.Media, report *Report) {
+	if strings.HasPrefix(path, "(") {
+		report.MediaMissing++
+		return
+	}
+	if media.FileName == "" {
+		media.FileName = filepath.Base(path)
+	}
+
+	f, err := os.Open(filepath.Join(dir, filepath.FromSlash(path)))
+	if err != nil {
+		im.log.Warn("media file is missing", zap.String("path", path), zap.Error(err))
+		report.MediaMissing++
+		return
+	}
+	defer f.Close()
+
+	hash, size, err := im.store.PutBlob(chatID, f)
+	if err != nil {
+		im.log.Warn("copy media file", zap.String("path", path), zap.Error(err))
+		report.MediaMissing++
+		return
+	}
+	media.Blob = hash
+	media.Size = size
+	report.MediaCopied++
+}
+
+// entityType переводит тип сущности экспорта в тип Bot API
+func entityType(t string) string {
+	switch t {
+	case "link":
+		return "url"
+	case "mention_name":
+		return "text_mention"
+	}
+	return t
+}
+
+func mediaKind(mediaType string) archive.MediaKind {
+	switch mediaType {
+	case "video_file":
+		return archive.MediaVideo
+	case "video_message":
+		return archive.MediaVideoNote
+	case "voice_message":
+		return archive.MediaVoice
+	case "audio_file":
+		return archive.MediaAudio
+	case "animation":
+		return archive.MediaAnimation
+	case "sticker":
+		return archive.MediaSticker
+	}
+	return archive.MediaDocument
+}
@@ -0,0 +1,188 @@
+package importer
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"tg-archive-bot/internal/archive"
+	"tg-archive-bot/internal/search"
+)
+
+func newTestImporter(t *testing.T) (*Importer, *archive.Store) {
+	t.Helper()
+	dir := t.TempDir()
+	store, err := archive.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	index, err := search.Open(filepath.Join(dir, "index"), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { index.Close() })
+	return New(store, index), store
+}
+
+// writeExport сохраняет result.json экспорта одного чата во временный каталог
+func writeExport(t *testing.T, chat exportChat) string {
+	t.Helper()
+	data, err := json.Marshal(map[string]any{"name": chat.Name, "type": chat.Type, "id": chat.ID, "messages": chat.Messages})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err = os.WriteFile(filepath.Join(dir, "result.json"), data, 0o640); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func text(s string) []exportText {
+	return []exportText{{Type: "plain", Text: s}}
+}
+
+func TestImportMapping(t *testing.T) {
+	im, store := newTestImporter(t)
+	dir := writeExport(t, exportChat{Name: "chat", Type: "private_supergroup", ID: 123, Messages: []exportMessage{
+		{ID: 1, Type: "service"},
+		{ID: 2, Type: "message", DateUnix: "1700000000", From: "Иван", FromID: "user5", TextEntities: []exportText{
+			{Type: "plain", Text: "see "},
+			{Type: "link", Text: "example.com"},
+		}},
+		{ID: 3, Type: "message", DateUnix: "1700000100", FromID: "channel77", ReplyToMessageID: 2,
+			Photo: "(File not included. Change data exporting settings to download.)", PhotoFileSize: 10,
+			Reactions: []exportReaction{{Type: "emoji", Emoji: "👍", Count: 2}}},
+	}})
+	report, err := im.Import(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.ChatID != -100123 || report.Imported != 2 || report.Skipped != 1 || report.MediaMissing != 1 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	chat, err := store.GetChat(-100123)
+	if err != nil || chat.Type != "supergroup" || chat.Title != "chat" {
+		t.Fatalf("chat %+v, %v", chat, err)
+	}
+	m, err := store.GetMessage(-100123, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Text != "see example.com" || m.FromID != 5 || m.Date != 1700000000 {
+		t.Fatalf("unexpected message %+v", m)
+	}
+	if len(m.Entities) != 1 || m.Entities[0].Type != "url" {
+		t.Fatalf("entities %+v, want one url", m.Entities)
+	}
+	if m.Source != archive.SourceImport || m.ExportID != 2 {
+		t.Fatalf("message is not marked as imported: source %q, export id %d", m.Source, m.ExportID)
+	}
+	if u, err := store.GetUser(5); err != nil || u.FirstName != "Иван" || u.Source != archive.SourceImport {
+		t.Fatalf("author %+v, %v", u, err)
+	}
+
+	m, err = store.GetMessage(-100123, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.FromID != -10077 || m.ReplyToID != 2 || len(m.Media) != 1 || m.Media[0].Blob != "" {
+		t.Fatalf("unexpected message %+v", m)
+	}
+	if len(m.Reactions) != 1 || m.Reactions[0] != (archive.Reaction{Emoji: "👍", Count: 2}) {
+		t.Fatalf("reactions %+v", m.Reactions)
+	}
+}
+
+// Номера сообщений обычной группы не пересекаются с номерами бота и идут в порядке экспорта
+func TestImportBasicGroupOrder(t *testing.T) {
+	im, store := newTestImporter(t)
+	dir := writeExport(t, exportChat{Name: "group", Type: "private_group", ID: 55, Messages: []exportMessage{
+		{ID: 10, Type: "message", DateUnix: "100", FromID: "user1", TextEntities: text("first")},
+		{ID: 11, Type: "message", DateUnix: "200", FromID: "user1", TextEntities: text("second"), ReplyToMessageID: 10},
+		{ID: 12, Type: "message", DateUnix: "300", FromID: "user1", TextEntities: text("third")},
+	}})
+	if _, err := im.Import(dir, 0); err != nil {
+		t.Fatal(err)
+	}
+	ids, err := store.MessageIDs(-55)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var texts []string
+	for _, id := range ids {
+		if id >= 0 {
+			t.Fatalf("imported basic group message stored under id %d", id)
+		}
+		m, err := store.GetMessage(-55, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		texts = append(texts, m.Text)
+		if m.ExportID != id+importedBase {
+			t.Fatalf("export id %d for message %d", m.ExportID, id)
+		}
+		if m.Text == "second" && m.ReplyToID != messageID(&exportChat{Type: "private_group"}, 10) {
+			t.Fatalf("reply to %d is not mapped", m.ReplyToID)
+		}
+	}
+	if !slices.Equal(texts, []string{"first", "second", "third"}) {
+		t.Fatalf("messages in id order: %v", texts)
+	}
+}
+
+func TestImportDedupByID(t *testing.T) {
+	im, store := newTestImporter(t)
+	live := &archive.Message{ChatID: -100123, ID: 2, Text: "live text", Source: archive.SourceLive}
+	if err := store.PutMessage(live); err != nil {
+		t.Fatal(err)
+	}
+	dir := writeExport(t, exportChat{Type: "public_supergroup", ID: 123, Messages: []exportMessage{
+		{ID: 1, Type: "message", DateUnix: "100", TextEntities: text("one")},
+		{ID: 2, Type: "message", DateUnix: "200", TextEntities: text("exported text")},
+	}})
+	report, err := im.Import(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Imported != 1 || report.Duplicates != 1 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if m, err := store.GetMessage(-100123, 2); err != nil || m.Text != "live text" || m.Source != archive.SourceLive {
+		t.Fatalf("live message overwritten: %+v, %v", m, err)
+	}
+
+	// повторный импорт ничего не добавляет
+	if report, err = im.Import(dir, 0); err != nil || report.Imported != 0 || report.Duplicates != 2 {
+		t.Fatalf("reimport: %+v, %v", report, err)
+	}
+}
+
+// В обычной группе номера бота и экспорта разные, дубликат находится по дате, автору и тексту
+func TestImportDedupBasicGroup(t *testing.T) {
+	im, store := newTestImporter(t)
+	if err := store.PutMessage(&archive.Message{ChatID: -55, ID: 7, FromID: 1, Date: 200, Text: "seen by bot", Source: archive.SourceLive}); err != nil {
+		t.Fatal(err)
+	}
+	// сообщение, импортированное прежней версией под номером -id
+	if err := store.PutMessage(&archive.Message{ChatID: -55, ID: -3, FromID: 1, Date: 300, Text: "old import", Source: archive.SourceImport}); err != nil {
+		t.Fatal(err)
+	}
+	dir := writeExport(t, exportChat{Type: "private_group", ID: 55, Messages: []exportMessage{
+		{ID: 1, Type: "message", DateUnix: "100", FromID: "user1", TextEntities: text("new")},
+		{ID: 2, Type: "message", DateUnix: "200", FromID: "user1", TextEntities: text("seen by bot")},
+		{ID: 3, Type: "message", DateUnix: "300", FromID: "user1", TextEntities: text("old import")},
+		{ID: 4, Type: "message", DateUnix: "200", FromID: "user2", TextEntities: text("seen by bot")},
+	}})
+	report, err := im.Import(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Imported != 2 || report.Duplicates != 2 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+}
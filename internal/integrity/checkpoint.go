@@ -0,0 +1,194 @@
+package integrity
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"tg-archive-bot/internal/archive"
+
+	"go.uber.org/zap"
+)
+
+// DefaultInterval период подписи вершин цепочек
+const DefaultInterval = time.Hour
+
+// Checkpointer периодически подписывает вершины цепочек чатов, изменившихся
+// с прошлой подписи
+type Checkpointer struct {
+	store    *archive.Store
+	key      ed25519.PrivateKey
+	interval time.Duration
+	log      *zap.Logger
+	// signed хэш последней подписанной вершины цепочки каждого чата
+	signed map[int64]string
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewCheckpointer создает подпись вершин цепочек ключом key
+func NewCheckpointer(store *archive.Store, key ed25519.PrivateKey, interval time.Duration, logger *zap.Logger) *Checkpointer {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	return &Checkpointer{
+		store:    store,
+		key:      key,
+		interval: interval,
+		log:      logger,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Run подписывает вершины раз в interval до вызова Stop
+func (c *Checkpointer) Run() {
+	defer close(c.done)
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			// последние изменения перед остановкой тоже подписываются
+			c.run()
+			return
+		case <-ticker.C:
+			c.run()
+		}
+	}
+}
+
+// Stop подписывает текущие вершины и останавливает расписание
+func (c *Checkpointer) Stop() {
+	close(c.stop)
+	<-c.done
+}
+
+func (c *Checkpointer) run() {
+	n, err := c.Checkpoint()
+	if err != nil {
+		c.log.Error("sign chain checkpoints", zap.Error(err))
+	}
+	if n > 0 {
+		c.log.Debug("chain checkpoints signed", zap.Int("chats", n))
+	}
+}
+
+// Checkpoint подписывает вершины цепочек, изменившихся с прошлой подписи.
+// Возвращает число подписанных чатов
+func (c *Checkpointer) Checkpoint() (int, error) {
+	if c.signed == nil {
+		if err := c.loadSigned(); err != nil {
+			return 0, err
+		}
+	}
+	chats, err := c.store.Chats()
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	var errs []error
+	for _, chatID := range chats {
+		// чаты, в которые ничего не писали с появления цепочек, тоже должны быть подписаны
+		if err = c.store.StartChain(chatID); err != nil {
+			errs = append(errs, fmt.Errorf("chat %d: %w", chatID, err))
+			continue
+		}
+		head, ok, err := c.store.ChainHead(chatID)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("chat %d: %w", chatID, err))
+			continue
+		}
+		if !ok || head.Hash == c.signed[chatID] {
+			continue
+		}
+		cp := archive.Checkpoint{ChatID: chatID, Seq: head.Seq, Hash: head.Hash, Time: time.Now().Unix()}
+		Sign(c.key, &cp)
+		if err = c.store.AppendCheckpoint(cp); err != nil {
+			errs = append(errs, fmt.Errorf("chat %d: %w", chatID, err))
+			continue
+		}
+		c.signed[chatID] = head.Hash
+		n++
+	}
+	deleted, err := c.signDeletions(chats)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	return n + deleted, errors.Join(errs...)
+}
+
+// signDeletions подписывает отметки, которые DeleteChat оставляет об удаленных чатах.
+// Чат, пропавший без такой отметки, не подписывается: это нарушение для проверки
+func (c *Checkpointer) signDeletions(chats []int64) (int, error) {
+	signed, err := c.store.CheckpointedChats()
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	var errs []error
+	for _, chatID := range signed {
+		if _, ok := slices.BinarySearch(chats, chatID); ok {
+			continue
+		}
+		last, ok, err := lastCheckpoint(c.store, chatID)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("chat %d: %w", chatID, err))
+			continue
+		}
+		if !ok || !last.Deleted || len(last.Signature) > 0 {
+			continue
+		}
+		cp := archive.Checkpoint{ChatID: chatID, Seq: last.Seq, Hash: last.Hash, Time: time.Now().Unix(), Deleted: true}
+		Sign(c.key, &cp)
+		if err = c.store.AppendCheckpoint(cp); err != nil {
+			errs = append(errs, fmt.Errorf("chat %d: %w", chatID, err))
+			continue
+		}
+		delete(c.signed, chatID)
+		n++
+	}
+	return n, errors.Join(errs...)
+}
+
+// lastCheckpoint последняя читаемая вершина чата, false если их нет
+func lastCheckpoint(store *archive.Store, chatID int64) (archive.Checkpoint, bool, error) {
+	var last archive.Checkpoint
+	var ok bool
+	err := store.Checkpoints(chatID, func(cp archive.Checkpoint, err error) error {
+		if err == nil {
+			last, ok = cp, true
+		}
+		return nil
+	})
+	return last, ok, err
+}
+
+// loadSigned читает последние подписанные вершины
+func (c *Checkpointer) loadSigned() error {
+	chats, err := c.store.Chats()
+	if err != nil {
+		return err
+	}
+	// вершины прежних версий лежат в каталогах чатов
+	checkpointed, err := c.store.CheckpointedChats()
+	if err != nil {
+		return err
+	}
+	signed := make(map[int64]string, len(chats))
+	for _, chatID := range append(chats, checkpointed...) {
+		last, ok, err := lastCheckpoint(c.store, chatID)
+		if err != nil {
+			return err
+		}
+		// после удаления чат с тем же идентификатором начинает цепочку заново
+		if ok && !last.Deleted {
+			signed[chatID] = last.Hash
+		}
+	}
+	c.signed = signed
+	return nil
+}
@@ -0,0 +1,3 @@
+package integrity
+
+// неизменность архива: подписанные вершины цепочек чатов и их проверка
@@ -0,0 +1,91 @@
+package integrity
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"tg-archive-bot/internal/archive"
+)
+
+// LoadPrivateKey читает ключ ed25519 в PEM (PKCS #8), например из
+// openssl genpkey -algorithm ed25519
+func LoadPrivateKey(path string) (ed25519.PrivateKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse signing key: %w", err)
+	}
+	private, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New("signing key is not ed25519")
+	}
+	return private, nil
+}
+
+// LoadPublicKey читает открытый ключ ed25519 в PEM или берет его из закрытого ключа
+func LoadPublicKey(path string) (ed25519.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	if block.Type == "PRIVATE KEY" {
+		private, err := LoadPrivateKey(path)
+		if err != nil {
+			return nil, err
+		}
+		return private.Public().(ed25519.PublicKey), nil
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse public key: %w", err)
+	}
+	public, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, errors.New("public key is not ed25519")
+	}
+	return public, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data", path)
+	}
+	return block, nil
+}
+
+// signedData подписываемое содержимое вершины цепочки. Отметка об удалении чата
+// дописывается в конец, поэтому подписи обычных вершин прежних версий остаются верными
+func signedData(cp *archive.Checkpoint) []byte {
+	data := "tg-archive-bot checkpoint v1\n" +
+		strconv.FormatInt(cp.ChatID, 10) + "\n" +
+		strconv.FormatInt(cp.Seq, 10) + "\n" +
+		cp.Hash + "\n" +
+		strconv.FormatInt(cp.Time, 10)
+	if cp.Deleted {
+		data += "\ndeleted"
+	}
+	return []byte(data)
+}
+
+// Sign подписывает вершину цепочки
+func Sign(key ed25519.PrivateKey, cp *archive.Checkpoint) {
+	cp.Signature = ed25519.Sign(key, signedData(cp))
+}
+
+// Valid подпись вершины цепочки верна
+func Valid(key ed25519.PublicKey, cp *archive.Checkpoint) bool {
+	return ed25519.Verify(key, signedData(cp), cp.Signature)
+}
@@ -0,0 +1,278 @@
+package integrity
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"tg-archive-bot/internal/archive"
+)
+
+// Виды нарушений
+const (
+	// ChainMissing у чата с сообщениями нет цепочки: его сообщения не защищены
+	ChainMissing = "chain_missing"
+	// EntryCorrupted строку цепочки не удалось разобрать
+	EntryCorrupted = "entry_corrupted"
+	// EntryModified запись цепочки изменена: ее хэш не совпадает с содержимым
+	EntryModified = "entry_modified"
+	// EntryInserted запись вставлена в цепочку: номер повторяется или идет назад
+	EntryInserted = "entry_inserted"
+	// EntriesRemoved записи удалены из цепочки: пропуск в номерах
+	EntriesRemoved = "entries_removed"
+	// ChainBroken запись не ссылается на предыдущую: цепочку переписали
+	ChainBroken = "chain_broken"
+	// RecordModified сообщение изменено в обход архива
+	RecordModified = "record_modified"
+	// RecordInserted сообщение есть на диске, но не записано в цепочку или удалено в ней
+	RecordInserted = "record_inserted"
+	// RecordRemoved сообщение есть в цепочке, но удалено с диска
+	RecordRemoved = "record_removed"
+	// RecordUnreadable сообщение не читается или не расшифровывается
+	RecordUnreadable = "record_unreadable"
+	// CheckpointInvalid неверная подпись вершины
+	CheckpointInvalid = "checkpoint_invalid"
+	// CheckpointMismatch подписанная вершина не совпадает с записью цепочки:
+	// цепочку пересчитали после подписи
+	CheckpointMismatch = "checkpoint_mismatch"
+	// CheckpointBeyondChain подписана запись, которой в цепочке уже нет: конец цепочки удален
+	CheckpointBeyondChain = "checkpoint_beyond_chain"
+	// CheckpointMissing у цепочки нет ни одной верной подписи: подписи удалены
+	// или цепочка подменена целиком
+	CheckpointMissing = "checkpoint_missing"
+	// CheckpointStale записи цепочки продолжались дольше допустимого после последней
+	// подписи: подписи после нее удалены или бот не подписывал изменения
+	CheckpointStale = "checkpoint_stale"
+	// ChatMissing чат, вершины которого подписывались, удален в обход бота
+	ChatMissing = "chat_missing"
+)
+
+// Problem нарушение целостности
+type Problem struct {
+	ChatID int64
+	Kind   string
+	// Seq номер записи цепочки, 0 если нарушение не в цепочке
+	Seq       int64
+	MessageID int
+	Detail    string
+}
+
+// Report итог проверки
+type Report struct {
+	Chats       int
+	Entries     int64
+	Records     int
+	Checkpoints int
+	// Unsigned записи после последней подписанной вершины: их подлинность
+	// подтверждает только цепочка
+	Unsigned int64
+	// Deleted чаты, удаленные ботом: от них остались только отметки об удалении
+	Deleted  int
+	Problems []Problem
+}
+
+// Verify проверяет цепочки чатов и сообщения на диске. С ключом key проверяются
+// и подписанные вершины, без него только сама цепочка. maxUnsigned — сколько записи
+// цепочки могут идти после последней подписи, 0 не ограничивает. chatID 0 — все чаты,
+// включая удаленные, вершины которых подписывались
+func Verify(store *archive.Store, key ed25519.PublicKey, chatID int64, maxUnsigned time.Duration) (*Report, error) {
+	existing, err := store.Chats()
+	if err != nil {
+		return nil, err
+	}
+	chats := []int64{chatID}
+	if chatID == 0 {
+		signed, err := store.CheckpointedChats()
+		if err != nil {
+			return nil, err
+		}
+		chats = append(slices.Clone(existing), signed...)
+		slices.Sort(chats)
+		chats = slices.Compact(chats)
+	}
+	report := &Report{}
+	for _, id := range chats {
+		_, exists := slices.BinarySearch(existing, id)
+		if err := verifyChat(store, key, id, exists, maxUnsigned, report); err != nil {
+			return report, fmt.Errorf("chat %d: %w", id, err)
+		}
+		report.Chats++
+	}
+	return report, nil
+}
+
+func verifyChat(store *archive.Store, key ed25519.PublicKey, chatID int64, exists bool, maxUnsigned time.Duration, report *Report) error {
+	add := func(kind string, seq int64, messageID int, detail string) {
+		report.Problems = append(report.Problems, Problem{ChatID: chatID, Kind: kind, Seq: seq, MessageID: messageID, Detail: detail})
+	}
+
+	// hashes хэши записей по номерам для сверки с подписанными вершинами,
+	// live последняя версия каждого сообщения по цепочке
+	hashes := make(map[int64]string)
+	live := make(map[int]string)
+	var prev archive.ChainEntry
+	var entries int64
+	err := store.Chain(chatID, func(e archive.ChainEntry, err error) error {
+		entries++
+		if err != nil {
+			add(EntryCorrupted, e.Seq, 0, fmt.Sprintf("line %d: %v", e.Seq, err))
+			return nil
+		}
+		modified := e.Sum() != e.Hash
+		if modified {
+			add(EntryModified, e.Seq, e.MessageID, "hash does not match entry")
+		}
+		switch expected := prev.Seq + 1; {
+		case e.Seq > expected:
+			add(EntriesRemoved, e.Seq, 0, fmt.Sprintf("entries %d-%d are missing", expected, e.Seq-1))
+		case e.Seq < expected:
+			add(EntryInserted, e.Seq, e.MessageID, fmt.Sprintf("expected seq %d", expected))
+		case e.Prev != prev.Hash:
+			add(ChainBroken, e.Seq, e.MessageID, "previous hash does not match")
+		}
+		hashes[e.Seq] = e.Hash
+		prev = e
+		// содержимому измененной записи верить нельзя
+		if modified {
+			return nil
+		}
+		if e.Op == archive.ChainDelete {
+			delete(live, e.MessageID)
+		} else {
+			live[e.MessageID] = e.Record
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	report.Entries += entries
+
+	ids, err := store.MessageIDs(chatID)
+	if err != nil {
+		return err
+	}
+	if entries == 0 && len(ids) > 0 {
+		add(ChainMissing, 0, 0, fmt.Sprintf("%d messages are not protected", len(ids)))
+		return nil
+	}
+	onDisk := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		report.Records++
+		onDisk[id] = true
+		m, err := store.GetMessage(chatID, id)
+		if errors.Is(err, archive.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			add(RecordUnreadable, 0, id, err.Error())
+			continue
+		}
+		hash, err := archive.RecordHash(m)
+		if err != nil {
+			return err
+		}
+		expected, ok := live[id]
+		switch {
+		case !ok:
+			add(RecordInserted, 0, id, "")
+		case hash != expected:
+			add(RecordModified, 0, id, "")
+		}
+	}
+	var removed []int
+	for id := range live {
+		if !onDisk[id] {
+			removed = append(removed, id)
+		}
+	}
+	sort.Ints(removed)
+	for _, id := range removed {
+		add(RecordRemoved, 0, id, "")
+	}
+
+	checkpoints, err := readCheckpoints(store, key, chatID, add, report)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		switch last := lastOf(checkpoints); {
+		case len(checkpoints) == 0:
+		case !last.Deleted:
+			add(ChatMissing, last.Seq, 0, "signed chat is gone without a deletion mark")
+		case key != nil && len(last.Signature) == 0:
+			add(ChatMissing, last.Seq, 0, "chat deletion is not signed")
+		default:
+			report.Deleted++
+		}
+		return nil
+	}
+
+	var signed archive.Checkpoint
+	var current int
+	for _, cp := range checkpoints {
+		if cp.Deleted {
+			continue
+		}
+		current++
+		hash, ok := hashes[cp.Seq]
+		switch {
+		case !ok && cp.Seq > prev.Seq:
+			add(CheckpointBeyondChain, cp.Seq, 0, fmt.Sprintf("chain ends at %d", prev.Seq))
+		case !ok:
+			add(CheckpointMismatch, cp.Seq, 0, "signed entry is missing")
+		case hash != cp.Hash:
+			add(CheckpointMismatch, cp.Seq, 0, "signed hash differs from entry")
+		case cp.Seq >= signed.Seq:
+			signed = cp
+		}
+	}
+	report.Unsigned += prev.Seq - signed.Seq
+	if key == nil || prev.Seq == 0 {
+		return nil
+	}
+	switch unsigned := time.Unix(prev.Time, 0).Sub(time.Unix(signed.Time, 0)); {
+	case current == 0:
+		add(CheckpointMissing, prev.Seq, 0, fmt.Sprintf("%d entries are not signed", prev.Seq))
+	case maxUnsigned > 0 && signed.Seq > 0 && prev.Seq > signed.Seq && unsigned > maxUnsigned:
+		add(CheckpointStale, signed.Seq, 0, fmt.Sprintf("entries %d-%d were added over %s after the last signature", signed.Seq+1, prev.Seq, unsigned))
+	}
+	return nil
+}
+
+// readCheckpoints верные вершины текущей цепочки чата: после последнего удаления чата
+// он мог появиться снова с новой цепочкой. Неверные подписи сообщаются через add.
+// Отметку об удалении DeleteChat оставляет без подписи, ее подписывает бот, поэтому
+// неподписанная отметка тоже начинает список, а оценивает ее вызывающий
+func readCheckpoints(store *archive.Store, key ed25519.PublicKey, chatID int64, add func(string, int64, int, string), report *Report) ([]archive.Checkpoint, error) {
+	var checkpoints []archive.Checkpoint
+	err := store.Checkpoints(chatID, func(cp archive.Checkpoint, err error) error {
+		report.Checkpoints++
+		if err != nil {
+			add(CheckpointInvalid, 0, 0, err.Error())
+			return nil
+		}
+		unsignedDeletion := cp.Deleted && len(cp.Signature) == 0
+		if !unsignedDeletion && key != nil && (cp.ChatID != chatID || !Valid(key, &cp)) {
+			add(CheckpointInvalid, cp.Seq, 0, "bad signature")
+			return nil
+		}
+		// вершины до удаления относятся к прежней цепочке
+		if cp.Deleted {
+			checkpoints = checkpoints[:0]
+		}
+		checkpoints = append(checkpoints, cp)
+		return nil
+	})
+	return checkpoints, err
+}
+
+func lastOf(checkpoints []archive.Checkpoint) archive.Checkpoint {
+	if len(checkpoints) == 0 {
+		return archive.Checkpoint{}
+	}
+	return checkpoints[len(checkpoints)-1]
+}
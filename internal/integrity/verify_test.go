@@ -0,0 +1,242 @@
+package integrity
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"tg-archive-bot/internal/archive"
+
+	"go.uber.org/zap"
+)
+
+const testChat = -100
+
+type testArchive struct {
+	t       *testing.T
+	dir     string
+	store   *archive.Store
+	public  ed25519.PublicKey
+	private ed25519.PrivateKey
+}
+
+// newTestArchive архив с тремя сообщениями в чате testChat и подписанной вершиной
+func newTestArchive(t *testing.T) *testArchive {
+	t.Helper()
+	dir := t.TempDir()
+	store, err := archive.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &testArchive{t: t, dir: dir, store: store, public: public, private: private}
+	if err = store.PutChat(&archive.Chat{ID: testChat, Title: "chat"}); err != nil {
+		t.Fatal(err)
+	}
+	for id := 1; id <= 3; id++ {
+		a.put(id, "message "+strconv.Itoa(id))
+	}
+	a.checkpoint()
+	return a
+}
+
+func (a *testArchive) put(id int, text string) {
+	a.t.Helper()
+	if err := a.store.PutMessage(&archive.Message{ChatID: testChat, ID: id, Text: text}); err != nil {
+		a.t.Fatal(err)
+	}
+}
+
+func (a *testArchive) checkpoint() {
+	a.t.Helper()
+	if _, err := NewCheckpointer(a.store, a.private, 0, zap.NewNop()).Checkpoint(); err != nil {
+		a.t.Fatal(err)
+	}
+}
+
+func (a *testArchive) path(parts ...string) string {
+	return filepath.Join(append([]string{a.dir, "chats", strconv.Itoa(testChat)}, parts...)...)
+}
+
+// writeRecord пишет сообщение в обход хранилища
+func (a *testArchive) writeRecord(id int, text string) {
+	a.t.Helper()
+	data, err := json.Marshal(&archive.Message{ChatID: testChat, ID: id, Text: text})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	if err = os.WriteFile(a.path("messages", strconv.Itoa(id)+".json"), data, 0o640); err != nil {
+		a.t.Fatal(err)
+	}
+}
+
+// verify проверяет архив и возвращает виды найденных нарушений
+func (a *testArchive) verify(key ed25519.PublicKey, maxUnsigned time.Duration) (*Report, []string) {
+	a.t.Helper()
+	report, err := Verify(a.store, key, 0, maxUnsigned)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	kinds := make([]string, len(report.Problems))
+	for i, p := range report.Problems {
+		kinds[i] = p.Kind
+	}
+	return report, kinds
+}
+
+func expectKinds(t *testing.T, got []string, want ...string) {
+	t.Helper()
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("problems %v, want %v", got, want)
+	}
+}
+
+func TestVerifyClean(t *testing.T) {
+	a := newTestArchive(t)
+	report, kinds := a.verify(a.public, time.Hour)
+	expectKinds(t, kinds)
+	if report.Entries != 3 || report.Records != 3 || report.Unsigned != 0 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+}
+
+func TestVerifyTamperedRecords(t *testing.T) {
+	a := newTestArchive(t)
+	a.writeRecord(2, "forged")
+	a.writeRecord(4, "inserted")
+	if err := os.Remove(a.path("messages", "3.json")); err != nil {
+		t.Fatal(err)
+	}
+	_, kinds := a.verify(a.public, time.Hour)
+	expectKinds(t, kinds, RecordModified, RecordInserted, RecordRemoved)
+}
+
+// Цепочка, пересчитанная после правки, сходится сама с собой, но не с подписью
+func TestVerifyRewrittenChain(t *testing.T) {
+	a := newTestArchive(t)
+	a.writeRecord(2, "forged")
+	m, err := a.store.GetMessage(testChat, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged, err := archive.RecordHash(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var entries []archive.ChainEntry
+	err = a.store.Chain(testChat, func(e archive.ChainEntry, err error) error {
+		entries = append(entries, e)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	var prev string
+	for _, e := range entries {
+		if e.MessageID == 2 {
+			e.Record = forged
+		}
+		e.Prev = prev
+		e.Hash = e.Sum()
+		prev = e.Hash
+		data, err := json.Marshal(&e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, string(data))
+	}
+	if err = os.WriteFile(a.path("chain.jsonl"), []byte(strings.Join(lines, "\n")+"\n"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+
+	_, kinds := a.verify(a.public, time.Hour)
+	expectKinds(t, kinds, CheckpointMismatch)
+}
+
+func TestVerifyRemovedCheckpoints(t *testing.T) {
+	a := newTestArchive(t)
+	if err := os.Remove(filepath.Join(a.dir, "checkpoints", strconv.Itoa(testChat)+".jsonl")); err != nil {
+		t.Fatal(err)
+	}
+	_, kinds := a.verify(a.public, time.Hour)
+	expectKinds(t, kinds, CheckpointMissing)
+}
+
+func TestVerifyStaleCheckpoint(t *testing.T) {
+	a := newTestArchive(t)
+	head, _, err := a.store.ChainHead(testChat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// последняя подпись сделана три часа назад, изменения после нее не подписаны
+	cp := archive.Checkpoint{ChatID: testChat, Seq: head.Seq, Hash: head.Hash, Time: time.Now().Add(-3 * time.Hour).Unix()}
+	Sign(a.private, &cp)
+	data, err := json.Marshal(&cp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(a.dir, "checkpoints", strconv.Itoa(testChat)+".jsonl"), append(data, '\n'), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	a.put(4, "unsigned")
+
+	report, kinds := a.verify(a.public, time.Hour)
+	expectKinds(t, kinds, CheckpointStale)
+	if report.Unsigned != 1 {
+		t.Fatalf("unsigned entries %d, want 1", report.Unsigned)
+	}
+	_, kinds = a.verify(a.public, 0)
+	expectKinds(t, kinds)
+}
+
+func TestVerifyRemovedChat(t *testing.T) {
+	a := newTestArchive(t)
+	if err := os.RemoveAll(a.path()); err != nil {
+		t.Fatal(err)
+	}
+	_, kinds := a.verify(a.public, time.Hour)
+	expectKinds(t, kinds, ChatMissing)
+}
+
+func TestVerifyDeletedChat(t *testing.T) {
+	a := newTestArchive(t)
+	for id := 1; id <= 3; id++ {
+		if err := a.store.DeleteMessage(testChat, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.store.DeleteChat(testChat); err != nil {
+		t.Fatal(err)
+	}
+	// отметку об удалении может оставить кто угодно, пока бот ее не подписал
+	_, kinds := a.verify(a.public, time.Hour)
+	expectKinds(t, kinds, ChatMissing)
+
+	a.checkpoint()
+	report, kinds := a.verify(a.public, time.Hour)
+	expectKinds(t, kinds)
+	if report.Deleted != 1 {
+		t.Fatalf("deleted chats %d, want 1", report.Deleted)
+	}
+
+	// чат с тем же идентификатором начинает новую цепочку
+	a.put(1, "again")
+	a.checkpoint()
+	report, kinds = a.verify(a.public, time.Hour)
+	expectKinds(t, kinds)
+	if report.Deleted != 0 || report.Entries != 1 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+}
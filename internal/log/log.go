@@ -13,7 +13,8 @@ var mu sync.Mutex
 
 // RootLogger Создает корневой логгер, использовать только для создания первого корневого логгера
 // для создания дочерних логгеров используйте zap.L().With( .... )
-func RootLogger(env string, level string) (*zap.Logger, error) {
+// без sinks логи пишутся в stderr в формате пресета env
+func RootLogger(env string, level string, sinks ...SinkConfig) (*zap.Logger, error) {
 	mu.Lock()
 	defer mu.Unlock()
 
@@ -33,13 +34,31 @@ func RootLogger(env string, level string) (*zap.Logger, error) {
 	if err = logLevel.UnmarshalText([]byte(level)); err != nil {
 		return nil, err
 	}
-	config.Level = logLevel
 
-	rootLogger, err = config.Build()
-	if err != nil {
-		return nil, err
+	if len(sinks) == 0 {
+		sinks = []SinkConfig{{Type: SinkConsole, Encoding: config.Encoding}}
+	}
+	cores := make([]zapcore.Core, 0, len(sinks))
+	for _, sink := range sinks {
+		core, err := newCore(sink, config.EncoderConfig)
+		if err != nil {
+			return nil, err
+		}
+		cores = append(cores, core)
 	}
 
+	stacktrace := zapcore.ErrorLevel
+	options := []zap.Option{zap.AddCaller()}
+	if config.Development {
+		stacktrace = zapcore.WarnLevel
+		options = append(options, zap.Development())
+	}
+	options = append(options, zap.AddStacktrace(stacktrace))
+
+	// общий уровень проверяется поверх всех синков, модули могут его переопределить
+	root := &levelCore{Core: zapcore.NewTee(cores...), enabler: logLevel}
+	rootLogger = zap.New(root, options...)
+
 	zap.ReplaceGlobals(rootLogger)
 
 	return rootLogger, nil
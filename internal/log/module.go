@@ -0,0 +1,82 @@
+package log
+
+import (
+	"sync"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// levelCore проверяет уровень перед передачей записи в синки
+type levelCore struct {
+	zapcore.Core
+	enabler zapcore.LevelEnabler
+}
+
+func (c *levelCore) Enabled(l zapcore.Level) bool {
+	return c.enabler.Enabled(l)
+}
+
+func (c *levelCore) With(fields []zapcore.Field) zapcore.Core {
+	return &levelCore{Core: c.Core.With(fields), enabler: c.enabler}
+}
+
+func (c *levelCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.enabler.Enabled(entry.Level) {
+		return checked
+	}
+	return c.Core.Check(entry, checked)
+}
+
+// moduleLevels уровни, переопределенные для отдельных модулей
+var moduleLevels sync.Map
+
+// moduleEnabler уровень модуля, если он задан, иначе общий уровень
+type moduleEnabler string
+
+func (m moduleEnabler) Enabled(l zapcore.Level) bool {
+	if level, ok := moduleLevels.Load(string(m)); ok {
+		return level.(zapcore.Level).Enabled(l)
+	}
+	return logLevel.Enabled(l)
+}
+
+// Named логгер модуля name. Уровень модуля можно изменить через SetModuleLevel
+// независимо от общего уровня, в том числе сделать его подробнее
+func Named(name string) *zap.Logger {
+	return Module(zap.L(), name)
+}
+
+// Module дочерний логгер l для модуля name, см. Named
+func Module(l *zap.Logger, name string) *zap.Logger {
+	return l.Named(name).WithOptions(zap.WrapCore(func(c zapcore.Core) zapcore.Core {
+		if lc, ok := c.(*levelCore); ok {
+			return &levelCore{Core: lc.Core, enabler: moduleEnabler(name)}
+		}
+		return c
+	}))
+}
+
+// SetModuleLevel переопределить уровень модуля, пустой levelText возвращает общий уровень
+func SetModuleLevel(name, levelText string) error {
+	if levelText == "" {
+		moduleLevels.Delete(name)
+		return nil
+	}
+	level, err := zapcore.ParseLevel(levelText)
+	if err != nil {
+		return err
+	}
+	moduleLevels.Store(name, level)
+	return nil
+}
+
+// GetModuleLevels переопределенные уровни модулей
+func GetModuleLevels() map[string]string {
+	levels := make(map[string]string)
+	moduleLevels.Range(func(key, value any) bool {
+		levels[key.(string)] = value.(zapcore.Level).String()
+		return true
+	})
+	return levels
+}
@@ -0,0 +1,212 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rotatedLayout суффикс с временем ротации в имени старого файла
+const rotatedLayout = "20060102T150405.000000"
+
+// rotatingFile файл лога с ротацией по размеру и возрасту и удалением старых копий
+type rotatingFile struct {
+	path        string
+	maxSize     int64
+	rotateEvery time.Duration
+	maxBackups  int
+	maxAge      time.Duration
+
+	mu      sync.Mutex
+	file    *os.File
+	size    int64
+	created time.Time
+}
+
+func newRotatingFile(cfg SinkConfig) (*rotatingFile, error) {
+	if cfg.Path == "" {
+		return nil, fmt.Errorf("file sink: path is required")
+	}
+	r := &rotatingFile{
+		path:       cfg.Path,
+		maxSize:    int64(cfg.MaxSizeMB) << 20,
+		maxBackups: cfg.MaxBackups,
+		maxAge:     time.Duration(cfg.MaxAgeDays) * 24 * time.Hour,
+	}
+	if cfg.RotateEvery != "" {
+		every, err := time.ParseDuration(cfg.RotateEvery)
+		if err != nil {
+			return nil, fmt.Errorf("file sink: rotate_every: %w", err)
+		}
+		r.rotateEvery = every
+	}
+	if err := os.MkdirAll(filepath.Dir(r.path), 0o750); err != nil {
+		return nil, err
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.file = f
+	r.size = info.Size()
+	r.created = r.loadCreated(info)
+	return nil
+}
+
+// createdPath файл с временем создания текущего файла лога: время изменения
+// файла после перезапуска не говорит, когда файл начат
+func (r *rotatingFile) createdPath() string {
+	return filepath.Join(filepath.Dir(r.path), "."+filepath.Base(r.path)+".created")
+}
+
+// loadCreated время создания текущего файла. Для пустого файла это текущее время,
+// для файла без записанного времени — время его изменения
+func (r *rotatingFile) loadCreated(info fs.FileInfo) time.Time {
+	created := time.Now()
+	if info.Size() > 0 {
+		data, err := os.ReadFile(r.createdPath())
+		if err == nil {
+			if created, err = time.Parse(time.RFC3339Nano, strings.TrimSpace(string(data))); err == nil {
+				return created
+			}
+		}
+		created = info.ModTime()
+	}
+	// без записанного времени ротация по возрасту только откладывается, поэтому ошибку не возвращаем
+	_ = os.WriteFile(r.createdPath(), []byte(created.Format(time.RFC3339Nano)), 0o640)
+	return created
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// файл мог остаться закрытым после неудачной ротации
+	if r.file == nil {
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	if r.needRotate(int64(len(p))) {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) Sync() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	return r.file.Sync()
+}
+
+func (r *rotatingFile) needRotate(next int64) bool {
+	if r.size == 0 {
+		return false
+	}
+	if r.maxSize > 0 && r.size+next > r.maxSize {
+		return true
+	}
+	return r.rotateEvery > 0 && time.Since(r.created) >= r.rotateEvery
+}
+
+// rotate переименовывает текущий файл в <имя>-<время><расширение> и начинает новый.
+// При ошибке запись продолжается в прежний файл
+func (r *rotatingFile) rotate() error {
+	err := r.file.Close()
+	r.file = nil
+	if err != nil {
+		return errors.Join(err, r.open())
+	}
+
+	ext := filepath.Ext(r.path)
+	rotated := strings.TrimSuffix(r.path, ext) + "-" + time.Now().Format(rotatedLayout) + ext
+	if err = os.Rename(r.path, rotated); err != nil {
+		return errors.Join(err, r.open())
+	}
+	if err = r.open(); err != nil {
+		if undo := os.Rename(rotated, r.path); undo != nil {
+			return errors.Join(err, undo)
+		}
+		return errors.Join(err, r.open())
+	}
+	r.cleanup()
+	return nil
+}
+
+// cleanup удаляет копии сверх maxBackups и старше maxAge
+func (r *rotatingFile) cleanup() {
+	if r.maxBackups <= 0 && r.maxAge <= 0 {
+		return
+	}
+
+	backups, err := r.backups()
+	if err != nil {
+		return
+	}
+	// имена содержат время ротации, поэтому сортировка по имени совпадает с хронологической
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+
+	for i, name := range backups {
+		expired := false
+		if r.maxAge > 0 {
+			if info, err := os.Stat(name); err == nil && time.Since(info.ModTime()) > r.maxAge {
+				expired = true
+			}
+		}
+		if expired || (r.maxBackups > 0 && i >= r.maxBackups) {
+			_ = os.Remove(name)
+		}
+	}
+}
+
+// backups копии текущего файла: только имена вида <имя>-<время><расширение>,
+// чтобы не задеть другие файлы в каталоге
+func (r *rotatingFile) backups() ([]string, error) {
+	dir := filepath.Dir(r.path)
+	ext := filepath.Ext(r.path)
+	prefix := strings.TrimSuffix(filepath.Base(r.path), ext) + "-"
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var backups []string
+	for _, e := range entries {
+		stamp, ok := strings.CutPrefix(e.Name(), prefix)
+		if !ok || e.IsDir() {
+			continue
+		}
+		if stamp, ok = strings.CutSuffix(stamp, ext); !ok {
+			continue
+		}
+		if _, err := time.Parse(rotatedLayout, stamp); err != nil {
+			continue
+		}
+		backups = append(backups, filepath.Join(dir, e.Name()))
+	}
+	return backups, nil
+}
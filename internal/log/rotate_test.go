@@ -0,0 +1,239 @@
+package log
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestRotatingFile(t *testing.T, cfg SinkConfig) *rotatingFile {
+	t.Helper()
+	r, err := newRotatingFile(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if r.file != nil {
+			r.file.Close()
+		}
+	})
+	return r
+}
+
+func write(t *testing.T, r *rotatingFile, text string) {
+	t.Helper()
+	if _, err := r.Write([]byte(text)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// backupNames имена копий в каталоге, от старых к новым
+func backupNames(t *testing.T, r *rotatingFile) []string {
+	t.Helper()
+	backups, err := r.backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, len(backups))
+	for i, b := range backups {
+		names[i] = filepath.Base(b)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// backupName имя копии, повернутой в момент at
+func backupName(at time.Time) string {
+	return "app-" + at.Format(rotatedLayout) + ".log"
+}
+
+func TestRotateBySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	r := newTestRotatingFile(t, SinkConfig{Path: path})
+	r.maxSize = 10
+
+	write(t, r, "first\n")
+	write(t, r, "second\n")
+	write(t, r, "third\n")
+
+	backups := backupNames(t, r)
+	if len(backups) != 2 {
+		t.Fatalf("backups %v, want 2", backups)
+	}
+	if got := readFile(t, filepath.Join(filepath.Dir(path), backups[0])); got != "first\n" {
+		t.Fatalf("oldest backup %q", got)
+	}
+	if got := readFile(t, path); got != "third\n" {
+		t.Fatalf("current file %q", got)
+	}
+
+	// запись больше предела попадает в новый файл целиком
+	write(t, r, strings.Repeat("x", 20))
+	if len(backupNames(t, r)) != 3 || readFile(t, path) != strings.Repeat("x", 20) {
+		t.Fatal("oversized record is split or not rotated")
+	}
+}
+
+// Время создания берется из файла .created, а не из времени изменения лога
+func TestRotateByAge(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	sidecar := filepath.Join(dir, ".app.log.created")
+	if err := os.WriteFile(path, []byte("old\n"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	started := time.Now().Add(-2 * time.Hour).Round(0)
+	if err := os.WriteFile(sidecar, []byte(started.Format(time.RFC3339Nano)), 0o640); err != nil {
+		t.Fatal(err)
+	}
+
+	r := newTestRotatingFile(t, SinkConfig{Path: path, RotateEvery: "1h"})
+	if !r.created.Equal(started) {
+		t.Fatalf("created %v, want %v from the sidecar", r.created, started)
+	}
+	write(t, r, "new\n")
+	if backups := backupNames(t, r); len(backups) != 1 {
+		t.Fatalf("backups %v, want 1", backups)
+	}
+	if got := readFile(t, path); got != "new\n" {
+		t.Fatalf("current file %q", got)
+	}
+	created, err := time.Parse(time.RFC3339Nano, readFile(t, sidecar))
+	if err != nil || time.Since(created) > time.Minute {
+		t.Fatalf("sidecar is not updated after rotation: %v, %v", created, err)
+	}
+
+	// свежий файл не поворачивается
+	write(t, r, "more\n")
+	if len(backupNames(t, r)) != 1 {
+		t.Fatal("fresh file rotated")
+	}
+}
+
+// Без .created возраст считается от времени изменения, и оно записывается
+func TestRotateCreatedFromModTime(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	if err := os.WriteFile(path, []byte("old\n"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	modified := time.Now().Add(-3 * time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(path, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+
+	r := newTestRotatingFile(t, SinkConfig{Path: path, RotateEvery: "1h"})
+	if !r.created.Equal(modified) {
+		t.Fatalf("created %v, want %v", r.created, modified)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".app.log.created")); err != nil {
+		t.Fatalf("sidecar is not written: %v", err)
+	}
+	write(t, r, "new\n")
+	if len(backupNames(t, r)) != 1 {
+		t.Fatal("old file is not rotated")
+	}
+}
+
+// После неудачной ротации запись продолжается в новый или прежний файл
+func TestRotateRecovery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	r := newTestRotatingFile(t, SinkConfig{Path: path})
+	r.maxSize = 10
+	write(t, r, "first\n")
+
+	// переименование не удается: файл удален из-под открытого дескриптора
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Write([]byte("lost record\n")); err == nil {
+		t.Fatal("failed rotation is not reported")
+	}
+	if r.file == nil {
+		t.Fatal("file is not reopened after a failed rotation")
+	}
+	write(t, r, "second\n")
+	if got := readFile(t, path); got != "second\n" {
+		t.Fatalf("current file %q", got)
+	}
+
+	// файл, оставшийся закрытым, открывается при следующей записи
+	r.file.Close()
+	r.file = nil
+	write(t, r, "x\n")
+	if got := readFile(t, path); got != "second\nx\n" {
+		t.Fatalf("current file %q", got)
+	}
+	if err := r.Sync(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Удаляются только копии с временем в имени, прочие файлы каталога не трогаются
+func TestRotateCleanup(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	now := time.Now()
+	old := now.Add(-10 * 24 * time.Hour)
+
+	var stamped []string
+	for i := 3; i >= 1; i-- {
+		name := backupName(now.Add(-time.Duration(i) * time.Hour))
+		stamped = append(stamped, name)
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("backup\n"), 0o640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ancient := backupName(old)
+	foreign := []string{"app-old.log", "app-2024-01-01.log", "app-" + old.Format(rotatedLayout) + ".txt",
+		"other-" + old.Format(rotatedLayout) + ".log", "app.log.1"}
+	for _, name := range append([]string{ancient}, foreign...) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("keep\n"), 0o640); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(filepath.Join(dir, name), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, backupName(old.Add(time.Hour))), 0o750); err != nil {
+		t.Fatal(err)
+	}
+
+	// по количеству копии не лишние, копия старше max_age_days удаляется по возрасту
+	r := newTestRotatingFile(t, SinkConfig{Path: path, MaxBackups: 5, MaxAgeDays: 7})
+	r.maxSize = 10
+	write(t, r, "first\n")
+	write(t, r, "second\n")
+	backups := backupNames(t, r)
+	if len(backups) != 4 || !slices.Equal(backups[:3], stamped) {
+		t.Fatalf("backups %v, want all but the expired one", backups)
+	}
+
+	// сверх max_backups удаляются самые старые
+	r.maxBackups = 2
+	write(t, r, "third\n")
+	rotated := backupNames(t, r)
+	if len(rotated) != 2 || rotated[0] != backups[3] {
+		t.Fatalf("backups %v, want the 2 newest", rotated)
+	}
+	for _, name := range foreign {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Fatalf("unrelated file removed: %v", err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, backupName(old.Add(time.Hour)))); err != nil {
+		t.Fatalf("directory removed: %v", err)
+	}
+}
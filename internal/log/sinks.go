@@ -0,0 +1,93 @@
+package log
+
+import (
+	"fmt"
+	"os"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// типы синков
+const (
+	SinkConsole = "console"
+	SinkFile    = "file"
+	SinkSyslog  = "syslog"
+)
+
+// SinkConfig место вывода логов со своим уровнем и форматом
+type SinkConfig struct {
+	// Type console, file или syslog
+	Type string `json:"type"`
+	// Level минимальный уровень синка, общий уровень SetLogLevel действует поверх него
+	Level string `json:"level,omitempty"`
+	// Encoding console или json, по умолчанию console для консоли и json для файла и syslog
+	Encoding string `json:"encoding,omitempty"`
+
+	// Path файл лога (file)
+	Path string `json:"path,omitempty"`
+	// MaxSizeMB ротация при превышении размера (file)
+	MaxSizeMB int `json:"max_size_mb,omitempty"`
+	// RotateEvery ротация по возрасту файла, например 24h (file)
+	RotateEvery string `json:"rotate_every,omitempty"`
+	// MaxBackups сколько старых файлов хранить (file)
+	MaxBackups int `json:"max_backups,omitempty"`
+	// MaxAgeDays удалять старые файлы старше указанного количества дней (file)
+	MaxAgeDays int `json:"max_age_days,omitempty"`
+
+	// Network и Address адрес syslog, пустые значения — локальный сокет (syslog)
+	Network string `json:"network,omitempty"`
+	Address string `json:"address,omitempty"`
+	// Tag тег сообщений syslog (syslog)
+	Tag string `json:"tag,omitempty"`
+}
+
+// newCore создает core для синка, encoderConfig пресета используется для формата console.
+// Общий уровень логирования проверяется уровнем выше, в levelCore
+func newCore(cfg SinkConfig, encoderConfig zapcore.EncoderConfig) (zapcore.Core, error) {
+	minLevel := zapcore.DebugLevel
+	if cfg.Level != "" {
+		level, err := zapcore.ParseLevel(cfg.Level)
+		if err != nil {
+			return nil, fmt.Errorf("%s sink: %w", cfg.Type, err)
+		}
+		minLevel = level
+	}
+	enabled := zap.LevelEnablerFunc(func(l zapcore.Level) bool {
+		return l >= minLevel
+	})
+
+	encoding := cfg.Encoding
+	if encoding == "" {
+		encoding = "json"
+		if cfg.Type == SinkConsole {
+			encoding = "console"
+		}
+	}
+	var encoder zapcore.Encoder
+	switch encoding {
+	case "console":
+		encoder = zapcore.NewConsoleEncoder(encoderConfig)
+	case "json":
+		// машиночитаемые логи всегда в формате production, независимо от пресета
+		jsonConfig := zap.NewProductionEncoderConfig()
+		jsonConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+		encoder = zapcore.NewJSONEncoder(jsonConfig)
+	default:
+		return nil, fmt.Errorf("%s sink: unknown encoding %q", cfg.Type, encoding)
+	}
+
+	switch cfg.Type {
+	case SinkConsole:
+		return zapcore.NewCore(encoder, zapcore.Lock(os.Stderr), enabled), nil
+	case SinkFile:
+		w, err := newRotatingFile(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return zapcore.NewCore(encoder, w, enabled), nil
+	case SinkSyslog:
+		return newSyslogCore(cfg, encoder, enabled)
+	}
+	return nil, fmt.Errorf("unknown sink type %q", cfg.Type)
+}
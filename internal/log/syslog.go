@@ -0,0 +1,70 @@
+//go:build !windows && !plan9
+
+package log
+
+import (
+	"fmt"
+	"log/syslog"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// syslogCore пишет записи в syslog с приоритетом, соответствующим уровню
+type syslogCore struct {
+	zapcore.LevelEnabler
+	encoder zapcore.Encoder
+	writer  *syslog.Writer
+}
+
+func newSyslogCore(cfg SinkConfig, encoder zapcore.Encoder, enabled zapcore.LevelEnabler) (zapcore.Core, error) {
+	tag := cfg.Tag
+	if tag == "" {
+		tag = "tg-archive-bot"
+	}
+	w, err := syslog.Dial(cfg.Network, cfg.Address, syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+	if err != nil {
+		return nil, fmt.Errorf("syslog sink: %w", err)
+	}
+	return &syslogCore{LevelEnabler: enabled, encoder: encoder, writer: w}, nil
+}
+
+func (c *syslogCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := &syslogCore{LevelEnabler: c.LevelEnabler, encoder: c.encoder.Clone(), writer: c.writer}
+	for _, f := range fields {
+		f.AddTo(clone.encoder)
+	}
+	return clone
+}
+
+func (c *syslogCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+func (c *syslogCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	buf, err := c.encoder.EncodeEntry(entry, fields)
+	if err != nil {
+		return err
+	}
+	defer buf.Free()
+
+	msg := buf.String()
+	switch entry.Level {
+	case zapcore.DebugLevel:
+		return c.writer.Debug(msg)
+	case zapcore.InfoLevel:
+		return c.writer.Info(msg)
+	case zapcore.WarnLevel:
+		return c.writer.Warning(msg)
+	case zapcore.ErrorLevel:
+		return c.writer.Err(msg)
+	default:
+		return c.writer.Crit(msg)
+	}
+}
+
+func (c *syslogCore) Sync() error {
+	return nil
+}
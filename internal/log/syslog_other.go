@@ -0,0 +1,13 @@
+//go:build windows || plan9
+
+package log
+
+import (
+	"errors"
+
+	"go.uber.org/zap/zapcore"
+)
+
+func newSyslogCore(SinkConfig, zapcore.Encoder, zapcore.LevelEnabler) (zapcore.Core, error) {
+	return nil, errors.New("syslog sink is not supported on this platform")
+}
@@ -0,0 +1,108 @@
+package log
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Redacted замена секретов в логах
+const Redacted = "***"
+
+// форматы сообщений telego, из которых извлекаются структурированные поля
+const (
+	telegoCallFormat     = "API call to: %q, with data: %s"
+	telegoResponseFormat = "API response %s: %s"
+	telegoErrorFormat    = "Execution error %s: %s"
+)
+
+// tokenPattern токен любого бота, маскируется даже если не передан явно
+var tokenPattern = regexp.MustCompile(`\d{5,}:[A-Za-z0-9_-]{30,}`)
+
+// TelegoLogger реализует telego.Logger поверх zap. Вызовы API пишутся на уровне debug
+// с полями method и duration, ошибки на уровне error. Токен бота и прочие секреты
+// вырезаются из URL и тел запросов
+type TelegoLogger struct {
+	log      *zap.Logger
+	replacer *strings.Replacer
+
+	mu      sync.Mutex
+	started map[string][]time.Time
+}
+
+// NewTelegoLogger создает адаптер, secrets заменяются на Redacted во всех сообщениях
+func NewTelegoLogger(l *zap.Logger, secrets ...string) *TelegoLogger {
+	var pairs []string
+	for _, s := range secrets {
+		if s != "" {
+			pairs = append(pairs, s, Redacted)
+		}
+	}
+	return &TelegoLogger{
+		log:      Module(l, "telego"),
+		replacer: strings.NewReplacer(pairs...),
+		started:  make(map[string][]time.Time),
+	}
+}
+
+// Redact вырезает секреты из текста
+func (l *TelegoLogger) Redact(text string) string {
+	return tokenPattern.ReplaceAllString(l.replacer.Replace(text), Redacted)
+}
+
+func (l *TelegoLogger) Debugf(format string, args ...any) {
+	switch {
+	case format == telegoCallFormat && len(args) == 2:
+		url := fmt.Sprint(args[0])
+		method := path.Base(url)
+		l.start(method)
+		l.log.Debug("api call", zap.String("method", method),
+			zap.String("url", l.Redact(url)), zap.String("data", l.Redact(fmt.Sprint(args[1]))))
+	case format == telegoResponseFormat && len(args) == 2:
+		method := fmt.Sprint(args[0])
+		l.log.Debug("api response", zap.String("method", method), zap.Duration("duration", l.finish(method)),
+			zap.String("response", l.Redact(fmt.Sprint(args[1]))))
+	default:
+		l.log.Debug(l.Redact(fmt.Sprintf(format, args...)))
+	}
+}
+
+func (l *TelegoLogger) Errorf(format string, args ...any) {
+	if format == telegoErrorFormat && len(args) == 2 {
+		method := fmt.Sprint(args[0])
+		l.log.Error("api call failed", zap.String("method", method), zap.Duration("duration", l.finish(method)),
+			zap.String("error", l.Redact(fmt.Sprint(args[1]))))
+		return
+	}
+	l.log.Error(l.Redact(fmt.Sprintf(format, args...)))
+}
+
+// start запоминает время начала вызова метода, одновременные вызовы одного метода
+// сопоставляются с ответами в порядке очереди
+func (l *TelegoLogger) start(method string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.started[method] = append(l.started[method], time.Now())
+}
+
+func (l *TelegoLogger) finish(method string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	queue := l.started[method]
+	if len(queue) == 0 {
+		return 0
+	}
+	started := queue[0]
+	if len(queue) == 1 {
+		delete(l.started, method)
+	} else {
+		l.started[method] = queue[1:]
+	}
+	return time.Since(started)
+}
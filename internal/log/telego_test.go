@@ -0,0 +1,142 @@
+package log
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mymmrac/telego"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	testToken  = "7012345678:AAHdqTcvCH1vGWJxfSeofSAs0K5PALDsawQ"
+	hookSecret = "webhook-secret-b1f2c3"
+	// otherToken токен, не переданный в секреты, маскируется по шаблону
+	otherToken = "6098765432:AAFr9xLmN2pQ8sT7uV6wX5yZ4aB3cD2eF1g"
+)
+
+// newTestTelegoLogger адаптер, пишущий записи в JSON в буфер
+func newTestTelegoLogger() (*TelegoLogger, *bytes.Buffer) {
+	var buf bytes.Buffer
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), zapcore.AddSync(&buf), zap.DebugLevel)
+	return NewTelegoLogger(zap.New(core), testToken, hookSecret), &buf
+}
+
+// entries записи лога, в которых не осталось секретов
+func entries(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	for _, secret := range []string{testToken, hookSecret, otherToken, "AAHdqTcv", "AAFr9xLm"} {
+		if strings.Contains(buf.String(), secret) {
+			t.Fatalf("log contains %q:\n%s", secret, buf.String())
+		}
+	}
+	var result []map[string]any
+	scanner := bufio.NewScanner(buf)
+	for scanner.Scan() {
+		var entry map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatal(err)
+		}
+		result = append(result, entry)
+	}
+	return result
+}
+
+func TestRedact(t *testing.T) {
+	l, _ := newTestTelegoLogger()
+	text := "https://api.telegram.org/bot" + testToken + "/getMe secret_token=" + hookSecret + " other " + otherToken
+	want := "https://api.telegram.org/bot" + Redacted + "/getMe secret_token=" + Redacted + " other " + Redacted
+	if got := l.Redact(text); got != want {
+		t.Fatalf("Redact = %q, want %q", got, want)
+	}
+}
+
+// Форматы совпадают с вызовами в telego/bot.go
+func TestTelegoLoggerFormats(t *testing.T) {
+	l, buf := newTestTelegoLogger()
+	url := "https://api.telegram.org/bot" + testToken + "/setWebhook"
+	l.Debugf("API call to: %q, with data: %s", url, `{"url":"https://example.com/hook","secret_token":"`+hookSecret+`"}`)
+	l.Debugf("API response %s: %s", "setWebhook", `Ok: true, Err: [<nil>], Result: true`)
+	l.Debugf("API call to: %q, with data: %s", url, "{}")
+	l.Errorf("Execution error %s: %s", "setWebhook",
+		fmt.Errorf("request call: %w", errors.New(`Post "`+url+`": dial tcp: i/o timeout`)))
+	l.Debugf("plain message with %s", otherToken)
+
+	logged := entries(t, buf)
+	if len(logged) != 5 {
+		t.Fatalf("%d entries, want 5", len(logged))
+	}
+	call, response, failed, plain := logged[0], logged[1], logged[3], logged[4]
+	if call["msg"] != "api call" || call["method"] != "setWebhook" || call["level"] != "debug" {
+		t.Fatalf("unexpected call entry %v", call)
+	}
+	if !strings.Contains(call["url"].(string), "/bot"+Redacted+"/setWebhook") {
+		t.Fatalf("url %v", call["url"])
+	}
+	if response["msg"] != "api response" || response["method"] != "setWebhook" {
+		t.Fatalf("unexpected response entry %v", response)
+	}
+	if failed["msg"] != "api call failed" || failed["method"] != "setWebhook" || failed["level"] != "error" {
+		t.Fatalf("unexpected error entry %v", failed)
+	}
+	for _, entry := range []map[string]any{response, failed} {
+		if d, ok := entry["duration"].(float64); !ok || d < 0 {
+			t.Fatalf("duration is not set: %v", entry)
+		}
+	}
+	if plain["msg"] != "plain message with "+Redacted {
+		t.Fatalf("unexpected plain entry %v", plain)
+	}
+}
+
+// Настоящий бот пишет в лог вызов, ответ и ошибку выполнения без токена
+func TestTelegoLoggerBot(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/sendMessage") {
+			_, _ = io.WriteString(w, `{"ok":true,"result":{"message_id":1,"date":0,"chat":{"id":1,"type":"private"}}}`)
+			return
+		}
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	l, buf := newTestTelegoLogger()
+	bot, err := telego.NewBot(testToken, telego.WithAPIServer(server.URL), telego.WithLogger(l))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = bot.SendMessage(&telego.SendMessageParams{
+		ChatID: telego.ChatID{ID: 1},
+		Text:   "webhook secret is " + hookSecret,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+	if _, err = bot.GetMe(); err == nil {
+		t.Fatal("call to a closed server succeeded")
+	}
+
+	var methods []string
+	for _, entry := range entries(t, buf) {
+		if entry["msg"] == "api response" || entry["msg"] == "api call failed" {
+			if _, ok := entry["duration"]; !ok {
+				t.Fatalf("duration is not set: %v", entry)
+			}
+		}
+		methods = append(methods, fmt.Sprint(entry["msg"], " ", entry["method"]))
+	}
+	want := []string{"api call sendMessage", "api response sendMessage", "api call getMe", "api call failed getMe"}
+	if strings.Join(methods, ", ") != strings.Join(want, ", ") {
+		t.Fatalf("logged %v, want %v", methods, want)
+	}
+}
@@ -0,0 +1,183 @@
+package log
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"github.com/mymmrac/telego"
+	"go.uber.org/zap"
+)
+
+// PayloadConfig вывод содержимого обновлений в лог
+type PayloadConfig struct {
+	// SampleEvery записывать содержимое каждого N-го обновления: 0 — никогда, 1 — всегда
+	SampleEvery int `json:"sample_every"`
+	// MaxBytes обрезать содержимое до указанного размера, 0 — без ограничения
+	MaxBytes int `json:"max_bytes"`
+}
+
+var payloadConfig atomic.Pointer[PayloadConfig]
+var payloadCounter atomic.Uint64
+
+// SetPayloadConfig изменить правила вывода содержимого обновлений
+func SetPayloadConfig(cfg PayloadConfig) {
+	payloadConfig.Store(&cfg)
+}
+
+// sensitiveKeys поля, значения которых не попадают в лог
+var sensitiveKeys = map[string]bool{
+	"phone_number": true,
+	"vcard":        true,
+	"email":        true,
+}
+
+// NewCorrelationID случайный идентификатор для сквозного поиска записей одного обновления
+func NewCorrelationID() string {
+	buf := make([]byte, 8)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// ForUpdate создает дочерний логгер обновления и сохраняет его в контексте
+func ForUpdate(ctx context.Context, update telego.Update) (context.Context, *zap.Logger) {
+	l := UpdateLogger(FromContext(ctx), update)
+	return WithLogger(ctx, l), l
+}
+
+// UpdateLogger дочерний логгер с полями обновления: update_id, тип, чат, пользователь,
+// сообщение и correlation_id
+func UpdateLogger(parent *zap.Logger, update telego.Update) *zap.Logger {
+	fields := []zap.Field{
+		zap.Int("update_id", update.UpdateID),
+		zap.String("correlation_id", NewCorrelationID()),
+	}
+
+	kind, chat, user, messageID := describeUpdate(update)
+	fields = append(fields, zap.String("update_type", kind))
+	if chat != nil {
+		fields = append(fields, zap.Int64("chat_id", chat.ID), zap.String("chat_type", chat.Type))
+	}
+	if user != nil {
+		fields = append(fields, zap.Int64("user_id", user.ID))
+	}
+	if messageID != 0 {
+		fields = append(fields, zap.Int("message_id", messageID))
+	}
+	return parent.With(fields...)
+}
+
+// Payload поле с содержимым обновления, если оно попадает в выборку. Телефоны,
+// контакты и email маскируются, длинное содержимое обрезается
+func Payload(update telego.Update) (zap.Field, bool) {
+	cfg := payloadConfig.Load()
+	if cfg == nil || cfg.SampleEvery <= 0 {
+		return zap.Skip(), false
+	}
+	if payloadCounter.Add(1)%uint64(cfg.SampleEvery) != 0 {
+		return zap.Skip(), false
+	}
+
+	data, err := json.Marshal(update)
+	if err != nil {
+		return zap.Skip(), false
+	}
+	var payload any
+	if err = json.Unmarshal(data, &payload); err != nil {
+		return zap.Skip(), false
+	}
+	data, _ = json.Marshal(mask(payload))
+
+	if cfg.MaxBytes > 0 && len(data) > cfg.MaxBytes {
+		// обрезается по границе символа, чтобы не оставить половину многобайтовой буквы
+		n := cfg.MaxBytes
+		for n > 0 && !utf8.RuneStart(data[n]) {
+			n--
+		}
+		return zap.String("payload", string(data[:n])+"…"), true
+	}
+	return zap.String("payload", string(data)), true
+}
+
+func mask(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			if sensitiveKeys[key] {
+				v[key] = Redacted
+				continue
+			}
+			v[key] = mask(value)
+		}
+	case []any:
+		for i := range v {
+			v[i] = mask(v[i])
+		}
+	}
+	return v
+}
+
+func describeUpdate(u telego.Update) (string, *telego.Chat, *telego.User, int) {
+	message := func(kind string, m *telego.Message) (string, *telego.Chat, *telego.User, int) {
+		return kind, &m.Chat, m.From, m.MessageID
+	}
+
+	switch {
+	case u.Message != nil:
+		return message("message", u.Message)
+	case u.EditedMessage != nil:
+		return message("edited_message", u.EditedMessage)
+	case u.ChannelPost != nil:
+		return message("channel_post", u.ChannelPost)
+	case u.EditedChannelPost != nil:
+		return message("edited_channel_post", u.EditedChannelPost)
+	case u.BusinessMessage != nil:
+		return message("business_message", u.BusinessMessage)
+	case u.EditedBusinessMessage != nil:
+		return message("edited_business_message", u.EditedBusinessMessage)
+	case u.DeletedBusinessMessages != nil:
+		return "deleted_business_messages", &u.DeletedBusinessMessages.Chat, nil, 0
+	case u.MessageReaction != nil:
+		r := u.MessageReaction
+		return "message_reaction", &r.Chat, r.User, r.MessageID
+	case u.MessageReactionCount != nil:
+		r := u.MessageReactionCount
+		return "message_reaction_count", &r.Chat, nil, r.MessageID
+	case u.InlineQuery != nil:
+		return "inline_query", nil, &u.InlineQuery.From, 0
+	case u.ChosenInlineResult != nil:
+		return "chosen_inline_result", nil, &u.ChosenInlineResult.From, 0
+	case u.CallbackQuery != nil:
+		q := u.CallbackQuery
+		if q.Message != nil {
+			chat := q.Message.GetChat()
+			return "callback_query", &chat, &q.From, q.Message.GetMessageID()
+		}
+		return "callback_query", nil, &q.From, 0
+	case u.MyChatMember != nil:
+		return "my_chat_member", &u.MyChatMember.Chat, &u.MyChatMember.From, 0
+	case u.ChatMember != nil:
+		return "chat_member", &u.ChatMember.Chat, &u.ChatMember.From, 0
+	case u.ChatJoinRequest != nil:
+		return "chat_join_request", &u.ChatJoinRequest.Chat, &u.ChatJoinRequest.From, 0
+	}
+	return "other", nil, nil, 0
+}
+
+// UpdateKind тип обновления и тип чата, в котором оно произошло
+func UpdateKind(update telego.Update) (kind string, chatType string) {
+	kind, chat, _, _ := describeUpdate(update)
+	if chat != nil {
+		chatType = chat.Type
+	}
+	return kind, chatType
+}
+
+// UpdateChat чат, в котором произошло обновление, nil для inline-запросов
+func UpdateChat(update telego.Update) *telego.Chat {
+	_, chat, _, _ := describeUpdate(update)
+	return chat
+}
@@ -0,0 +1,131 @@
+package log
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/mymmrac/telego"
+)
+
+// setPayload задает правила вывода и сбрасывает счетчик выборки
+func setPayload(t *testing.T, cfg PayloadConfig) {
+	t.Helper()
+	SetPayloadConfig(cfg)
+	payloadCounter.Store(0)
+	t.Cleanup(func() { payloadConfig.Store(nil) })
+}
+
+func payload(t *testing.T, update telego.Update) string {
+	t.Helper()
+	field, ok := Payload(update)
+	if !ok {
+		t.Fatal("payload is not sampled")
+	}
+	return field.String
+}
+
+func textUpdate(text string) telego.Update {
+	return telego.Update{UpdateID: 1, Message: &telego.Message{
+		MessageID: 2,
+		Chat:      telego.Chat{ID: -100, Type: telego.ChatTypeSupergroup},
+		Text:      text,
+	}}
+}
+
+func TestPayloadSampling(t *testing.T) {
+	setPayload(t, PayloadConfig{})
+	if _, ok := Payload(textUpdate("text")); ok {
+		t.Fatal("payload logged with sampling disabled")
+	}
+
+	setPayload(t, PayloadConfig{SampleEvery: 3})
+	var sampled []int
+	for i := 1; i <= 7; i++ {
+		if _, ok := Payload(textUpdate("text")); ok {
+			sampled = append(sampled, i)
+		}
+	}
+	if len(sampled) != 2 || sampled[0] != 3 || sampled[1] != 6 {
+		t.Fatalf("sampled updates %v, want [3 6]", sampled)
+	}
+}
+
+func TestPayloadTruncate(t *testing.T) {
+	setPayload(t, PayloadConfig{SampleEvery: 1})
+	full := payload(t, textUpdate(strings.Repeat("я", 100)))
+
+	// ограничение попадает на все позиции внутри двухбайтовых букв
+	start := strings.Index(full, "я")
+	for limit := start; limit < start+10; limit++ {
+		setPayload(t, PayloadConfig{SampleEvery: 1, MaxBytes: limit})
+		got := payload(t, textUpdate(strings.Repeat("я", 100)))
+		if !utf8.ValidString(got) {
+			t.Fatalf("limit %d: invalid UTF-8 in %q", limit, got)
+		}
+		cut, ok := strings.CutSuffix(got, "…")
+		if !ok || len(cut) > limit || len(cut) < limit-1 || !strings.HasPrefix(full, cut) {
+			t.Fatalf("limit %d: payload %q", limit, got)
+		}
+	}
+
+	setPayload(t, PayloadConfig{SampleEvery: 1, MaxBytes: len(full)})
+	if got := payload(t, textUpdate(strings.Repeat("я", 100))); got != full {
+		t.Fatalf("payload within limit is truncated: %q", got)
+	}
+}
+
+func TestPayloadMask(t *testing.T) {
+	setPayload(t, PayloadConfig{SampleEvery: 1})
+	contact := func(phone string) *telego.Contact {
+		return &telego.Contact{PhoneNumber: phone, FirstName: "Иван", Vcard: "BEGIN:VCARD\nTEL:" + phone + "\nEND:VCARD"}
+	}
+	update := textUpdate("")
+	update.Message.Contact = contact("+79990001122")
+	// вложенные сообщения маскируются так же
+	update.Message.ReplyToMessage = &telego.Message{
+		MessageID: 1,
+		Chat:      update.Message.Chat,
+		Contact:   contact("+79993334455"),
+	}
+	update.Message.ExternalReply = &telego.ExternalReplyInfo{
+		Origin:  &telego.MessageOriginHiddenUser{Type: telego.OriginTypeHiddenUser, SenderUserName: "hidden"},
+		Contact: contact("+79996667788"),
+	}
+
+	got := payload(t, update)
+	for _, secret := range []string{"+7999", "VCARD", "TEL:"} {
+		if strings.Contains(got, secret) {
+			t.Fatalf("payload contains %q: %s", secret, got)
+		}
+	}
+	if n := strings.Count(got, `"phone_number":"`+Redacted+`"`); n != 3 {
+		t.Fatalf("%d masked phone numbers, want 3: %s", n, got)
+	}
+	if n := strings.Count(got, `"vcard":"`+Redacted+`"`); n != 3 {
+		t.Fatalf("%d masked vcards, want 3: %s", n, got)
+	}
+	if !strings.Contains(got, `"first_name":"Иван"`) {
+		t.Fatalf("non-sensitive fields masked: %s", got)
+	}
+}
+
+// Маскирование проходит по массивам, например по общим контактам
+func TestMaskNested(t *testing.T) {
+	v := map[string]any{
+		"users": []any{
+			map[string]any{"phone_number": "+1", "name": "a"},
+			map[string]any{"contact": map[string]any{"vcard": "card", "email": "a@example.com"}},
+		},
+	}
+	mask(v)
+	users := v["users"].([]any)
+	first := users[0].(map[string]any)
+	contact := users[1].(map[string]any)["contact"].(map[string]any)
+	if first["phone_number"] != Redacted || first["name"] != "a" {
+		t.Fatalf("unexpected %v", first)
+	}
+	if contact["vcard"] != Redacted || contact["email"] != Redacted {
+		t.Fatalf("unexpected %v", contact)
+	}
+}
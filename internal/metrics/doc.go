@@ -0,0 +1,3 @@
+package metrics
+
+// метрики в текстовом формате Prometheus: счетчики, gauge и гистограммы с метками
@@ -0,0 +1,83 @@
+package metrics
+
+import (
+	"bytes"
+	"flag"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "перезаписать golden-файлы")
+
+// resetRegistry убирает метрики, зарегистрированные другими тестами
+func resetRegistry() {
+	registry.mu.Lock()
+	registry.collectors = make(map[string]collector)
+	registry.mu.Unlock()
+}
+
+func TestWriteTo(t *testing.T) {
+	resetRegistry()
+
+	counter := NewCounterVec("test_requests_total", "Запросы по методу и коду", "method", "code")
+	counter.Inc("sendMessage", "200")
+	counter.Add(2, "getUpdates", "200")
+	counter.Inc("sendMessage", "429")
+	NewCounterVec("test_started_total", "Счетчик без меток")
+
+	gauge := NewGaugeVec("test_queue_depth", `Описание с \ обратной чертой,
+переводом строки и "кавычками"`, "bot")
+	gauge.Set(3, `bot "quoted"`)
+	gauge.Set(-1.5, `back\slash`)
+	gauge.Add(1, "multi\nline")
+
+	NewGaugeFunc("test_special_values", "Бесконечность и NaN", []string{"kind"},
+		func(set func(v float64, values ...string)) {
+			set(math.Inf(1), "inf")
+			set(math.Inf(-1), "neg_inf")
+			set(math.NaN(), "nan")
+			set(1e21, "big")
+		})
+
+	hist := NewHistogramVec("test_duration_seconds", "Длительность", []float64{1, 0.1, 0.5}, "kind")
+	hist.Observe(0.05, "json")
+	hist.Observe(0.3, "json")
+	hist.Observe(7, "json")
+	NewHistogramVec("test_empty_seconds", "Гистограмма без наблюдений", []float64{1})
+
+	var buf bytes.Buffer
+	if err := WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	golden(t, "exposition.golden", buf.Bytes())
+}
+
+func TestLabelCount(t *testing.T) {
+	resetRegistry()
+	counter := NewCounterVec("test_labels_total", "Метки", "a", "b")
+	defer func() {
+		if recover() == nil {
+			t.Error("Inc with a wrong number of label values did not panic")
+		}
+	}()
+	counter.Inc("only one")
+}
+
+func golden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("output differs from %s, run go test -update to rewrite it\ngot:\n%s\nwant:\n%s", path, got, want)
+	}
+}
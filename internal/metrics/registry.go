@@ -0,0 +1,143 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// collector семейство метрик с одним именем
+type collector interface {
+	name() string
+	write(w *bufio.Writer)
+}
+
+// registry все зарегистрированные метрики процесса
+var registry = struct {
+	mu         sync.Mutex
+	collectors map[string]collector
+}{collectors: make(map[string]collector)}
+
+// register добавляет семейство, семейство с тем же именем заменяется
+func register(c collector) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	registry.collectors[c.name()] = c
+}
+
+// WriteTo пишет все метрики в текстовом формате Prometheus
+func WriteTo(w io.Writer) error {
+	registry.mu.Lock()
+	collectors := make([]collector, 0, len(registry.collectors))
+	for _, c := range registry.collectors {
+		collectors = append(collectors, c)
+	}
+	registry.mu.Unlock()
+	sort.Slice(collectors, func(i, j int) bool { return collectors[i].name() < collectors[j].name() })
+
+	bw := bufio.NewWriter(w)
+	for _, c := range collectors {
+		c.write(bw)
+	}
+	return bw.Flush()
+}
+
+// Handler обработчик /metrics
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = WriteTo(w)
+	})
+}
+
+// family общая часть семейств: имя, описание, тип и имена меток
+type family struct {
+	fullName string
+	help     string
+	kind     string
+	labels   []string
+}
+
+func (f *family) name() string {
+	return f.fullName
+}
+
+func (f *family) writeHeader(w *bufio.Writer) {
+	w.WriteString("# HELP " + f.fullName + " " + helpEscaper.Replace(f.help) + "\n")
+	w.WriteString("# TYPE " + f.fullName + " " + f.kind + "\n")
+}
+
+// key ключ серии по значениям меток
+func (f *family) key(values []string) string {
+	if len(values) != len(f.labels) {
+		panic("metrics: " + f.fullName + ": expected " + strconv.Itoa(len(f.labels)) + " label values")
+	}
+	return strings.Join(values, "\xff")
+}
+
+// writeSample пишет строку name{labels} value, le граница корзины гистограммы
+func (f *family) writeSample(w *bufio.Writer, suffix string, values []string, le string, v float64) {
+	w.WriteString(f.fullName + suffix)
+	if len(values) > 0 || le != "" {
+		w.WriteByte('{')
+		for i, name := range f.labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(name + `="` + escape(values[i]) + `"`)
+		}
+		if le != "" {
+			if len(values) > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(`le="` + le + `"`)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteString(" " + formatFloat(v) + "\n")
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	// в HELP кавычки не экранируются
+	helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escape(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// sortedKeys ключи серий в стабильном порядке
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// splitKey значения меток из ключа серии
+func splitKey(key string, n int) []string {
+	if n == 0 {
+		return nil
+	}
+	return strings.Split(key, "\xff")
+}
@@ -0,0 +1,189 @@
+package metrics
+
+import (
+	"bufio"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefBuckets границы гистограмм длительности в секундах
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+// CounterVec счетчик с метками
+type CounterVec struct {
+	family
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// NewCounterVec регистрирует счетчик name с метками labels
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		family: family{fullName: name, help: help, kind: "counter", labels: labels},
+		values: make(map[string]float64),
+	}
+	if len(labels) == 0 {
+		// счетчик без меток виден сразу, а не после первого события
+		c.values[""] = 0
+	}
+	register(c)
+	return c
+}
+
+// Add увеличивает счетчик серии с метками values на v
+func (c *CounterVec) Add(v float64, values ...string) {
+	key := c.key(values)
+	c.mu.Lock()
+	c.values[key] += v
+	c.mu.Unlock()
+}
+
+// Inc увеличивает счетчик серии на 1
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeHeader(w)
+	for _, key := range sortedKeys(c.values) {
+		c.writeSample(w, "", splitKey(key, len(c.labels)), "", c.values[key])
+	}
+}
+
+// GaugeVec текущее значение с метками
+type GaugeVec struct {
+	family
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// NewGaugeVec регистрирует gauge name с метками labels
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{
+		family: family{fullName: name, help: help, kind: "gauge", labels: labels},
+		values: make(map[string]float64),
+	}
+	register(g)
+	return g
+}
+
+// Set устанавливает значение серии
+func (g *GaugeVec) Set(v float64, values ...string) {
+	key := g.key(values)
+	g.mu.Lock()
+	g.values[key] = v
+	g.mu.Unlock()
+}
+
+// Add изменяет значение серии на v
+func (g *GaugeVec) Add(v float64, values ...string) {
+	key := g.key(values)
+	g.mu.Lock()
+	g.values[key] += v
+	g.mu.Unlock()
+}
+
+func (g *GaugeVec) write(w *bufio.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.writeHeader(w)
+	for _, key := range sortedKeys(g.values) {
+		g.writeSample(w, "", splitKey(key, len(g.labels)), "", g.values[key])
+	}
+}
+
+// GaugeFunc gauge, значения которого вычисляются при каждом чтении метрик
+type GaugeFunc struct {
+	family
+	collect func(set func(v float64, values ...string))
+}
+
+// NewGaugeFunc регистрирует gauge, collect вызывает set для каждой серии
+func NewGaugeFunc(name, help string, labels []string, collect func(set func(v float64, values ...string))) *GaugeFunc {
+	g := &GaugeFunc{
+		family:  family{fullName: name, help: help, kind: "gauge", labels: labels},
+		collect: collect,
+	}
+	register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w *bufio.Writer) {
+	g.writeHeader(w)
+	g.collect(func(v float64, values ...string) {
+		g.key(values)
+		g.writeSample(w, "", values, "", v)
+	})
+}
+
+// HistogramVec распределение значений с метками
+type HistogramVec struct {
+	family
+	buckets []float64
+	mu      sync.Mutex
+	values  map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewHistogramVec регистрирует гистограмму name с границами корзин buckets
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	h := &HistogramVec{
+		family:  family{fullName: name, help: help, kind: "histogram", labels: labels},
+		buckets: buckets,
+		values:  make(map[string]*histogram),
+	}
+	register(h)
+	return h
+}
+
+// Observe добавляет значение в серию с метками values
+func (h *HistogramVec) Observe(v float64, values ...string) {
+	key := h.key(values)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.values[key]
+	if !ok {
+		s = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = s
+	}
+	for i, bound := range h.buckets {
+		if v <= bound {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += v
+}
+
+// Since добавляет время, прошедшее с start, в секундах
+func (h *HistogramVec) Since(start time.Time, values ...string) {
+	h.Observe(time.Since(start).Seconds(), values...)
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.writeHeader(w)
+	for _, key := range sortedKeys(h.values) {
+		s := h.values[key]
+		values := splitKey(key, len(h.labels))
+		for i, bound := range h.buckets {
+			h.writeSample(w, "_bucket", values, formatFloat(bound), float64(s.counts[i]))
+		}
+		h.writeSample(w, "_bucket", values, formatFloat(math.Inf(1)), float64(s.count))
+		h.writeSample(w, "_sum", values, "", s.sum)
+		h.writeSample(w, "_count", values, "", float64(s.count))
+	}
+}
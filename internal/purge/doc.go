@@ -0,0 +1,3 @@
+package purge
+
+// удаление данных пользователя из архива по его запросу или по запросу администратора чата
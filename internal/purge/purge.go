@@ -0,0 +1,183 @@
+package purge
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"tg-archive-bot/internal/archive"
+	"tg-archive-bot/internal/search"
+)
+
+// Действия в журнале аудита
+const (
+	ActionForgetMe = "forgetme"
+	ActionPurge    = "purge"
+	ActionCLI      = "cli"
+)
+
+// Plan что будет удалено. Реакции хранятся только как общие счетчики без авторов,
+// поэтому в план не входят
+type Plan struct {
+	UserID int64
+	// ChatID чат, в котором удаляются сообщения, 0 — все чаты
+	ChatID   int64
+	Messages []search.DocKey
+	Chats    int
+	// Blobs вложения удаляемых сообщений, на которые не ссылаются другие сообщения
+	Blobs []string
+	// Profile удалить сохраненный профиль: при удалении из всех чатов или когда
+	// других сообщений пользователя в архиве не остается
+	Profile bool
+	// PrivateChat удалить описание личного чата пользователя с ботом
+	PrivateChat bool
+	// HeldChats чаты под legal_hold, сообщения в которых не удаляются
+	HeldChats int
+}
+
+// Empty удалять нечего
+func (p *Plan) Empty() bool {
+	return len(p.Messages) == 0 && len(p.Blobs) == 0 && !p.Profile && !p.PrivateChat
+}
+
+// Prepare составляет план удаления сообщений пользователя userID в чате chatID
+// или во всех чатах, если chatID равен 0. Чаты, для которых held возвращает true,
+// не затрагиваются. Ничего не удаляет
+func Prepare(store *archive.Store, userID, chatID int64, held func(chatID int64) bool) (*Plan, error) {
+	chats, err := store.Chats()
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{UserID: userID, ChatID: chatID}
+	// ссылки на вложения от сообщений, которые останутся, и от удаляемых
+	kept := make(map[string]int)
+	removed := make(map[string]bool)
+	var remaining, heldRemaining bool
+	for _, id := range chats {
+		isHeld := held != nil && held(id)
+		inScope := (chatID == 0 || id == chatID) && !isHeld
+		var found, foundHeld bool
+		err = store.Messages(id, func(m *archive.Message) error {
+			own := m.FromID == userID || (chatID == 0 && m.ChatID == userID)
+			for _, media := range m.Media {
+				if media.Blob == "" {
+					continue
+				}
+				if own && inScope {
+					removed[media.Blob] = true
+				} else {
+					kept[media.Blob]++
+				}
+			}
+			switch {
+			case own && inScope:
+				plan.Messages = append(plan.Messages, search.DocKey{ChatID: m.ChatID, MessageID: m.ID})
+				found = true
+			case m.FromID == userID:
+				remaining = true
+				if isHeld {
+					foundHeld = true
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("read chat %d: %w", id, err)
+		}
+		if found {
+			plan.Chats++
+		}
+		if foundHeld && (chatID == 0 || chatID == id) {
+			plan.HeldChats++
+			heldRemaining = true
+		}
+	}
+	for blob := range removed {
+		if kept[blob] == 0 {
+			plan.Blobs = append(plan.Blobs, blob)
+		}
+	}
+
+	// профиль нужен сообщениям, которые остаются под legal_hold
+	if (chatID == 0 && !heldRemaining) || !remaining {
+		_, err = store.GetUser(userID)
+		plan.Profile = err == nil
+	}
+	if (chatID == 0 || chatID == userID) && (held == nil || !held(userID)) {
+		_, err = store.GetChat(userID)
+		plan.PrivateChat = err == nil
+	}
+	return plan, nil
+}
+
+// Audit запись журнала аудита. Содержимое сообщений и данные пользователя не пишутся
+type Audit struct {
+	Time   time.Time `json:"time"`
+	Action string    `json:"action"`
+	ChatID int64     `json:"chat_id,omitempty"`
+	// RequestedBy администратор, который запросил удаление. Для /forgetme не пишется,
+	// чтобы запись не указывала на пользователя
+	RequestedBy int64  `json:"requested_by,omitempty"`
+	Messages    int    `json:"messages"`
+	Blobs       int    `json:"blobs"`
+	Profile     bool   `json:"profile,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// Apply удаляет данные по плану, сохраняет снимок индекса без удаленных сообщений
+// и дописывает запись в журнал аудита. Ошибки не прерывают удаление остального
+func Apply(store *archive.Store, index *search.Index, plan *Plan, action string, requestedBy int64) (*Audit, error) {
+	audit := &Audit{Time: time.Now().UTC(), Action: action, ChatID: plan.ChatID, RequestedBy: requestedBy}
+	var errs []error
+	ignore := func(err error) error {
+		if errors.Is(err, archive.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	for _, key := range plan.Messages {
+		if err := index.Delete(key.ChatID, key.MessageID); err != nil {
+			errs = append(errs, fmt.Errorf("delete from index: %w", err))
+		}
+		if err := ignore(store.DeleteMessage(key.ChatID, key.MessageID)); err != nil {
+			errs = append(errs, fmt.Errorf("delete message: %w", err))
+			continue
+		}
+		audit.Messages++
+	}
+	for _, blob := range plan.Blobs {
+		if err := ignore(store.DeleteBlob(blob)); err != nil {
+			errs = append(errs, fmt.Errorf("delete blob: %w", err))
+			continue
+		}
+		audit.Blobs++
+	}
+	if plan.Profile {
+		if err := ignore(store.DeleteUser(plan.UserID)); err != nil {
+			errs = append(errs, fmt.Errorf("delete user: %w", err))
+		} else {
+			audit.Profile = true
+		}
+	}
+	if plan.PrivateChat {
+		if err := ignore(store.DeleteChat(plan.UserID)); err != nil {
+			errs = append(errs, fmt.Errorf("delete private chat: %w", err))
+		}
+	}
+	// в журнале индекса остаются только ключи, но снимок пересохраняется,
+	// чтобы в нем не осталось слов удаленных сообщений
+	if err := index.Save(); err != nil {
+		errs = append(errs, fmt.Errorf("save search index: %w", err))
+	}
+
+	err := errors.Join(errs...)
+	if err != nil {
+		audit.Error = err.Error()
+	}
+	if auditErr := store.AppendAudit(audit); auditErr != nil {
+		err = errors.Join(err, fmt.Errorf("write audit: %w", auditErr))
+	}
+	return audit, err
+}
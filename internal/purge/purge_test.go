@@ -0,0 +1,240 @@
+package purge
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"tg-archive-bot/internal/archive"
+	"tg-archive-bot/internal/search"
+)
+
+const (
+	user  = 5
+	other = 6
+	chatA = -1
+	chatB = -2
+)
+
+type testArchive struct {
+	t     *testing.T
+	store *archive.Store
+	index *search.Index
+}
+
+// newTestArchive архив, в котором user пишет в оба чата, а other пересылает его файл в chatB
+func newTestArchive(t *testing.T) (*testArchive, string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	store, err := archive.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	index, err := search.Open(filepath.Join(dir, "index"), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { index.Close() })
+	a := &testArchive{t: t, store: store, index: index}
+
+	for _, id := range []int64{user, other} {
+		if err = store.PutUser(&archive.User{ID: id, FirstName: "user"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	shared, own := a.blob("shared"), a.blob("own")
+	a.put(chatA, 1, user, archive.Media{Kind: archive.MediaPhoto, Blob: shared})
+	a.put(chatA, 2, user, archive.Media{Kind: archive.MediaDocument, Blob: own})
+	a.put(chatA, 3, other)
+	a.put(chatB, 1, user)
+	a.put(chatB, 2, other, archive.Media{Kind: archive.MediaPhoto, Blob: shared})
+	return a, shared, own
+}
+
+func (a *testArchive) blob(content string) string {
+	a.t.Helper()
+	hash, _, err := a.store.PutBlob(chatA, bytes.NewReader([]byte(content)))
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return hash
+}
+
+func (a *testArchive) put(chatID int64, id int, from int64, media ...archive.Media) {
+	a.t.Helper()
+	m := &archive.Message{ChatID: chatID, ID: id, FromID: from, Text: "message text", Media: media}
+	if err := a.store.PutMessage(m); err != nil {
+		a.t.Fatal(err)
+	}
+	if err := a.index.Update(m); err != nil {
+		a.t.Fatal(err)
+	}
+}
+
+func (a *testArchive) exists(chatID int64, id int) bool {
+	a.t.Helper()
+	_, err := a.store.GetMessage(chatID, id)
+	if err != nil && !errors.Is(err, archive.ErrNotFound) {
+		a.t.Fatal(err)
+	}
+	return err == nil
+}
+
+func (a *testArchive) blobExists(hash string) bool {
+	a.t.Helper()
+	r, err := a.store.OpenBlob(hash)
+	if errors.Is(err, archive.ErrNotFound) {
+		return false
+	}
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	r.Close()
+	return true
+}
+
+func (a *testArchive) profile(id int64) bool {
+	_, err := a.store.GetUser(id)
+	return err == nil
+}
+
+func keys(plan *Plan) []search.DocKey {
+	k := slices.Clone(plan.Messages)
+	slices.SortFunc(k, func(x, y search.DocKey) int {
+		if x.ChatID != y.ChatID {
+			return int(x.ChatID - y.ChatID)
+		}
+		return x.MessageID - y.MessageID
+	})
+	return k
+}
+
+// Файл, на который ссылается оставшееся сообщение, остается на диске
+func TestPurgeSharedBlob(t *testing.T) {
+	a, shared, own := newTestArchive(t)
+	plan, err := Prepare(a.store, user, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []search.DocKey{{ChatID: chatB, MessageID: 1}, {ChatID: chatA, MessageID: 1}, {ChatID: chatA, MessageID: 2}}
+	if !slices.Equal(keys(plan), want) || plan.Chats != 2 || !plan.Profile {
+		t.Fatalf("unexpected plan %+v", plan)
+	}
+	if !slices.Equal(plan.Blobs, []string{own}) {
+		t.Fatalf("blobs %v, want only the user's own", plan.Blobs)
+	}
+
+	audit, err := Apply(a.store, a.index, plan, ActionForgetMe, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if audit.Messages != 3 || audit.Blobs != 1 || !audit.Profile || audit.RequestedBy != 0 {
+		t.Fatalf("unexpected audit %+v", audit)
+	}
+	if a.blobExists(own) || !a.blobExists(shared) {
+		t.Fatal("wrong blobs deleted")
+	}
+	for _, key := range want {
+		if a.exists(key.ChatID, key.MessageID) || a.index.Has(key) {
+			t.Fatalf("message %v is not deleted", key)
+		}
+	}
+	if !a.exists(chatA, 3) || !a.exists(chatB, 2) || a.profile(user) || !a.profile(other) {
+		t.Fatal("other user's data deleted")
+	}
+}
+
+func TestPurgeLegalHold(t *testing.T) {
+	a, shared, own := newTestArchive(t)
+	held := func(chatID int64) bool { return chatID == chatA }
+
+	plan, err := Prepare(a.store, user, 0, held)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(keys(plan), []search.DocKey{{ChatID: chatB, MessageID: 1}}) || plan.HeldChats != 1 {
+		t.Fatalf("unexpected plan %+v", plan)
+	}
+	// профиль нужен сообщениям под legal_hold, файлы удержанного чата не удаляются
+	if plan.Profile || len(plan.Blobs) != 0 {
+		t.Fatalf("unexpected plan %+v", plan)
+	}
+	if _, err = Apply(a.store, a.index, plan, ActionForgetMe, 0); err != nil {
+		t.Fatal(err)
+	}
+	if a.exists(chatB, 1) || !a.exists(chatA, 1) || !a.exists(chatA, 2) {
+		t.Fatal("legal hold is not respected")
+	}
+	if !a.profile(user) || !a.blobExists(own) || !a.blobExists(shared) {
+		t.Fatal("data of held messages deleted")
+	}
+
+	// удаление в другом чате тоже оставляет профиль, но удержанный чат не считает
+	plan, err = Prepare(a.store, user, chatB, held)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.HeldChats != 0 || plan.Profile || !plan.Empty() {
+		t.Fatalf("unexpected plan %+v", plan)
+	}
+	plan, err = Prepare(a.store, user, chatA, held)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.HeldChats != 1 || len(plan.Messages) != 0 || plan.Profile {
+		t.Fatalf("unexpected plan %+v", plan)
+	}
+}
+
+// Удаление в одном чате оставляет профиль, пока у пользователя есть другие сообщения
+func TestPurgeChat(t *testing.T) {
+	a, _, own := newTestArchive(t)
+	plan, err := Prepare(a.store, user, chatA, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Messages) != 2 || plan.Profile || !slices.Equal(plan.Blobs, []string{own}) {
+		t.Fatalf("unexpected plan %+v", plan)
+	}
+	audit, err := Apply(a.store, a.index, plan, ActionPurge, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if audit.ChatID != chatA || audit.RequestedBy != other || audit.Profile {
+		t.Fatalf("unexpected audit %+v", audit)
+	}
+	if !a.profile(user) || !a.exists(chatB, 1) {
+		t.Fatal("data outside the chat deleted")
+	}
+
+	// последние сообщения удаляются вместе с профилем
+	if plan, err = Prepare(a.store, user, chatB, nil); err != nil || !plan.Profile {
+		t.Fatalf("plan %+v, %v", plan, err)
+	}
+}
+
+// План без Apply ничего не удаляет
+func TestPrepareDryRun(t *testing.T) {
+	a, shared, own := newTestArchive(t)
+	plan, err := Prepare(a.store, user, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Empty() {
+		t.Fatal("empty plan")
+	}
+	for _, key := range plan.Messages {
+		if !a.exists(key.ChatID, key.MessageID) || !a.index.Has(key) {
+			t.Fatalf("message %v deleted by a dry run", key)
+		}
+	}
+	if !a.blobExists(shared) || !a.blobExists(own) || !a.profile(user) {
+		t.Fatal("data deleted by a dry run")
+	}
+	if plan, err = Prepare(a.store, 42, 0, nil); err != nil || !plan.Empty() {
+		t.Fatalf("plan for an unknown user %+v, %v", plan, err)
+	}
+}
@@ -0,0 +1,3 @@
+package query
+
+// разбор поисковых запросов с фильтрами и их выполнение по архиву
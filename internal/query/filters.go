@@ -0,0 +1,199 @@
+package query
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"tg-archive-bot/internal/archive"
+)
+
+// dateLayout формат дат в фильтрах before:/after:
+const dateLayout = "2006-01-02"
+
+// filterNode фильтр вида ключ:значение, проверяемый по сообщению
+type filterNode struct {
+	key   string
+	value string
+	// raw значение как в запросе, до нормализации
+	raw string
+	// id числовое значение для chat:, topic:, from:, mentions:
+	id   int64
+	isID bool
+	date time.Time
+	pred func(f *filterNode, m *archive.Message, ev *evaluator) bool
+}
+
+var filters = map[string]func(f *filterNode, m *archive.Message, ev *evaluator) bool{
+	"chat":     matchChat,
+	"topic":    matchTopic,
+	"from":     matchFrom,
+	"before":   matchBefore,
+	"after":    matchAfter,
+	"has":      matchHas,
+	"is":       matchIs,
+	"reaction": matchReaction,
+	"mentions": matchMentions,
+}
+
+var hasValues = map[string]bool{"photo": true, "video": true, "voice": true, "link": true, "file": true}
+
+var isValues = map[string]bool{"forwarded": true, "edited": true, "deleted": true, "reply": true}
+
+func isFilter(key string) bool {
+	_, ok := filters[key]
+	return ok
+}
+
+// compileFilter проверяет значение фильтра и готовит его к проверке
+func compileFilter(tok token) (*filterNode, error) {
+	f := &filterNode{key: tok.key, value: tok.value, raw: tok.value, pred: filters[tok.key]}
+	valuePos := tok.pos + len([]rune(tok.key)) + 1
+
+	switch f.key {
+	case "chat", "from", "mentions":
+		value := strings.TrimPrefix(f.value, "@")
+		if id, err := strconv.ParseInt(value, 10, 64); err == nil {
+			f.id, f.isID = id, true
+		}
+		f.value = strings.ToLower(value)
+	case "topic":
+		id, err := strconv.ParseInt(f.value, 10, 64)
+		if err != nil || id <= 0 {
+			return nil, errorf(valuePos, "topic: expected topic id, got %q", f.value)
+		}
+		f.id, f.isID = id, true
+	case "before", "after":
+		t, err := time.ParseInLocation(dateLayout, f.value, time.Local)
+		if err != nil {
+			return nil, errorf(valuePos, "%s: expected date YYYY-MM-DD, got %q", f.key, f.value)
+		}
+		f.date = t
+	case "has":
+		f.value = strings.ToLower(f.value)
+		if !hasValues[f.value] {
+			return nil, errorf(valuePos, "has: expected photo, video, voice, link or file, got %q", tok.value)
+		}
+	case "is":
+		f.value = strings.ToLower(f.value)
+		if !isValues[f.value] {
+			return nil, errorf(valuePos, "is: expected forwarded, edited, deleted or reply, got %q", tok.value)
+		}
+	}
+	return f, nil
+}
+
+func (f *filterNode) match(m *archive.Message, ev *evaluator) bool {
+	return f.pred(f, m, ev)
+}
+
+// matchChat chat:-1001234567890, chat:@username или часть названия
+func matchChat(f *filterNode, m *archive.Message, ev *evaluator) bool {
+	if f.isID {
+		return m.ChatID == f.id
+	}
+	chat := ev.chat(m.ChatID)
+	if chat == nil {
+		return false
+	}
+	return strings.EqualFold(chat.Username, f.value) || strings.Contains(strings.ToLower(chat.Title), f.value)
+}
+
+func matchTopic(f *filterNode, m *archive.Message, _ *evaluator) bool {
+	return int64(m.ThreadID) == f.id
+}
+
+// matchFrom from:@username, from:<user_id> или имя автора: from:Иван, from:"Иван Петров"
+func matchFrom(f *filterNode, m *archive.Message, ev *evaluator) bool {
+	if f.isID {
+		return m.FromID == f.id
+	}
+	author := ev.user(m.FromID)
+	if author == nil {
+		return false
+	}
+	if strings.EqualFold(author.Username, f.value) {
+		return true
+	}
+	if strings.HasPrefix(f.raw, "@") {
+		return false
+	}
+	fullName := strings.TrimSpace(author.FirstName + " " + author.LastName)
+	return strings.EqualFold(fullName, f.value) || strings.EqualFold(author.FirstName, f.value) ||
+		strings.EqualFold(author.LastName, f.value)
+}
+
+// matchBefore before: не включает указанный день
+func matchBefore(f *filterNode, m *archive.Message, _ *evaluator) bool {
+	return time.Unix(m.Date, 0).Before(f.date)
+}
+
+// matchAfter after: включает указанный день
+func matchAfter(f *filterNode, m *archive.Message, _ *evaluator) bool {
+	return !time.Unix(m.Date, 0).Before(f.date)
+}
+
+func matchHas(f *filterNode, m *archive.Message, _ *evaluator) bool {
+	if f.value == "link" {
+		for _, e := range m.Entities {
+			if e.Type == "url" || e.Type == "text_link" {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, media := range m.Media {
+		switch {
+		case f.value == "photo" && media.Kind == archive.MediaPhoto,
+			f.value == "video" && (media.Kind == archive.MediaVideo || media.Kind == archive.MediaVideoNote),
+			f.value == "voice" && media.Kind == archive.MediaVoice,
+			f.value == "file" && (media.Kind == archive.MediaDocument || media.Kind == archive.MediaAudio):
+			return true
+		}
+	}
+	return false
+}
+
+func matchIs(f *filterNode, m *archive.Message, _ *evaluator) bool {
+	switch f.value {
+	case "forwarded":
+		return m.ForwardFrom != ""
+	case "edited":
+		return m.EditDate != 0
+	case "deleted":
+		return m.Deleted
+	case "reply":
+		return m.ReplyToID != 0
+	}
+	return false
+}
+
+func matchReaction(f *filterNode, m *archive.Message, _ *evaluator) bool {
+	for _, r := range m.Reactions {
+		if r.Emoji == f.value && r.Count > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// matchMentions mentions:@username или mentions:<user_id>
+func matchMentions(f *filterNode, m *archive.Message, ev *evaluator) bool {
+	for _, e := range m.Entities {
+		switch e.Type {
+		case "mention":
+			if !f.isID && strings.EqualFold(strings.TrimPrefix(e.Text, "@"), f.value) {
+				return true
+			}
+		case "text_mention":
+			if f.isID && e.UserID == f.id {
+				return true
+			}
+			if u := ev.user(e.UserID); !f.isID && u != nil && strings.EqualFold(u.Username, f.value) {
+				return true
+			}
+		}
+	}
+	return false
+}
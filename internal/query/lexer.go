@@ -0,0 +1,160 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenWord
+	tokenPhrase
+	tokenFilter
+	tokenLParen
+	tokenRParen
+	tokenAnd
+	tokenOr
+	tokenNot
+)
+
+func (k tokenKind) String() string {
+	switch k {
+	case tokenEOF:
+		return "end of query"
+	case tokenWord:
+		return "word"
+	case tokenPhrase:
+		return "phrase"
+	case tokenFilter:
+		return "filter"
+	case tokenLParen:
+		return "\"(\""
+	case tokenRParen:
+		return "\")\""
+	case tokenAnd:
+		return "AND"
+	case tokenOr:
+		return "OR"
+	case tokenNot:
+		return "NOT"
+	}
+	return "unknown"
+}
+
+type token struct {
+	kind  tokenKind
+	pos   int // позиция в символах, начиная с 1
+	text  string
+	key   string // имя фильтра для tokenFilter
+	value string // значение фильтра для tokenFilter
+}
+
+// SyntaxError ошибка разбора запроса с позицией в символах, начиная с 1
+type SyntaxError struct {
+	Pos int
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("position %d: %s", e.Pos, e.Msg)
+}
+
+func errorf(pos int, format string, args ...any) *SyntaxError {
+	return &SyntaxError{Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// lex разбивает запрос на лексемы. Операторы AND, OR, NOT распознаются только
+// заглавными буквами, минус перед словом означает NOT
+func lex(s string) ([]token, error) {
+	runes := []rune(s)
+	var tokens []token
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		pos := i + 1
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{kind: tokenLParen, pos: pos, text: "("})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{kind: tokenRParen, pos: pos, text: ")"})
+			i++
+		case r == '-' && i+1 < len(runes) && !unicode.IsSpace(runes[i+1]) && runes[i+1] != ')':
+			tokens = append(tokens, token{kind: tokenNot, pos: pos, text: "-"})
+			i++
+		case r == '"':
+			text, next, err := lexQuoted(runes, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: tokenPhrase, pos: pos, text: text})
+			i = next
+		default:
+			tok, next, err := lexWord(runes, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, tok)
+			i = next
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(runes) + 1}), nil
+}
+
+// lexQuoted читает строку в кавычках начиная с runes[start] == '"'
+func lexQuoted(runes []rune, start int) (string, int, error) {
+	for i := start + 1; i < len(runes); i++ {
+		if runes[i] == '"' {
+			return string(runes[start+1 : i]), i + 1, nil
+		}
+	}
+	return "", 0, errorf(start+1, "unclosed quote")
+}
+
+func lexWord(runes []rune, start int) (token, int, error) {
+	i := start
+	for i < len(runes) && !unicode.IsSpace(runes[i]) && runes[i] != '(' && runes[i] != ')' && runes[i] != '"' {
+		i++
+	}
+	text := string(runes[start:i])
+	tok := token{kind: tokenWord, pos: start + 1, text: text}
+
+	switch text {
+	case "AND":
+		tok.kind = tokenAnd
+		return tok, i, nil
+	case "OR":
+		tok.kind = tokenOr
+		return tok, i, nil
+	case "NOT":
+		tok.kind = tokenNot
+		return tok, i, nil
+	}
+
+	key, value, ok := strings.Cut(text, ":")
+	if !ok || !isFilter(strings.ToLower(key)) {
+		// двоеточие внутри обычного слова, например в ссылке
+		return tok, i, nil
+	}
+	tok.kind = tokenFilter
+	tok.key = strings.ToLower(key)
+	tok.value = value
+
+	// значение фильтра в кавычках: from:"Иван Петров"
+	if value == "" && i < len(runes) && runes[i] == '"' {
+		quoted, next, err := lexQuoted(runes, i)
+		if err != nil {
+			return token{}, 0, err
+		}
+		tok.value = quoted
+		i = next
+	}
+	if tok.value == "" {
+		return token{}, 0, errorf(start+1, "empty value for %s:", tok.key)
+	}
+	return tok, i, nil
+}
@@ -0,0 +1,229 @@
+package query
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+
+	"tg-archive-bot/internal/archive"
+)
+
+// ErrEmpty в запросе нет ни слов, ни фильтров
+var ErrEmpty = errors.New("empty query")
+
+// Query разобранный запрос
+//
+//	deploy from:@alice (has:link OR has:file) -is:forwarded before:2024-06-01
+type Query struct {
+	source string
+	root   node
+}
+
+// node узел дерева запроса
+type node interface {
+	match(m *archive.Message, ev *evaluator) bool
+}
+
+type andNode struct {
+	items []node
+}
+
+type orNode struct {
+	items []node
+}
+
+type notNode struct {
+	item node
+}
+
+// textNode слово, префикс или фраза для полнотекстового поиска
+type textNode struct {
+	pos   int
+	query string
+}
+
+// Parse разбирает запрос. Ошибки синтаксиса возвращаются как *SyntaxError
+func Parse(s string) (*Query, error) {
+	tokens, err := lex(s)
+	if err != nil {
+		return nil, err
+	}
+	if tokens[0].kind == tokenEOF {
+		return nil, ErrEmpty
+	}
+
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, errorf(tok.pos, "unexpected %s", tok.kind)
+	}
+	return &Query{source: s, root: root}, nil
+}
+
+// String исходный текст запроса
+func (q *Query) String() string {
+	return q.source
+}
+
+// canonical запрос в нормальной форме: операторы явно, вложенные AND и OR в скобках.
+// Разбор нормальной формы дает то же дерево
+func (q *Query) canonical() string {
+	var b strings.Builder
+	writeNode(&b, q.root)
+	return b.String()
+}
+
+func writeNode(b *strings.Builder, n node) {
+	switch n := n.(type) {
+	case *andNode:
+		writeItems(b, n.items, " AND ")
+	case *orNode:
+		writeItems(b, n.items, " OR ")
+	case *notNode:
+		b.WriteString("NOT ")
+		writeOperand(b, n.item)
+	case *textNode:
+		b.WriteString(n.query)
+	case *filterNode:
+		b.WriteString(n.key)
+		b.WriteByte(':')
+		if strings.ContainsFunc(n.raw, func(r rune) bool { return unicode.IsSpace(r) || r == '(' || r == ')' }) {
+			b.WriteString(`"` + n.raw + `"`)
+		} else {
+			b.WriteString(n.raw)
+		}
+	}
+}
+
+func writeItems(b *strings.Builder, items []node, op string) {
+	for i, item := range items {
+		if i > 0 {
+			b.WriteString(op)
+		}
+		writeOperand(b, item)
+	}
+}
+
+// writeOperand заключает в скобки вложенные AND и OR
+func writeOperand(b *strings.Builder, n node) {
+	switch n.(type) {
+	case *andNode, *orNode:
+		b.WriteByte('(')
+		writeNode(b, n)
+		b.WriteByte(')')
+	default:
+		writeNode(b, n)
+	}
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// parseOr: and (OR and)*
+func (p *parser) parseOr() (node, error) {
+	first, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	items := []node{first}
+	for p.peek().kind == tokenOr {
+		p.next()
+		item, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	if len(items) == 1 {
+		return first, nil
+	}
+	return &orNode{items: items}, nil
+}
+
+// parseAnd: unary ([AND] unary)*, соседние условия без оператора объединяются через AND
+func (p *parser) parseAnd() (node, error) {
+	first, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	items := []node{first}
+	for {
+		switch p.peek().kind {
+		case tokenAnd:
+			p.next()
+		case tokenWord, tokenPhrase, tokenFilter, tokenLParen, tokenNot:
+		default:
+			if len(items) == 1 {
+				return first, nil
+			}
+			return &andNode{items: items}, nil
+		}
+		item, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+}
+
+// parseUnary: (NOT | -) unary | primary
+func (p *parser) parseUnary() (node, error) {
+	if p.peek().kind != tokenNot {
+		return p.parsePrimary()
+	}
+	p.next()
+	item, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	return &notNode{item: item}, nil
+}
+
+// parsePrimary: ( or ) | слово | "фраза" | фильтр
+func (p *parser) parsePrimary() (node, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokenWord:
+		return &textNode{pos: tok.pos, query: tok.text}, nil
+	case tokenPhrase:
+		if strings.TrimSpace(tok.text) == "" {
+			return nil, errorf(tok.pos, "empty phrase")
+		}
+		return &textNode{pos: tok.pos, query: `"` + tok.text + `"`}, nil
+	case tokenFilter:
+		return compileFilter(tok)
+	case tokenLParen:
+		if p.peek().kind == tokenRParen {
+			return nil, errorf(tok.pos, "empty parentheses")
+		}
+		item, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek().kind != tokenRParen {
+			return nil, errorf(tok.pos, "unclosed parenthesis")
+		}
+		p.next()
+		return item, nil
+	case tokenEOF:
+		return nil, errorf(tok.pos, "unexpected end of query, expected a term")
+	}
+	return nil, errorf(tok.pos, "unexpected %s, expected a term", tok.kind)
+}
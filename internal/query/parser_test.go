@@ -0,0 +1,128 @@
+package query
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"deploy", "deploy"},
+		{"deploy release", "deploy AND release"},
+		{"deploy AND release", "deploy AND release"},
+		{"deploy OR release", "deploy OR release"},
+		{"a b OR c", "(a AND b) OR c"},
+		{"a (b OR c)", "a AND (b OR c)"},
+		{"a OR b c", "a OR (b AND c)"},
+		{"NOT spam", "NOT spam"},
+		{"-spam", "NOT spam"},
+		{"- spam", "- AND spam"},
+		{"NOT (a OR b)", "NOT (a OR b)"},
+		{"NOT -a", "NOT NOT a"},
+		{"and or not", "and AND or AND not"},
+		{"serv*", "serv*"},
+		{`"новый релиз"`, `"новый релиз"`},
+		{`deploy "новый релиз" -is:forwarded`, `deploy AND "новый релиз" AND NOT is:forwarded`},
+		{"from:@alice", "from:@alice"},
+		{"FROM:@Alice", "from:@Alice"},
+		{`from:"Иван Петров"`, `from:"Иван Петров"`},
+		{`from:"alice"`, "from:alice"},
+		{"https://example.com", "https://example.com"},
+		{"deploy from:@alice (has:link OR has:file) -is:forwarded before:2024-06-01",
+			"deploy AND from:@alice AND (has:link OR has:file) AND NOT is:forwarded AND before:2024-06-01"},
+		{"((a))", "a"},
+	}
+	for _, tt := range tests {
+		q, err := Parse(tt.query)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.query, err)
+			continue
+		}
+		if got := q.canonical(); got != tt.want {
+			t.Errorf("Parse(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+		if got := q.String(); got != tt.query {
+			t.Errorf("Parse(%q).String() = %q", tt.query, got)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		query string
+		pos   int
+	}{
+		{`"unclosed`, 1},
+		{`deploy "unclosed`, 8},
+		{"(deploy", 1},
+		{"deploy)", 7},
+		{"()", 1},
+		{`""`, 1},
+		{`"  "`, 1},
+		{"a AND", 6},
+		{"OR a", 1},
+		{"a OR OR b", 6},
+		{"NOT", 4},
+		{"from:", 1},
+		{`from:""`, 1},
+		{`from:"x`, 6},
+		{"topic:abc", 7},
+		{"topic:0", 7},
+		{"before:yesterday", 8},
+		{"has:gif", 5},
+		{"is:pinned", 4},
+		{"привет has:gif", 12},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.query)
+		var syntax *SyntaxError
+		if !errors.As(err, &syntax) {
+			t.Errorf("Parse(%q) error = %v, want *SyntaxError", tt.query, err)
+			continue
+		}
+		if syntax.Pos != tt.pos {
+			t.Errorf("Parse(%q) error at %d, want %d: %v", tt.query, syntax.Pos, tt.pos, err)
+		}
+	}
+
+	for _, query := range []string{"", "   ", "\t\n"} {
+		if _, err := Parse(query); !errors.Is(err, ErrEmpty) {
+			t.Errorf("Parse(%q) error = %v, want ErrEmpty", query, err)
+		}
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	for _, seed := range []string{
+		"deploy",
+		`deploy "новый релиз" serv*`,
+		"a b OR c",
+		"NOT (a OR -b) AND c",
+		`from:"Иван Петров" chat:@dev topic:12`,
+		"deploy from:@alice (has:link OR has:file) -is:forwarded before:2024-06-01",
+		"reaction:👍 mentions:123 after:2024-01-01",
+		"(-)",
+		"- - -",
+		`"`,
+		"((",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		q, err := Parse(s)
+		if err != nil {
+			return
+		}
+		printed := q.canonical()
+		again, err := Parse(printed)
+		if err != nil {
+			t.Fatalf("Parse(%q) printed as %q, which does not parse: %v", s, printed, err)
+		}
+		if got := again.canonical(); got != printed {
+			t.Fatalf("Parse(%q) printed as %q, parsed back as %q", s, printed, got)
+		}
+	})
+}
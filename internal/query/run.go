@@ -0,0 +1,225 @@
+package query
+
+import (
+	"errors"
+	"sort"
+
+	"tg-archive-bot/internal/archive"
+	"tg-archive-bot/internal/search"
+)
+
+// MaxResults ограничение на количество найденных сообщений
+const MaxResults = 1000
+
+// textResult сообщения, найденные индексом по одному текстовому условию.
+// all означает, что в условии нет слов (например, одни знаки препинания) и оно не ограничивает выборку
+type textResult struct {
+	scores map[search.DocKey]float64
+	all    bool
+}
+
+// evaluator кэширует данные, нужные фильтрам, на время выполнения запроса
+type evaluator struct {
+	store *archive.Store
+	text  map[*textNode]textResult
+	users map[int64]*archive.User
+	chats map[int64]*archive.Chat
+}
+
+func (ev *evaluator) user(id int64) *archive.User {
+	u, ok := ev.users[id]
+	if !ok {
+		u, _ = ev.store.GetUser(id)
+		ev.users[id] = u
+	}
+	return u
+}
+
+func (ev *evaluator) chat(id int64) *archive.Chat {
+	c, ok := ev.chats[id]
+	if !ok {
+		c, _ = ev.store.GetChat(id)
+		ev.chats[id] = c
+	}
+	return c
+}
+
+func (n *andNode) match(m *archive.Message, ev *evaluator) bool {
+	for _, item := range n.items {
+		if !item.match(m, ev) {
+			return false
+		}
+	}
+	return true
+}
+
+func (n *orNode) match(m *archive.Message, ev *evaluator) bool {
+	for _, item := range n.items {
+		if item.match(m, ev) {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *notNode) match(m *archive.Message, ev *evaluator) bool {
+	return !n.item.match(m, ev)
+}
+
+func (n *textNode) match(m *archive.Message, ev *evaluator) bool {
+	res := ev.text[n]
+	if res.all {
+		return true
+	}
+	_, ok := res.scores[search.DocKey{ChatID: m.ChatID, MessageID: m.ID}]
+	return ok
+}
+
+// score сумма релевантности текстовых условий, которым соответствует сообщение
+func (ev *evaluator) score(m *archive.Message) float64 {
+	key := search.DocKey{ChatID: m.ChatID, MessageID: m.ID}
+	var score float64
+	for _, res := range ev.text {
+		score += res.scores[key]
+	}
+	return score
+}
+
+// plan способ выборки сообщений, полученный из условий верхнего уровня (объединенных через AND):
+// фильтры chat: сужают список чатов, а текстовое условие заменяет обход чатов выборкой из индекса.
+// before: обход не прерывает: номера сообщений не упорядочены по дате у импортированных
+// сообщений, поэтому дата проверяется у каждого
+type plan struct {
+	chats      []int64
+	candidates *textNode
+}
+
+func (q *Query) plan(chats []int64, ev *evaluator) plan {
+	conj := []node{q.root}
+	if and, ok := q.root.(*andNode); ok {
+		conj = and.items
+	}
+
+	p := plan{chats: chats}
+	for _, item := range conj {
+		switch n := item.(type) {
+		case *filterNode:
+			if n.key == "chat" && n.isID {
+				var narrowed []int64
+				for _, id := range p.chats {
+					if id == n.id {
+						narrowed = append(narrowed, id)
+					}
+				}
+				p.chats = narrowed
+			}
+		case *textNode:
+			res := ev.text[n]
+			if res.all {
+				continue
+			}
+			if p.candidates == nil || len(res.scores) < len(ev.text[p.candidates].scores) {
+				p.candidates = n
+			}
+		}
+	}
+	return p
+}
+
+// collectText выполняет текстовые условия запроса через индекс
+func (q *Query) collectText(index *search.Index, n node, ev *evaluator) error {
+	switch n := n.(type) {
+	case *andNode:
+		for _, item := range n.items {
+			if err := q.collectText(index, item, ev); err != nil {
+				return err
+			}
+		}
+	case *orNode:
+		for _, item := range n.items {
+			if err := q.collectText(index, item, ev); err != nil {
+				return err
+			}
+		}
+	case *notNode:
+		return q.collectText(index, n.item, ev)
+	case *textNode:
+		scores, err := index.Match(n.query)
+		if errors.Is(err, search.ErrEmptyQuery) {
+			ev.text[n] = textResult{all: true}
+			return nil
+		}
+		if err != nil {
+			return errorf(n.pos, "%s", err)
+		}
+		ev.text[n] = textResult{scores: scores}
+	}
+	return nil
+}
+
+// Run выполняет запрос по сообщениям чатов chats. При наличии текстовых условий результаты
+// упорядочены по релевантности, иначе от новых к старым
+func Run(store *archive.Store, index *search.Index, q *Query, chats []int64) ([]*archive.Message, error) {
+	ev := &evaluator{
+		store: store,
+		text:  make(map[*textNode]textResult),
+		users: make(map[int64]*archive.User),
+		chats: make(map[int64]*archive.Chat),
+	}
+	if err := q.collectText(index, q.root, ev); err != nil {
+		return nil, err
+	}
+	p := q.plan(chats, ev)
+
+	var result []*archive.Message
+	if p.candidates != nil {
+		allowed := make(map[int64]bool, len(p.chats))
+		for _, id := range p.chats {
+			allowed[id] = true
+		}
+		for key := range ev.text[p.candidates].scores {
+			if !allowed[key.ChatID] {
+				continue
+			}
+			m, err := store.GetMessage(key.ChatID, key.MessageID)
+			if errors.Is(err, archive.ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			if q.root.match(m, ev) {
+				result = append(result, m)
+			}
+		}
+	} else {
+		for _, chatID := range p.chats {
+			err := store.Messages(chatID, func(m *archive.Message) error {
+				if q.root.match(m, ev) {
+					result = append(result, m)
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	scores := make(map[*archive.Message]float64, len(result))
+	if len(ev.text) > 0 {
+		for _, m := range result {
+			scores[m] = ev.score(m)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if scores[result[i]] != scores[result[j]] {
+			return scores[result[i]] > scores[result[j]]
+		}
+		return result[i].Date > result[j].Date
+	})
+	if len(result) > MaxResults {
+		result = result[:MaxResults]
+	}
+	return result, nil
+}
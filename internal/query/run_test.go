@@ -0,0 +1,87 @@
+package query
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"tg-archive-bot/internal/archive"
+	"tg-archive-bot/internal/search"
+)
+
+const testChat = -100
+
+// run выполняет запрос по архиву с авторами alice (Иван Петров) и bob (Петр Иванов)
+func run(t *testing.T, query string) []int {
+	t.Helper()
+	dir := t.TempDir()
+	store, err := archive.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	index, err := search.Open(filepath.Join(dir, "index"), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+
+	users := []*archive.User{
+		{ID: 1, FirstName: "Иван", LastName: "Петров", Username: "alice"},
+		{ID: 2, FirstName: "Петр", LastName: "Иванов", Username: "bob"},
+	}
+	for _, u := range users {
+		if err = store.PutUser(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for id, from := range []int64{1, 2, 1, 3} {
+		m := &archive.Message{ChatID: testChat, ID: id + 1, FromID: from, Date: int64(id + 1), Text: "message"}
+		if err = store.PutMessage(m); err != nil {
+			t.Fatal(err)
+		}
+		if err = index.Update(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	q, err := Parse(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found, err := Run(store, index, q, []int64{testChat})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]int, len(found))
+	for i, m := range found {
+		ids[i] = m.ID
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func TestRunFrom(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []int
+	}{
+		{"from:1", []int{1, 3}},
+		{"from:@alice", []int{1, 3}},
+		{"from:@ALICE", []int{1, 3}},
+		{"from:bob", []int{2}},
+		{`from:"Иван Петров"`, []int{1, 3}},
+		{`from:"иван петров"`, []int{1, 3}},
+		{"from:Иванов", []int{2}},
+		{"from:петр", []int{2}},
+		{`from:"Петров Иван"`, nil},
+		// @ означает только имя пользователя
+		{"from:@Иван", nil},
+		{"message -from:@alice", []int{2, 4}},
+	}
+	for _, tt := range tests {
+		if got := run(t, tt.query); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: messages %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,3 @@
+package retention
+
+// сроки хранения архива: периодическое удаление старых сообщений и вложений
@@ -0,0 +1,391 @@
+package retention
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"tg-archive-bot/internal/access"
+	"tg-archive-bot/internal/archive"
+	"tg-archive-bot/internal/config"
+	"tg-archive-bot/internal/metrics"
+	"tg-archive-bot/internal/search"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultInterval период запуска очистки
+	DefaultInterval = time.Hour
+	// DefaultBatchSize удалений за один запуск
+	DefaultBatchSize = 1000
+	// blobGrace файлы моложе этого не удаляются: загрузчик сначала пишет файл,
+	// а потом ссылку на него в сообщение
+	blobGrace = time.Hour
+	// startDelay первый запуск после старта бота
+	startDelay = time.Minute
+	stateName  = "retention"
+)
+
+var pruned = metrics.NewCounterVec("tgarchive_retention_deleted_total",
+	"Удаленное по срокам хранения: сообщения, вложения и файлы", "bot", "kind")
+
+// Report итог запуска очистки
+type Report struct {
+	Time     time.Time `json:"time"`
+	Action   string    `json:"action"`
+	Messages int       `json:"messages"`
+	Media    int       `json:"media"`
+	Blobs    int       `json:"blobs"`
+	Bytes    int64     `json:"bytes"`
+	Chats    int       `json:"chats"`
+	// Held чатов пропущено из-за legal_hold
+	Held int `json:"held,omitempty"`
+	// Complete все чаты обработаны, иначе следующий запуск продолжит с места остановки
+	Complete bool `json:"complete"`
+}
+
+// state место, с которого продолжить очистку, и итог последнего запуска
+type state struct {
+	// Next чат, с которого продолжить, nil — начать с первого
+	Next *int64  `json:"next,omitempty"`
+	Last *Report `json:"last,omitempty"`
+}
+
+// Pruner удаляет сообщения и вложения, срок хранения которых истек
+type Pruner struct {
+	name      string
+	store     *archive.Store
+	index     *search.Index
+	archiving *access.Archiving
+	log       *zap.Logger
+	cfg       atomic.Pointer[config.Retention]
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New создает очистку архива бота name. Срок, выбранный администраторами чата,
+// применяется, если он короче срока из конфигурации
+func New(name string, store *archive.Store, index *search.Index, archiving *access.Archiving, cfg config.Retention, logger *zap.Logger) *Pruner {
+	p := &Pruner{
+		name:      name,
+		store:     store,
+		index:     index,
+		archiving: archiving,
+		log:       logger,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	p.cfg.Store(&cfg)
+	return p
+}
+
+// SetConfig применяет новые политики со следующего запуска
+func (p *Pruner) SetConfig(cfg config.Retention) {
+	p.cfg.Store(&cfg)
+}
+
+// Held удаление из чата запрещено legal_hold
+func (p *Pruner) Held(chatID int64) bool {
+	return p.cfg.Load().Held(chatID)
+}
+
+// Policy правила хранения чата из текущей конфигурации, без срока, выбранного в чате
+func (p *Pruner) Policy(chatID int64) config.RetentionPolicy {
+	return p.cfg.Load().Policy(chatID)
+}
+
+// Run запускает очистку по расписанию до вызова Stop
+func (p *Pruner) Run() {
+	defer close(p.done)
+	delay := startDelay
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-time.After(delay):
+		}
+		report, err := p.Prune(time.Now())
+		if err != nil {
+			p.log.Error("retention failed", zap.Error(err))
+		}
+		if report != nil && (report.Messages > 0 || report.Media > 0 || report.Blobs > 0) {
+			p.log.Info("retention applied",
+				zap.Int("messages", report.Messages), zap.Int("media", report.Media),
+				zap.Int("blobs", report.Blobs), zap.Int64("bytes", report.Bytes),
+				zap.Int("held_chats", report.Held), zap.Bool("complete", report.Complete))
+		}
+		delay = DefaultInterval
+		if d, err := time.ParseDuration(p.cfg.Load().Interval); err == nil && d > 0 {
+			delay = d
+		}
+	}
+}
+
+// Stop останавливает расписание и дожидается текущего запуска
+func (p *Pruner) Stop() {
+	close(p.stop)
+	<-p.done
+}
+
+// Prune обрабатывает чаты, начиная с места прошлой остановки, пока не исчерпает
+// batch_size удалений. После полного прохода удаляет файлы, на которые не ссылается
+// ни одно сообщение, и пересохраняет поисковый индекс
+func (p *Pruner) Prune(now time.Time) (*Report, error) {
+	cfg := p.cfg.Load()
+	budget := cfg.BatchSize
+	if budget <= 0 {
+		budget = DefaultBatchSize
+	}
+
+	var st state
+	if err := p.store.GetState(stateName, &st); err != nil && !errors.Is(err, archive.ErrNotFound) {
+		return nil, fmt.Errorf("read retention state: %w", err)
+	}
+	chats, err := p.store.Chats()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{Time: now.UTC(), Action: "retention", Complete: true}
+	refs := &blobRefs{store: p.store}
+	var errs []error
+	for _, chatID := range chats {
+		if st.Next != nil && chatID < *st.Next {
+			continue
+		}
+		policy := p.policy(chatID)
+		if policy.LegalHold {
+			report.Held++
+			continue
+		}
+		used, err := p.pruneChat(chatID, policy, now, budget, refs, report)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("chat %d: %w", chatID, err))
+		}
+		report.Chats++
+		if budget -= used; budget <= 0 {
+			// чат мог остаться необработанным до конца, следующий запуск начнет с него
+			st.Next = &chatID
+			report.Complete = false
+			break
+		}
+	}
+
+	if report.Complete {
+		st.Next = nil
+		if err = p.collectBlobs(now, report); err != nil {
+			errs = append(errs, fmt.Errorf("collect blobs: %w", err))
+		}
+	}
+	if report.Messages > 0 {
+		// снимок без удаленных сообщений вместо растущего журнала
+		if err = p.index.Save(); err != nil {
+			errs = append(errs, fmt.Errorf("save search index: %w", err))
+		}
+	}
+	pruned.Add(float64(report.Messages), p.name, "message")
+	pruned.Add(float64(report.Media), p.name, "media")
+	pruned.Add(float64(report.Blobs), p.name, "blob")
+
+	st.Last = report
+	if err = p.store.PutState(stateName, &st); err != nil {
+		errs = append(errs, fmt.Errorf("save retention state: %w", err))
+	}
+	if report.Messages > 0 || report.Media > 0 || report.Blobs > 0 {
+		if err = p.store.AppendAudit(report); err != nil {
+			errs = append(errs, fmt.Errorf("write audit: %w", err))
+		}
+	}
+	return report, errors.Join(errs...)
+}
+
+// policy политика чата с учетом срока, выбранного его администраторами
+func (p *Pruner) policy(chatID int64) config.RetentionPolicy {
+	policy := p.cfg.Load().Policy(chatID)
+	if days := p.archiving.Get(chatID).RetentionDays; days > 0 && (policy.MaxAgeDays == 0 || days < policy.MaxAgeDays) {
+		policy.MaxAgeDays = days
+	}
+	return policy
+}
+
+// blobRefs ссылки на файлы по всему архиву. Считаются при первой проверке max_mb
+// за запуск и уменьшаются при удалении сообщений
+type blobRefs struct {
+	store *archive.Store
+	refs  map[string]int
+}
+
+func (r *blobRefs) get() (map[string]int, error) {
+	if r.refs == nil {
+		refs, err := r.store.BlobRefs()
+		if err != nil {
+			return nil, err
+		}
+		r.refs = refs
+	}
+	return r.refs, nil
+}
+
+// release убирает ссылки на удаленные из архива файлы
+func (r *blobRefs) release(blobs []string) {
+	if r.refs == nil {
+		return
+	}
+	for _, hash := range blobs {
+		r.refs[hash]--
+	}
+}
+
+// pruneChat применяет политику к одному чату, удаляя не больше budget записей.
+// Возвращает число удалений
+func (p *Pruner) pruneChat(chatID int64, policy config.RetentionPolicy, now time.Time, budget int, refs *blobRefs, report *Report) (int, error) {
+	if policy.MaxAgeDays == 0 && len(policy.MediaMaxAgeDays) == 0 && policy.MaxMB == 0 {
+		return 0, nil
+	}
+
+	type entry struct {
+		id    int
+		date  time.Time
+		blobs []string
+		// expired индексы вложений с истекшим сроком и их файлы
+		expired      []int
+		expiredBlobs []string
+	}
+	var entries []entry
+	// sizes размеры файлов чата, chatRefs ссылки на них из сообщений чата
+	sizes := make(map[string]int64)
+	chatRefs := make(map[string]int)
+	err := p.store.Messages(chatID, func(m *archive.Message) error {
+		e := entry{id: m.ID, date: time.Unix(m.Date, 0)}
+		for i, media := range m.Media {
+			if media.Blob == "" {
+				continue
+			}
+			e.blobs = append(e.blobs, media.Blob)
+			sizes[media.Blob] = media.Size
+			chatRefs[media.Blob]++
+			if ttl := mediaTTL(policy, media.Kind); ttl > 0 && now.Sub(e.date) > ttl {
+				e.expired = append(e.expired, i)
+				e.expiredBlobs = append(e.expiredBlobs, media.Blob)
+			}
+		}
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	// номера импортированных сообщений не всегда идут по времени, поэтому порядок по дате
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].date.Before(entries[j].date) })
+
+	// total занятое чатом место, avg средний размер записи сообщения. Файл считается
+	// один раз и только если на него не ссылаются другие чаты: удаление сообщений этого
+	// чата освобождает место, только когда исчезает последняя ссылка на файл
+	var total, avg int64
+	var owned map[string]bool
+	if policy.MaxMB > 0 {
+		_, jsonSize, err := p.store.ChatUsage(chatID)
+		if err != nil {
+			return 0, err
+		}
+		total = jsonSize
+		if len(entries) > 0 {
+			avg = jsonSize / int64(len(entries))
+		}
+		all, err := refs.get()
+		if err != nil {
+			return 0, err
+		}
+		owned = make(map[string]bool, len(chatRefs))
+		for hash, n := range chatRefs {
+			if all[hash] <= n {
+				owned[hash] = true
+				total += sizes[hash]
+			}
+		}
+	}
+	// unref убирает ссылки сообщения на файлы и возвращает освободившееся место
+	unref := func(blobs []string) int64 {
+		var freed int64
+		for _, hash := range blobs {
+			if chatRefs[hash]--; chatRefs[hash] == 0 && owned[hash] {
+				freed += sizes[hash]
+			}
+		}
+		refs.release(blobs)
+		return freed
+	}
+
+	used := 0
+	maxAge := time.Duration(policy.MaxAgeDays) * 24 * time.Hour
+	limit := policy.MaxMB << 20
+	// сообщения отсортированы от старых к новым, поэтому при превышении размера удаляются старые
+	for _, e := range entries {
+		if used >= budget {
+			break
+		}
+		tooOld := maxAge > 0 && now.Sub(e.date) > maxAge
+		tooBig := limit > 0 && total > limit
+		switch {
+		case tooOld || tooBig:
+			if err = p.index.Delete(chatID, e.id); err != nil {
+				return used, err
+			}
+			if err = p.store.DeleteMessage(chatID, e.id); err != nil && !errors.Is(err, archive.ErrNotFound) {
+				return used, err
+			}
+			total -= unref(e.blobs) + avg
+			report.Messages++
+			used++
+		case len(e.expired) > 0:
+			err = p.store.UpdateMessage(chatID, e.id, func(m *archive.Message) error {
+				for _, i := range e.expired {
+					if i < len(m.Media) {
+						m.Media[i].Blob = ""
+						m.Media[i].Pruned = true
+					}
+				}
+				return nil
+			})
+			if err != nil && !errors.Is(err, archive.ErrNotFound) {
+				return used, err
+			}
+			total -= unref(e.expiredBlobs)
+			report.Media += len(e.expired)
+			used++
+		}
+	}
+	return used, nil
+}
+
+// mediaTTL срок хранения вложений типа kind, 0 — бессрочно
+func mediaTTL(policy config.RetentionPolicy, kind archive.MediaKind) time.Duration {
+	days, ok := policy.MediaMaxAgeDays[string(kind)]
+	if !ok {
+		days = policy.MediaMaxAgeDays["*"]
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// collectBlobs удаляет файлы, на которые не ссылается ни одно сообщение
+func (p *Pruner) collectBlobs(now time.Time, report *Report) error {
+	refs, err := p.store.BlobRefs()
+	if err != nil {
+		return err
+	}
+	return p.store.Blobs(func(hash string, size int64, modTime time.Time) error {
+		if refs[hash] > 0 || now.Sub(modTime) < blobGrace {
+			return nil
+		}
+		if err := p.store.DeleteBlob(hash); err != nil && !errors.Is(err, archive.ErrNotFound) {
+			return err
+		}
+		report.Blobs++
+		report.Bytes += size
+		return nil
+	})
+}
@@ -0,0 +1,289 @@
+package retention
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"tg-archive-bot/internal/access"
+	"tg-archive-bot/internal/archive"
+	"tg-archive-bot/internal/config"
+	"tg-archive-bot/internal/search"
+
+	"go.uber.org/zap"
+)
+
+const day = 24 * time.Hour
+
+type testPruner struct {
+	*Pruner
+	t         *testing.T
+	store     *archive.Store
+	archiving *access.Archiving
+	now       time.Time
+}
+
+func newTestPruner(t *testing.T, cfg config.Retention) *testPruner {
+	t.Helper()
+	dir := t.TempDir()
+	store, err := archive.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	index, err := search.Open(filepath.Join(dir, "index"), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { index.Close() })
+	archiving, err := access.NewArchiving(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testPruner{
+		Pruner:    New("test", store, index, archiving, cfg, zap.NewNop()),
+		t:         t,
+		store:     store,
+		archiving: archiving,
+		now:       time.Now(),
+	}
+}
+
+// blob сохраняет файл и возвращает вложение размером size по записи сообщения
+func (p *testPruner) blob(kind archive.MediaKind, content string, size int64) archive.Media {
+	p.t.Helper()
+	hash, _, err := p.store.PutBlob(-1, bytes.NewReader([]byte(content)))
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	return archive.Media{Kind: kind, Blob: hash, Size: size}
+}
+
+// put сохраняет сообщение возрастом age
+func (p *testPruner) put(chatID int64, id int, age time.Duration, media ...archive.Media) {
+	p.t.Helper()
+	m := &archive.Message{ChatID: chatID, ID: id, Date: p.now.Add(-age).Unix(), Text: "message " + strconv.Itoa(id), Media: media}
+	if err := p.store.PutMessage(m); err != nil {
+		p.t.Fatal(err)
+	}
+}
+
+func (p *testPruner) prune(at time.Time) *Report {
+	p.t.Helper()
+	report, err := p.Prune(at)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	return report
+}
+
+// ids номера оставшихся сообщений чата
+func (p *testPruner) ids(chatID int64) []int {
+	p.t.Helper()
+	ids, err := p.store.MessageIDs(chatID)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	return ids
+}
+
+func (p *testPruner) expectIDs(chatID int64, want ...int) {
+	p.t.Helper()
+	got := p.ids(chatID)
+	if len(got) != len(want) {
+		p.t.Fatalf("chat %d: messages %v, want %v", chatID, got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			p.t.Fatalf("chat %d: messages %v, want %v", chatID, got, want)
+		}
+	}
+}
+
+func (p *testPruner) blobExists(hash string) bool {
+	p.t.Helper()
+	r, err := p.store.OpenBlob(hash)
+	if errors.Is(err, archive.ErrNotFound) {
+		return false
+	}
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	r.Close()
+	return true
+}
+
+func TestPruneMaxAge(t *testing.T) {
+	p := newTestPruner(t, config.Retention{Default: config.RetentionPolicy{MaxAgeDays: 5}})
+	p.put(-1, 1, 10*day)
+	p.put(-1, 2, day)
+	report := p.prune(p.now)
+	if report.Messages != 1 || !report.Complete {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	p.expectIDs(-1, 2)
+}
+
+func TestPruneMediaMaxAge(t *testing.T) {
+	p := newTestPruner(t, config.Retention{Default: config.RetentionPolicy{
+		MediaMaxAgeDays: map[string]int{"photo": 5, "video": 0, "*": 7},
+	}})
+	photo := p.blob(archive.MediaPhoto, "photo", 10)
+	video := p.blob(archive.MediaVideo, "video", 10)
+	oldDoc := p.blob(archive.MediaDocument, "old document", 10)
+	newDoc := p.blob(archive.MediaDocument, "new document", 10)
+	p.put(-1, 1, 10*day, photo)
+	p.put(-1, 2, 10*day, video)
+	p.put(-1, 3, 10*day, oldDoc)
+	p.put(-1, 4, 3*day, newDoc)
+
+	// файлы старше часа, чтобы их удалил сбор лишних файлов
+	report := p.prune(p.now.Add(2 * time.Hour))
+	if report.Media != 2 || report.Messages != 0 || report.Blobs != 2 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	p.expectIDs(-1, 1, 2, 3, 4)
+	for id, pruned := range map[int]bool{1: true, 2: false, 3: true, 4: false} {
+		m, err := p.store.GetMessage(-1, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Media[0].Pruned != pruned || (m.Media[0].Blob == "") != pruned {
+			t.Fatalf("message %d: media %+v, pruned want %v", id, m.Media[0], pruned)
+		}
+	}
+	if p.blobExists(photo.Blob) || p.blobExists(oldDoc.Blob) || !p.blobExists(video.Blob) || !p.blobExists(newDoc.Blob) {
+		t.Fatal("wrong blobs collected")
+	}
+}
+
+// Файл, общий с другим чатом, не входит в размер чата, а файл, на который чат ссылается
+// дважды, считается один раз
+func TestPruneMaxMBSharedBlob(t *testing.T) {
+	p := newTestPruner(t, config.Retention{Chats: map[int64]config.RetentionPolicy{-1: {MaxMB: 1}}})
+	shared := p.blob(archive.MediaVideo, "shared", 2<<20)
+	twice := p.blob(archive.MediaPhoto, "twice", 600<<10)
+	p.put(-1, 1, 3*day, shared)
+	p.put(-1, 2, 2*day, twice)
+	p.put(-1, 3, day, twice)
+	p.put(-2, 1, day, shared)
+
+	if report := p.prune(p.now); report.Messages != 0 {
+		t.Fatalf("deleted %d messages from a chat within max_mb", report.Messages)
+	}
+	p.expectIDs(-1, 1, 2, 3)
+
+	// собственный файл выводит чат за предел: удаляются все сообщения от старых к новым,
+	// общий файл остается у другого чата
+	p.put(-1, 4, 0, p.blob(archive.MediaVideo, "own", 2<<20))
+	report := p.prune(p.now.Add(2 * time.Hour))
+	if report.Messages != 4 || report.Blobs != 2 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	p.expectIDs(-1)
+	p.expectIDs(-2, 1)
+	if !p.blobExists(shared.Blob) {
+		t.Fatal("blob shared with another chat deleted")
+	}
+}
+
+// При превышении размера удаляются самые старые сообщения, а не с меньшими номерами
+func TestPruneMaxMBByDate(t *testing.T) {
+	p := newTestPruner(t, config.Retention{Default: config.RetentionPolicy{MaxMB: 1}})
+	p.put(-1, 1, day, p.blob(archive.MediaVideo, "newer", 800<<10))
+	p.put(-1, 2, 2*day, p.blob(archive.MediaVideo, "older", 800<<10))
+	if report := p.prune(p.now); report.Messages != 1 {
+		t.Fatalf("deleted %d messages, want 1", report.Messages)
+	}
+	p.expectIDs(-1, 1)
+}
+
+func TestPruneLegalHold(t *testing.T) {
+	p := newTestPruner(t, config.Retention{
+		Default: config.RetentionPolicy{MaxAgeDays: 1},
+		Chats:   map[int64]config.RetentionPolicy{-1: {MaxAgeDays: 1, LegalHold: true}},
+	})
+	p.put(-1, 1, 10*day)
+	p.put(-2, 1, 10*day)
+	report := p.prune(p.now)
+	if report.Held != 1 || report.Messages != 1 || report.Chats != 1 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	p.expectIDs(-1, 1)
+	p.expectIDs(-2)
+}
+
+// Срок, выбранный администраторами чата, короче срока из конфигурации
+func TestPruneChatRetentionDays(t *testing.T) {
+	p := newTestPruner(t, config.Retention{Default: config.RetentionPolicy{MaxAgeDays: 30}})
+	if _, err := p.archiving.Update(-1, func(c *access.ChatArchiving) { c.RetentionDays = 5 }); err != nil {
+		t.Fatal(err)
+	}
+	// более долгий срок чата не продлевает срок из конфигурации
+	if _, err := p.archiving.Update(-2, func(c *access.ChatArchiving) { c.RetentionDays = 60 }); err != nil {
+		t.Fatal(err)
+	}
+	p.put(-1, 1, 10*day)
+	p.put(-2, 1, 10*day)
+	p.put(-2, 2, 40*day)
+	p.prune(p.now)
+	p.expectIDs(-1)
+	p.expectIDs(-2, 1)
+}
+
+func TestPruneResumesAfterBatch(t *testing.T) {
+	p := newTestPruner(t, config.Retention{BatchSize: 2, Default: config.RetentionPolicy{MaxAgeDays: 1}})
+	for _, chatID := range []int64{-3, -2, -1} {
+		p.put(chatID, 1, 10*day)
+		p.put(chatID, 2, 10*day)
+	}
+
+	report := p.prune(p.now)
+	if report.Complete || report.Messages != 2 {
+		t.Fatalf("unexpected first report %+v", report)
+	}
+	var st state
+	if err := p.store.GetState(stateName, &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Next == nil || *st.Next != -3 {
+		t.Fatalf("next chat %v, want -3", st.Next)
+	}
+	p.expectIDs(-3)
+	p.expectIDs(-1, 1, 2)
+
+	total := report.Messages
+	for runs := 1; !report.Complete; runs++ {
+		if runs > 5 {
+			t.Fatal("pruning does not complete")
+		}
+		report = p.prune(p.now)
+		total += report.Messages
+	}
+	if total != 6 {
+		t.Fatalf("deleted %d messages, want 6", total)
+	}
+	for _, chatID := range []int64{-3, -2, -1} {
+		p.expectIDs(chatID)
+	}
+	var last state
+	if err := p.store.GetState(stateName, &last); err != nil || last.Next != nil {
+		t.Fatalf("next chat %v after a full pass, %v", last.Next, err)
+	}
+}
+
+// Только что записанный файл может ждать ссылки из сообщения
+func TestCollectBlobsGrace(t *testing.T) {
+	p := newTestPruner(t, config.Retention{})
+	orphan := p.blob(archive.MediaPhoto, "orphan", 6)
+	if report := p.prune(p.now); report.Blobs != 0 || !p.blobExists(orphan.Blob) {
+		t.Fatalf("fresh blob collected: %+v", report)
+	}
+	report := p.prune(p.now.Add(2 * time.Hour))
+	if report.Blobs != 1 || report.Bytes != 6 || p.blobExists(orphan.Blob) {
+		t.Fatalf("orphan blob is not collected: %+v", report)
+	}
+}
@@ -0,0 +1,59 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"tg-archive-bot/internal/config"
+	"tg-archive-bot/internal/tgapi"
+
+	"github.com/mymmrac/telego"
+	ta "github.com/mymmrac/telego/telegoapi"
+	"go.uber.org/zap"
+)
+
+// connectAttempts попытки вызова getMe при запуске
+const connectAttempts = 5
+
+// connect проверяет токен вызовом getMe. Неверный токен завершает запуск сразу,
+// сетевые ошибки и ошибки сервера повторяются с увеличивающейся паузой
+func connect(bot *telego.Bot, logger *zap.Logger) (*telego.User, error) {
+	delay := time.Second
+	for attempt := 1; ; attempt++ {
+		user, err := bot.GetMe()
+		if err == nil {
+			return user, nil
+		}
+
+		var apiErr *ta.Error
+		if errors.As(err, &apiErr) && (apiErr.ErrorCode == http.StatusUnauthorized || apiErr.ErrorCode == http.StatusNotFound) {
+			return nil, fmt.Errorf("invalid bot token: %w", err)
+		}
+		if attempt == connectAttempts {
+			return nil, fmt.Errorf("getMe failed after %d attempts: %w", attempt, err)
+		}
+		logger.Warn("getMe failed, retrying", zap.Int("attempt", attempt), zap.Duration("delay", delay), zap.Error(err))
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// rateLimits ограничения вызовов Bot API из конфигурации
+func rateLimits(cfg config.RateLimits) tgapi.Limits {
+	limits := tgapi.DefaultLimits
+	if cfg.GlobalRate > 0 {
+		limits.GlobalRate = cfg.GlobalRate
+	}
+	if cfg.PrivateRate > 0 {
+		limits.PrivateRate = cfg.PrivateRate
+	}
+	if cfg.GroupRate > 0 {
+		limits.GroupRate = cfg.GroupRate
+	}
+	if cfg.MaxAttempts > 0 {
+		limits.MaxAttempts = cfg.MaxAttempts
+	}
+	return limits
+}
@@ -0,0 +1,3 @@
+package runner
+
+// запуск ботов: получение обновлений, архивирование и команды для каждого бота из конфигурации
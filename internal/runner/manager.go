@@ -0,0 +1,161 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"tg-archive-bot/internal/config"
+
+	"go.uber.org/zap"
+)
+
+// ErrConflict обновления бота получает другой процесс
+var ErrConflict = errors.New("updates are consumed by another process")
+
+// Manager запускает ботов из конфигурации и применяет изменения списка ботов
+// без остановки остальных
+type Manager struct {
+	reloader *config.Reloader
+	probes   *Probes
+
+	mu      sync.Mutex
+	runners map[string]*Runner
+	stopped bool
+	failed  chan error
+}
+
+// NewManager создает менеджер, состояние ботов добавляется в probes
+func NewManager(reloader *config.Reloader, probes *Probes) *Manager {
+	return &Manager{
+		reloader: reloader,
+		probes:   probes,
+		runners:  make(map[string]*Runner),
+		failed:   make(chan error, 1),
+	}
+}
+
+// Start запускает всех ботов. Ошибка любого бота останавливает уже запущенных
+func (m *Manager) Start() error {
+	cfg := m.reloader.Current()
+	if len(cfg.Bots) == 0 {
+		return fmt.Errorf("no bots configured: add bots to the config or set %s", config.EnvToken)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, b := range cfg.Bots {
+		r, err := Start(cfg, b, m.probes, m.conflict)
+		if err != nil {
+			m.stopAll()
+			return fmt.Errorf("bot %s: %w", b.Name, err)
+		}
+		m.runners[b.Name] = r
+	}
+	return nil
+}
+
+// Apply приводит запущенных ботов к новой конфигурации: удаленные боты останавливаются,
+// новые запускаются, измененные перезапускаются. Остальные продолжают работать,
+// получая новые ограничения частоты и список разрешенных чатов. Боты запускаются
+// без блокировки менеджера, возвращаются ошибки запуска
+func (m *Manager) Apply(cfg *config.Config) error {
+	m.mu.Lock()
+	var stopping []*Runner
+	for name, r := range m.runners {
+		b, ok := cfg.FindBot(name)
+		if ok && b == r.Config() {
+			r.Reconfigure(cfg)
+			continue
+		}
+		stopping = append(stopping, r)
+		delete(m.runners, name)
+	}
+	var starting []config.Bot
+	for _, b := range cfg.Bots {
+		if _, ok := m.runners[b.Name]; !ok {
+			starting = append(starting, b)
+		}
+	}
+	m.mu.Unlock()
+
+	// перезапускаемый бот должен освободить каталог архива до запуска
+	var wg sync.WaitGroup
+	for _, r := range stopping {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.Stop()
+		}()
+	}
+	wg.Wait()
+
+	started := make([]*Runner, len(starting))
+	errs := make([]error, len(starting))
+	for i, b := range starting {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r, err := Start(cfg, b, m.probes, m.conflict)
+			if err != nil {
+				zap.L().Error("start bot", zap.String("bot", b.Name), zap.Error(err))
+				errs[i] = fmt.Errorf("bot %s: %w", b.Name, err)
+				return
+			}
+			started[i] = r
+		}()
+	}
+	wg.Wait()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, r := range started {
+		if r == nil {
+			continue
+		}
+		// менеджер остановлен, пока боты запускались
+		if m.stopped {
+			r.Stop()
+			continue
+		}
+		m.runners[r.Config().Name] = r
+	}
+	return errors.Join(errs...)
+}
+
+// Failed получает ошибку, после которой процесс должен завершиться
+func (m *Manager) Failed() <-chan error {
+	return m.failed
+}
+
+// Stop останавливает всех ботов
+func (m *Manager) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stopped = true
+	m.stopAll()
+}
+
+func (m *Manager) stopAll() {
+	var wg sync.WaitGroup
+	for name, r := range m.runners {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.Stop()
+		}()
+		delete(m.runners, name)
+	}
+	wg.Wait()
+}
+
+// conflict завершает процесс, если это включено в конфигурации
+func (m *Manager) conflict(name string) {
+	if !m.reloader.Current().Polling.ExitOnConflict {
+		return
+	}
+	select {
+	case m.failed <- fmt.Errorf("bot %s: %w", name, ErrConflict):
+	default:
+	}
+}
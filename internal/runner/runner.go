@@ -0,0 +1,358 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"time"
+
+	"tg-archive-bot/internal/access"
+	"tg-archive-bot/internal/archive"
+	"tg-archive-bot/internal/archiver"
+	"tg-archive-bot/internal/config"
+	"tg-archive-bot/internal/handler"
+	"tg-archive-bot/internal/health"
+	"tg-archive-bot/internal/integrity"
+	"tg-archive-bot/internal/log"
+	"tg-archive-bot/internal/metrics"
+	"tg-archive-bot/internal/retention"
+	"tg-archive-bot/internal/search"
+	"tg-archive-bot/internal/tgapi"
+
+	"github.com/mymmrac/telego"
+	ta "github.com/mymmrac/telego/telegoapi"
+	"go.uber.org/zap"
+)
+
+var (
+	updatesReceived = metrics.NewCounterVec("tgarchive_updates_received_total",
+		"Полученные обновления по типу обновления и типу чата", "bot", "kind", "chat_type")
+	updateDuration = metrics.NewHistogramVec("tgarchive_update_duration_seconds",
+		"Длительность обработки обновления", metrics.DefBuckets, "bot", "kind")
+)
+
+// allowedUpdates реакции приходят только если явно перечислены в allowed_updates
+var allowedUpdates = []string{
+	"message", "edited_message", "channel_post", "edited_channel_post",
+	"business_message", "edited_business_message", "deleted_business_messages",
+	"message_reaction", "message_reaction_count",
+	"inline_query", "chosen_inline_result", "callback_query",
+	"my_chat_member",
+}
+
+// Runner получает и обрабатывает обновления одного бота. У каждого бота свой
+// каталог архива, индекс, очередь скачивания и сохраненное смещение обновлений
+type Runner struct {
+	cfg    config.Bot
+	log    *zap.Logger
+	probes *Probes
+
+	store      *archive.Store
+	index      *search.Index
+	bot        *telego.Bot
+	caller     *tgapi.ResilientCaller
+	downloader *archiver.Downloader
+	archiver   *archiver.Archiver
+	commands   *handler.Handler
+	access     *access.Guard
+	archiving  *access.Archiving
+	pruner     *retention.Pruner
+	signer     *integrity.Checkpointer
+
+	loop     *health.Loop
+	polling  *health.Polling
+	pinger   *health.BotPinger
+	stopPing context.CancelFunc
+	done     chan struct{}
+}
+
+// Probes проверки, в которые runner добавляет состояние своего бота
+type Probes struct {
+	Liveness  *health.Probe
+	Readiness *health.Probe
+}
+
+// Start открывает архив бота, проверяет токен и начинает получать обновления.
+// onConflict вызывается, когда обновления бота забирает другой процесс
+func Start(cfg *config.Config, b config.Bot, probes *Probes, onConflict func(name string)) (_ *Runner, err error) {
+	r := &Runner{
+		cfg:    b,
+		log:    zap.L().With(zap.String("bot", b.Name)),
+		probes: probes,
+		done:   make(chan struct{}),
+	}
+	defer func() {
+		if err != nil {
+			r.close()
+		}
+	}()
+
+	dataDir := cfg.BotDataDir(b)
+	enc, err := cfg.Encryption.Archive()
+	if err != nil {
+		return nil, err
+	}
+	if r.store, err = archive.Open(dataDir); err != nil {
+		return nil, fmt.Errorf("open archive: %w", err)
+	}
+	r.store.SetName(b.Name)
+	if err = r.store.SetEncryption(enc); err != nil {
+		return nil, fmt.Errorf("open archive: %w", err)
+	}
+	if r.index, err = search.Open(filepath.Join(dataDir, "index"), r.store); err != nil {
+		return nil, fmt.Errorf("open search index: %w", err)
+	}
+
+	// повторы снаружи, чтобы метрики учитывали каждую попытку
+	apiLog := log.Module(r.log, "telego")
+	// getUpdates отвечает не реже раза в несколько секунд, даже когда обновлений нет
+	r.polling = health.NewPolling(5 * time.Minute)
+	metered := &tgapi.MetricsCaller{Caller: tgapi.Transport, Bot: b.Name, Polled: r.polling.OK}
+	r.caller = tgapi.NewResilientCaller(
+		tgapi.NewConflictCaller(metered, b.Name, apiLog,
+			func(*ta.Error) { onConflict(b.Name) }),
+		b.Name, rateLimits(cfg.RateLimits), apiLog)
+	secrets := []string{b.Token, b.Webhook.Secret}
+	r.bot, err = telego.NewBot(b.Token,
+		telego.WithLogger(log.NewTelegoLogger(r.log, secrets...)),
+		telego.WithAPICaller(r.caller),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("create bot: %w", err)
+	}
+
+	user, err := connect(r.bot, r.log)
+	if err != nil {
+		return nil, err
+	}
+	r.log = r.log.With(zap.String("bot_username", user.Username))
+	r.log.Info("bot connected", zap.Int64("bot_id", user.ID))
+
+	r.downloader = archiver.NewDownloader(b.Name, r.bot, r.store, 2, 100)
+	if r.archiving, err = access.NewArchiving(r.store); err != nil {
+		return nil, err
+	}
+	if r.access, err = access.New(r.bot, r.store, r.archiving, cfg.Access, r.log); err != nil {
+		return nil, err
+	}
+	if cfg.Integrity.KeyFile != "" {
+		key, err := integrity.LoadPrivateKey(cfg.Integrity.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load integrity key: %w", err)
+		}
+		interval, _ := time.ParseDuration(cfg.Integrity.CheckpointInterval)
+		r.signer = integrity.NewCheckpointer(r.store, key, interval, r.log)
+		go r.signer.Run()
+	}
+	r.pruner = retention.New(b.Name, r.store, r.index, r.archiving, cfg.Retention, r.log)
+	go r.pruner.Run()
+	r.archiver = archiver.New(r.store, r.index, r.downloader, r.archiving)
+	r.commands = handler.New(b.Name, r.bot, user.Username, r.store, r.index, r.archiving, r.pruner.Policy)
+	r.access.OnApprove(r.commands.Onboard)
+	if err := r.commands.PublishCommands(); err != nil {
+		// без меню команды работают, поэтому запуск не прерываем
+		r.log.Warn("publish bot commands", zap.Error(err))
+	}
+
+	updates, err := r.updates()
+	if err != nil {
+		return nil, err
+	}
+
+	r.loop = health.NewLoop(2 * time.Minute)
+	r.pinger = health.NewBotPinger(r.bot, time.Minute, 3*time.Minute)
+	var pingCtx context.Context
+	pingCtx, r.stopPing = context.WithCancel(context.Background())
+	go r.pinger.Run(pingCtx)
+
+	prefix := b.Name + "/"
+	probes.Liveness.Add(prefix+"update_loop", r.loop.Check)
+	if b.Mode != config.ModeWebhook {
+		probes.Liveness.Add(prefix+"polling", r.polling.Check)
+	}
+	probes.Readiness.Add(prefix+"bot_api", r.pinger.Check)
+	probes.Readiness.Add(prefix+"storage", health.Writable(dataDir))
+	probes.Readiness.Add(prefix+"disk_space", health.DiskSpace(dataDir, uint64(cfg.Health.MinFreeMB)<<20))
+	probes.Readiness.Add(prefix+"download_queue", health.Queue(r.downloader.Len, r.downloader.Cap, 0.9))
+
+	go r.run(updates)
+	return r, nil
+}
+
+// updates начинает получать обновления в режиме из конфигурации бота
+func (r *Runner) updates() (<-chan telego.Update, error) {
+	if r.cfg.Mode == config.ModeWebhook {
+		return r.webhook()
+	}
+
+	// getUpdates не работает, пока у бота установлен webhook
+	if err := r.bot.DeleteWebhook(&telego.DeleteWebhookParams{}); err != nil {
+		return nil, fmt.Errorf("delete webhook: %w", err)
+	}
+	offset, err := r.store.Checkpoint()
+	if err != nil {
+		return nil, fmt.Errorf("read checkpoint: %w", err)
+	}
+	params := &telego.GetUpdatesParams{AllowedUpdates: allowedUpdates}
+	if offset > 0 {
+		params.Offset = offset + 1
+		r.log.Info("resuming updates", zap.Int("offset", params.Offset))
+	}
+	return r.bot.UpdatesViaLongPolling(params)
+}
+
+func (r *Runner) webhook() (<-chan telego.Update, error) {
+	hook := r.cfg.Webhook
+	u, err := url.Parse(hook.URL)
+	if err != nil {
+		return nil, fmt.Errorf("webhook url: %w", err)
+	}
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+
+	server := telego.HTTPWebhookServer{
+		Logger:      log.NewTelegoLogger(r.log, r.cfg.Token, hook.Secret),
+		Server:      &http.Server{ReadHeaderTimeout: 10 * time.Second},
+		ServeMux:    http.NewServeMux(),
+		SecretToken: hook.Secret,
+	}
+	updates, err := r.bot.UpdatesViaWebhook(path,
+		telego.WithWebhookServer(server),
+		telego.WithWebhookSet(&telego.SetWebhookParams{
+			URL:            hook.URL,
+			SecretToken:    hook.Secret,
+			AllowedUpdates: allowedUpdates,
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("set webhook: %w", err)
+	}
+	go func() {
+		if err := r.bot.StartWebhook(hook.Listen); err != nil {
+			r.log.Error("webhook server stopped", zap.Error(err))
+		}
+	}()
+	return updates, nil
+}
+
+// run обрабатывает обновления, пока канал не закроется
+func (r *Runner) run(updates <-chan telego.Update) {
+	defer close(r.done)
+	defer r.close()
+
+	base := log.WithLogger(context.Background(), r.log)
+	for update := range updates {
+		r.loop.Begin()
+		started := time.Now()
+		kind, chatType := log.UpdateKind(update)
+		updatesReceived.Inc(r.cfg.Name, kind, chatType)
+
+		ctx, logger := log.ForUpdate(base, update)
+		if payload, ok := log.Payload(update); ok {
+			logger.Debug("update received", payload)
+		} else {
+			logger.Debug("update received")
+		}
+		r.handle(ctx, update)
+		if r.cfg.Mode != config.ModeWebhook {
+			if err := r.store.SaveCheckpoint(update.UpdateID); err != nil {
+				logger.Error("save checkpoint", zap.Error(err))
+			}
+		}
+		updateDuration.Since(started, r.cfg.Name, kind)
+		r.loop.End()
+	}
+}
+
+// handle передает обновление архиватору и командам. Обновления из неразрешенных чатов
+// не сохраняются, команды в них не выполняются. Обновления чатов, где администраторы
+// выключили или приостановили архивирование, отбрасываются до сохранения и скачивания вложений
+func (r *Runner) handle(ctx context.Context, update telego.Update) {
+	if r.access.HandleUpdate(ctx, update) {
+		return
+	}
+	chat := log.UpdateChat(update)
+	switch {
+	case chat == nil:
+		r.archiver.HandleUpdate(ctx, update)
+	case !r.access.Allowed(chat.ID):
+		log.FromContext(ctx).Debug("update from unauthorized chat skipped")
+	case !r.archiving.Active(chat.ID):
+		log.FromContext(ctx).Debug("update skipped: archiving is off or paused")
+	default:
+		r.archiver.HandleUpdate(ctx, update)
+	}
+	// в личных чатах команды работают и без разрешения, чтобы владелец мог управлять ботом
+	if chat == nil || chat.Type == telego.ChatTypePrivate || r.access.Allowed(chat.ID) {
+		r.commands.HandleUpdate(ctx, update)
+	}
+}
+
+// Stop прекращает получение обновлений и дожидается обработки уже полученных
+func (r *Runner) Stop() {
+	if r.cfg.Mode == config.ModeWebhook {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := r.bot.StopWebhookWithContext(ctx); err != nil {
+			r.log.Error("stop webhook", zap.Error(err))
+		}
+	} else {
+		r.bot.StopLongPolling()
+	}
+	<-r.done
+	r.log.Info("bot stopped")
+}
+
+// Reconfigure применяет настройки, которые меняются без перезапуска бота:
+// ограничения частоты вызовов, список разрешенных чатов и сроки хранения
+func (r *Runner) Reconfigure(cfg *config.Config) {
+	r.caller.SetLimits(rateLimits(cfg.RateLimits))
+	r.access.SetConfig(cfg.Access)
+	r.pruner.SetConfig(cfg.Retention)
+}
+
+// Config конфигурация бота, с которой он запущен
+func (r *Runner) Config() config.Bot {
+	return r.cfg
+}
+
+// close освобождает ресурсы бота в обратном порядке
+func (r *Runner) close() {
+	if r.stopPing != nil {
+		r.stopPing()
+	}
+	if r.probes != nil && r.loop != nil {
+		prefix := r.cfg.Name + "/"
+		r.probes.Liveness.Remove(prefix + "update_loop")
+		r.probes.Liveness.Remove(prefix + "polling")
+		for _, name := range []string{"bot_api", "storage", "disk_space", "download_queue"} {
+			r.probes.Readiness.Remove(prefix + name)
+		}
+	}
+	if r.access != nil {
+		r.access.Stop()
+	}
+	if r.pruner != nil {
+		r.pruner.Stop()
+	}
+	if r.downloader != nil {
+		r.downloader.Stop()
+	}
+	if r.signer != nil {
+		r.signer.Stop()
+	}
+	if r.index != nil {
+		if err := r.index.Close(); err != nil {
+			r.log.Error("close search index", zap.Error(err))
+		}
+	}
+	if r.store != nil {
+		if err := r.store.Close(); err != nil {
+			r.log.Error("close archive", zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,3 @@
+package search
+
+// полнотекстовый индекс по архиву со стеммингом для русского и английского
@@ -0,0 +1,245 @@
+package search
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"tg-archive-bot/internal/archive"
+	"tg-archive-bot/internal/log"
+
+	"go.uber.org/zap"
+)
+
+// DocKey идентификатор сообщения в индексе
+type DocKey struct {
+	ChatID    int64
+	MessageID int
+}
+
+// Index инвертированный индекс с позициями слов.
+// Индекс хранится в памяти, на диск пишется снимок и журнал изменений после него
+type Index struct {
+	dir   string
+	store *archive.Store
+	log   *zap.Logger
+
+	mu       sync.RWMutex
+	docs     map[DocKey][]string
+	postings map[string]map[DocKey][]int
+	totalLen int
+	// words и forms слова сообщений без стемминга для поиска по префиксу:
+	// основа префикса может не совпадать с основой слова
+	words map[DocKey][]string
+	forms map[string]map[DocKey]int
+
+	journal *os.File
+}
+
+// Open загружает индекс из dir. Если снимка нет, индекс строится заново по хранилищу
+func Open(dir string, store *archive.Store) (*Index, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("create index dir: %w", err)
+	}
+	ix := &Index{
+		dir:   dir,
+		store: store,
+		log:   log.Named("search"),
+	}
+	ix.reset()
+
+	err := ix.load()
+	if errors.Is(err, os.ErrNotExist) {
+		ix.log.Info("search index not found, rebuilding")
+		if err = ix.Rebuild(); err != nil {
+			return nil, err
+		}
+		return ix, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ix, nil
+}
+
+func (ix *Index) reset() {
+	ix.docs = make(map[DocKey][]string)
+	ix.postings = make(map[string]map[DocKey][]int)
+	ix.totalLen = 0
+	ix.words = make(map[DocKey][]string)
+	ix.forms = make(map[string]map[DocKey]int)
+}
+
+// Update добавляет сообщение в индекс или заменяет его прежнюю версию
+func (ix *Index) Update(m *archive.Message) error {
+	key := DocKey{ChatID: m.ChatID, MessageID: m.ID}
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	ix.remove(key)
+	ix.addMessage(key, m)
+	return ix.writeJournal('u', key)
+}
+
+// Delete удаляет сообщение из индекса
+func (ix *Index) Delete(chatID int64, messageID int) error {
+	key := DocKey{ChatID: chatID, MessageID: messageID}
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	ix.remove(key)
+	return ix.writeJournal('d', key)
+}
+
+// Rebuild заново строит индекс по всем сообщениям хранилища и сохраняет снимок
+func (ix *Index) Rebuild() error {
+	chats, err := ix.store.Chats()
+	if err != nil {
+		return err
+	}
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	ix.reset()
+	for _, chatID := range chats {
+		err = ix.store.Messages(chatID, func(m *archive.Message) error {
+			ix.addMessage(DocKey{ChatID: m.ChatID, MessageID: m.ID}, m)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	ix.log.Info("search index rebuilt", zap.Int("docs", len(ix.docs)))
+	return ix.save()
+}
+
+// Keys проиндексированные сообщения
+func (ix *Index) Keys() []DocKey {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	keys := make([]DocKey, 0, len(ix.docs))
+	for key := range ix.docs {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Has сообщение есть в индексе
+func (ix *Index) Has(key DocKey) bool {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	_, ok := ix.docs[key]
+	return ok
+}
+
+// Indexable в сообщении есть слова для индекса, иначе Update его не добавляет
+func Indexable(m *archive.Message) bool {
+	return len(messageWords(m)) > 0
+}
+
+// Len количество проиндексированных сообщений
+func (ix *Index) Len() int {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return len(ix.docs)
+}
+
+// Save сохраняет снимок индекса и очищает журнал
+func (ix *Index) Save() error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	return ix.save()
+}
+
+// Close сохраняет снимок и закрывает журнал
+func (ix *Index) Close() error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	err := ix.save()
+	if ix.journal != nil {
+		err = errors.Join(err, ix.journal.Close())
+		ix.journal = nil
+	}
+	return err
+}
+
+func (ix *Index) addMessage(key DocKey, m *archive.Message) {
+	words := messageWords(m)
+	ix.add(key, stems(words), words)
+}
+
+// add добавляет основы terms и исходные слова words сообщения, words[i] соответствует terms[i]
+func (ix *Index) add(key DocKey, terms, words []string) {
+	if len(terms) == 0 {
+		return
+	}
+	ix.docs[key] = terms
+	ix.totalLen += len(terms)
+	for pos, term := range terms {
+		docs, ok := ix.postings[term]
+		if !ok {
+			docs = make(map[DocKey][]int)
+			ix.postings[term] = docs
+		}
+		docs[key] = append(docs[key], pos)
+	}
+	ix.words[key] = words
+	for _, word := range words {
+		docs, ok := ix.forms[word]
+		if !ok {
+			docs = make(map[DocKey]int)
+			ix.forms[word] = docs
+		}
+		docs[key]++
+	}
+}
+
+func (ix *Index) remove(key DocKey) {
+	terms, ok := ix.docs[key]
+	if !ok {
+		return
+	}
+	for _, term := range terms {
+		docs := ix.postings[term]
+		delete(docs, key)
+		if len(docs) == 0 {
+			delete(ix.postings, term)
+		}
+	}
+	ix.totalLen -= len(terms)
+	delete(ix.docs, key)
+	for _, word := range ix.words[key] {
+		docs := ix.forms[word]
+		delete(docs, key)
+		if len(docs) == 0 {
+			delete(ix.forms, word)
+		}
+	}
+	delete(ix.words, key)
+}
+
+// messageWords слова текста, подписи и имен файлов сообщения
+func messageWords(m *archive.Message) []string {
+	terms := words(m.Text)
+	for _, media := range m.Media {
+		if media.FileName != "" {
+			terms = append(terms, words(media.FileName)...)
+		}
+	}
+	return terms
+}
+
+func (ix *Index) snapshotPath() string {
+	return filepath.Join(ix.dir, "index.gob")
+}
+
+func (ix *Index) journalPath() string {
+	return filepath.Join(ix.dir, "journal")
+}
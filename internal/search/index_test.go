@@ -0,0 +1,228 @@
+package search
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"tg-archive-bot/internal/archive"
+)
+
+const testChat = -100
+
+type testIndex struct {
+	*Index
+	t     *testing.T
+	dir   string
+	store *archive.Store
+}
+
+func newTestIndex(t *testing.T) *testIndex {
+	t.Helper()
+	store, err := archive.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	ix := &testIndex{t: t, dir: filepath.Join(store.Dir(), "index"), store: store}
+	ix.Index = ix.open()
+	return ix
+}
+
+// open открывает еще один индекс в том же каталоге
+func (ix *testIndex) open() *Index {
+	ix.t.Helper()
+	index, err := Open(ix.dir, ix.store)
+	if err != nil {
+		ix.t.Fatal(err)
+	}
+	ix.t.Cleanup(func() { index.Close() })
+	return index
+}
+
+// put сохраняет сообщение в хранилище и индекс
+func (ix *testIndex) put(id int, text string) {
+	ix.t.Helper()
+	m := &archive.Message{ChatID: testChat, ID: id, Text: text}
+	if err := ix.store.PutMessage(m); err != nil {
+		ix.t.Fatal(err)
+	}
+	if err := ix.Update(m); err != nil {
+		ix.t.Fatal(err)
+	}
+}
+
+// search номера найденных сообщений в порядке релевантности
+func search(t *testing.T, index *Index, query string) []int {
+	t.Helper()
+	hits, err := index.Search(query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]int, len(hits))
+	for i, hit := range hits {
+		ids[i] = hit.MessageID
+	}
+	return ids
+}
+
+// expectHits проверяет найденные сообщения без учета порядка
+func expectHits(t *testing.T, index *Index, query string, want ...int) {
+	t.Helper()
+	got := search(t, index, query)
+	slices.Sort(got)
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Errorf("%s: messages %v, want %v", query, got, want)
+	}
+}
+
+func TestSearchStemming(t *testing.T) {
+	ix := newTestIndex(t)
+	ix.put(1, "Вышли новые релизы")
+	ix.put(2, "The releases were deployed")
+	ix.put(3, "Ёлка в офисе")
+
+	expectHits(t, ix.Index, "релиз", 1)
+	expectHits(t, ix.Index, "релизов", 1)
+	expectHits(t, ix.Index, "release", 2)
+	expectHits(t, ix.Index, "deploying releases", 2)
+	expectHits(t, ix.Index, "елка", 3)
+	expectHits(t, ix.Index, "релиз deploy")
+}
+
+func TestSearchPhrase(t *testing.T) {
+	ix := newTestIndex(t)
+	ix.put(1, "новый релиз готов")
+	ix.put(2, "релиз новый")
+	ix.put(3, "новый большой релиз")
+	ix.put(4, "вышли новые релизы")
+	ix.put(5, "новый. Релиз")
+
+	// знаки препинания между словами фразу не разрывают
+	expectHits(t, ix.Index, `"новый релиз"`, 1, 4, 5)
+	expectHits(t, ix.Index, "новый релиз", 1, 2, 3, 4, 5)
+	if _, err := ix.Search(`"новый релиз`, nil); err == nil {
+		t.Error("unclosed quote accepted")
+	}
+}
+
+// Префикс сравнивается с исходными словами: основа "servic" не начинается с "service"
+func TestSearchPrefix(t *testing.T) {
+	ix := newTestIndex(t)
+	ix.put(1, "restarted services")
+	ix.put(2, "упал сервер")
+	ix.put(3, "перезапуск серверами")
+	ix.put(4, "serverless")
+
+	expectHits(t, ix.Index, "service*", 1)
+	expectHits(t, ix.Index, "serv*", 1, 4)
+	expectHits(t, ix.Index, "серверам*", 3)
+	expectHits(t, ix.Index, "сервер*", 2, 3)
+	expectHits(t, ix.Index, "упал серв*", 2)
+}
+
+func TestSearchRanking(t *testing.T) {
+	ix := newTestIndex(t)
+	ix.put(1, "deploy finished with some unrelated words after it")
+	ix.put(2, "deploy deploy deploy")
+	ix.put(3, "deploy finished")
+	ix.put(4, "rollback finished")
+
+	// больше вхождений и короче сообщение выше
+	if got := search(t, ix.Index, "deploy"); !slices.Equal(got, []int{2, 3, 1}) {
+		t.Errorf("deploy: ranking %v, want [2 3 1]", got)
+	}
+	scores, err := ix.Match("finished")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deploy, err := ix.Match("deploy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rollback, err := ix.Match("rollback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// редкое слово весит больше частого в сообщениях одной длины
+	if rollback[DocKey{testChat, 4}] <= deploy[DocKey{testChat, 3}] {
+		t.Errorf("rare word score %f, common %f", rollback[DocKey{testChat, 4}], deploy[DocKey{testChat, 3}])
+	}
+	if scores[DocKey{testChat, 3}] != scores[DocKey{testChat, 4}] || scores[DocKey{testChat, 1}] >= scores[DocKey{testChat, 3}] {
+		t.Errorf("equal messages scored differently: %v", scores)
+	}
+
+	if _, err = ix.Search("...", nil); !errors.Is(err, ErrEmptyQuery) {
+		t.Errorf("query without words: %v, want ErrEmptyQuery", err)
+	}
+}
+
+func TestIndexUpdateDelete(t *testing.T) {
+	ix := newTestIndex(t)
+	ix.put(1, "первая версия")
+	ix.put(2, "первая строка")
+	ix.put(1, "исправленный текст")
+
+	expectHits(t, ix.Index, "первая", 2)
+	expectHits(t, ix.Index, "исправленный", 1)
+	expectHits(t, ix.Index, "верс*")
+
+	if err := ix.Delete(testChat, 2); err != nil {
+		t.Fatal(err)
+	}
+	expectHits(t, ix.Index, "первая")
+	if ix.Has(DocKey{testChat, 2}) || ix.Len() != 1 {
+		t.Fatalf("deleted message is still indexed: %v", ix.Keys())
+	}
+
+	// сообщение без слов в индекс не попадает
+	ix.put(3, "👍")
+	if ix.Has(DocKey{testChat, 3}) {
+		t.Fatal("message without words indexed")
+	}
+}
+
+// Снимок и журнал восстанавливают то же состояние, что и перестроение по хранилищу
+func TestIndexPersistence(t *testing.T) {
+	ix := newTestIndex(t)
+	ix.put(1, "новый релиз")
+	ix.put(2, "старый релиз")
+	ix.put(3, "релиз отменен")
+	if err := ix.Save(); err != nil {
+		t.Fatal(err)
+	}
+	// изменения после снимка есть только в журнале
+	ix.put(2, "старая сборка")
+	ix.put(4, "релиз перенесен")
+	if err := ix.store.DeleteMessage(testChat, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := ix.Delete(testChat, 3); err != nil {
+		t.Fatal(err)
+	}
+	want := []int{1, 4}
+	expectHits(t, ix.Index, "релиз*", want...)
+
+	// журнал читается, как после аварийного завершения без Close
+	replayed := ix.open()
+	expectHits(t, replayed, "релиз*", want...)
+	expectHits(t, replayed, "сборка", 2)
+
+	if err := replayed.Close(); err != nil {
+		t.Fatal(err)
+	}
+	expectHits(t, ix.open(), "релиз*", want...)
+
+	if err := os.RemoveAll(ix.dir); err != nil {
+		t.Fatal(err)
+	}
+	rebuilt := ix.open()
+	expectHits(t, rebuilt, "релиз*", want...)
+	expectHits(t, rebuilt, "сборка", 2)
+	if rebuilt.Len() != 3 {
+		t.Fatalf("rebuilt index has %d messages, want 3", rebuilt.Len())
+	}
+}
@@ -0,0 +1,159 @@
+package search
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"tg-archive-bot/internal/archive"
+)
+
+// snapshotVersion меняется при несовместимом изменении токенизации,
+// старый снимок в этом случае игнорируется и индекс перестраивается
+const snapshotVersion = 2
+
+type snapshot struct {
+	Version int
+	Docs    []snapshotDoc
+}
+
+type snapshotDoc struct {
+	Key   DocKey
+	Terms []string
+	Words []string
+}
+
+// load читает снимок и применяет к нему журнал изменений
+func (ix *Index) load() error {
+	// снимок содержит слова сообщений, поэтому в зашифрованном архиве тоже шифруется.
+	// Зашифрованный снимок отличается суффиксом имени
+	sealed := true
+	f, err := os.Open(ix.snapshotPath() + archive.SealedSuffix)
+	if errors.Is(err, os.ErrNotExist) {
+		sealed = false
+		f, err = os.Open(ix.snapshotPath())
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if sealed {
+		if r, err = ix.store.OpenSealed(f); err != nil {
+			return fmt.Errorf("decrypt search index: %w", err)
+		}
+	}
+	var snap snapshot
+	if err = gob.NewDecoder(r).Decode(&snap); err != nil {
+		if !sealed {
+			// прежние версии писали зашифрованный снимок под тем же именем, индекс перестраивается
+			return fmt.Errorf("decode search index: %w: %w", err, os.ErrNotExist)
+		}
+		return fmt.Errorf("decode search index: %w", err)
+	}
+	if snap.Version != snapshotVersion {
+		return fmt.Errorf("search index version %d: %w", snap.Version, os.ErrNotExist)
+	}
+	for _, doc := range snap.Docs {
+		ix.add(doc.Key, doc.Terms, doc.Words)
+	}
+
+	if err = ix.replayJournal(); err != nil {
+		return err
+	}
+	return ix.save()
+}
+
+// save атомарно пишет снимок и начинает новый журнал
+func (ix *Index) save() error {
+	snap := snapshot{Version: snapshotVersion, Docs: make([]snapshotDoc, 0, len(ix.docs))}
+	for key, terms := range ix.docs {
+		snap.Docs = append(snap.Docs, snapshotDoc{Key: key, Terms: terms, Words: ix.words[key]})
+	}
+
+	path, stale := ix.snapshotPath(), ix.snapshotPath()+archive.SealedSuffix
+	if ix.store.Sealed(archive.KeyIndex) {
+		path, stale = stale, path
+	}
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	sealed, err := ix.store.EncryptWriter(archive.KeyIndex, w)
+	if err == nil {
+		err = gob.NewEncoder(sealed).Encode(&snap)
+	}
+	if err == nil {
+		err = sealed.Close()
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err = errors.Join(err, f.Close()); err != nil {
+		return fmt.Errorf("write search index: %w", err)
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		return err
+	}
+	// снимок в другом виде остался от записи до включения или выключения шифрования
+	if err = os.Remove(stale); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if ix.journal != nil {
+		_ = ix.journal.Close()
+	}
+	ix.journal, err = os.Create(ix.journalPath())
+	return err
+}
+
+// writeJournal дописывает изменение в журнал, чтобы не потерять его до следующего снимка
+func (ix *Index) writeJournal(op byte, key DocKey) error {
+	if ix.journal == nil {
+		return nil
+	}
+	_, err := fmt.Fprintf(ix.journal, "%c %d %d\n", op, key.ChatID, key.MessageID)
+	return err
+}
+
+// replayJournal повторяет изменения из журнала, актуальные версии сообщений берутся из хранилища
+func (ix *Index) replayJournal() error {
+	f, err := os.Open(ix.journalPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var op byte
+		var key DocKey
+		if _, err = fmt.Sscanf(scanner.Text(), "%c %d %d", &op, &key.ChatID, &key.MessageID); err != nil {
+			// оборванная последняя строка после аварийного завершения
+			continue
+		}
+
+		ix.remove(key)
+		if op != 'u' {
+			continue
+		}
+		m, err := ix.store.GetMessage(key.ChatID, key.MessageID)
+		if errors.Is(err, archive.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		ix.addMessage(key, m)
+	}
+	return scanner.Err()
+}
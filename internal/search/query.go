@@ -0,0 +1,194 @@
+package search
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// ErrEmptyQuery в запросе нет ни одного слова
+var ErrEmptyQuery = errors.New("empty search query")
+
+// параметры ранжирования BM25
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// Hit найденное сообщение
+type Hit struct {
+	DocKey
+	Score float64
+}
+
+// clause условие запроса: слово, префикс (слово*) или фраза в кавычках.
+// Префикс хранится без стемминга и сравнивается с исходными словами
+type clause struct {
+	terms  []string
+	prefix bool
+}
+
+// parseQuery разбирает запрос вида: деплой "новый релиз" serv*
+func parseQuery(query string) ([]clause, error) {
+	var clauses []clause
+	rest := query
+	for {
+		rest = strings.TrimSpace(rest)
+		if rest == "" {
+			break
+		}
+
+		if rest[0] == '"' {
+			end := strings.IndexByte(rest[1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed quote at position %d", len(query)-len(rest)+1)
+			}
+			if terms := tokenize(rest[1 : end+1]); len(terms) > 0 {
+				clauses = append(clauses, clause{terms: terms})
+			}
+			rest = rest[end+2:]
+			continue
+		}
+
+		word := rest
+		if i := strings.IndexAny(rest, " \t\n\""); i >= 0 {
+			word, rest = rest[:i], rest[i:]
+		} else {
+			rest = ""
+		}
+
+		prefix := strings.HasSuffix(word, "*")
+		parts := words(strings.TrimSuffix(word, "*"))
+		for i, part := range parts {
+			if prefix && i == len(parts)-1 {
+				clauses = append(clauses, clause{terms: []string{part}, prefix: true})
+				continue
+			}
+			clauses = append(clauses, clause{terms: []string{stem(part)}})
+		}
+	}
+
+	if len(clauses) == 0 {
+		return nil, ErrEmptyQuery
+	}
+	return clauses, nil
+}
+
+// Search ищет сообщения, содержащие все условия запроса, и сортирует их по релевантности.
+// filter, если задан, отбрасывает неподходящие сообщения
+func (ix *Index) Search(query string, filter func(key DocKey) bool) ([]Hit, error) {
+	scores, err := ix.Match(query)
+	if err != nil {
+		return nil, err
+	}
+
+	hits := make([]Hit, 0, len(scores))
+	for key, score := range scores {
+		if filter != nil && !filter(key) {
+			continue
+		}
+		hits = append(hits, Hit{DocKey: key, Score: score})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		if hits[i].ChatID != hits[j].ChatID {
+			return hits[i].ChatID < hits[j].ChatID
+		}
+		return hits[i].MessageID > hits[j].MessageID
+	})
+	return hits, nil
+}
+
+// Match возвращает сообщения, содержащие все условия запроса, с оценкой релевантности BM25
+func (ix *Index) Match(query string) (map[DocKey]float64, error) {
+	clauses, err := parseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	if len(ix.docs) == 0 {
+		return nil, nil
+	}
+	total := float64(len(ix.docs))
+	avgLen := float64(ix.totalLen) / total
+
+	var scores map[DocKey]float64
+	for _, c := range clauses {
+		freqs := ix.match(c)
+
+		df := float64(len(freqs))
+		idf := math.Log(1 + (total-df+0.5)/(df+0.5))
+
+		next := make(map[DocKey]float64, len(freqs))
+		for key, tf := range freqs {
+			prev, ok := scores[key]
+			if scores != nil && !ok {
+				continue
+			}
+			docLen := float64(len(ix.docs[key]))
+			f := float64(tf)
+			next[key] = prev + idf*f*(bm25K1+1)/(f+bm25K1*(1-bm25B+bm25B*docLen/avgLen))
+		}
+		scores = next
+		if len(scores) == 0 {
+			return nil, nil
+		}
+	}
+	return scores, nil
+}
+
+// match возвращает количество вхождений условия в каждом подходящем сообщении
+func (ix *Index) match(c clause) map[DocKey]int {
+	freqs := make(map[DocKey]int)
+	switch {
+	case c.prefix:
+		for word, docs := range ix.forms {
+			if !strings.HasPrefix(word, c.terms[0]) {
+				continue
+			}
+			for key, n := range docs {
+				freqs[key] += n
+			}
+		}
+	case len(c.terms) == 1:
+		for key, positions := range ix.postings[c.terms[0]] {
+			freqs[key] = len(positions)
+		}
+	default:
+		for key, positions := range ix.postings[c.terms[0]] {
+			if n := ix.phraseCount(key, positions, c.terms[1:]); n > 0 {
+				freqs[key] = n
+			}
+		}
+	}
+	return freqs
+}
+
+// phraseCount количество позиций, с которых в сообщении идут все слова фразы подряд
+func (ix *Index) phraseCount(key DocKey, starts []int, rest []string) int {
+	terms := ix.docs[key]
+	count := 0
+	for _, start := range starts {
+		if start+len(rest) >= len(terms) {
+			continue
+		}
+		matched := true
+		for i, term := range rest {
+			if terms[start+1+i] != term {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			count++
+		}
+	}
+	return count
+}
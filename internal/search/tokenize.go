@@ -0,0 +1,70 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/kljensen/snowball/english"
+	"github.com/kljensen/snowball/russian"
+)
+
+// tokenize разбивает текст на нормализованные основы слов
+func tokenize(text string) []string {
+	return stems(words(text))
+}
+
+// words разбивает текст на нормализованные слова без стемминга
+func words(text string) []string {
+	fields := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, w := range fields {
+		fields[i] = normalize(w)
+	}
+	return fields
+}
+
+// stems основы нормализованных слов
+func stems(words []string) []string {
+	terms := make([]string, 0, len(words))
+	for _, w := range words {
+		terms = append(terms, stem(w))
+	}
+	return terms
+}
+
+// normalize приводит слово к нижнему регистру и заменяет ё на е
+func normalize(word string) string {
+	return strings.ReplaceAll(strings.ToLower(word), "ё", "е")
+}
+
+// stem выбирает стеммер по алфавиту слова, смешанные слова и числа не изменяются
+func stem(word string) string {
+	switch script(word) {
+	case unicode.Cyrillic:
+		return russian.Stem(word, true)
+	case unicode.Latin:
+		return english.Stem(word, true)
+	}
+	return word
+}
+
+func script(word string) *unicode.RangeTable {
+	var table *unicode.RangeTable
+	for _, r := range word {
+		var t *unicode.RangeTable
+		switch {
+		case unicode.Is(unicode.Cyrillic, r):
+			t = unicode.Cyrillic
+		case unicode.Is(unicode.Latin, r):
+			t = unicode.Latin
+		default:
+			return nil
+		}
+		if table != nil && table != t {
+			return nil
+		}
+		table = t
+	}
+	return table
+}
@@ -0,0 +1,50 @@
+package search
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestWords(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"Новый релиз, v2.1!", []string{"новый", "релиз", "v2", "1"}},
+		{"Ёлка и ЕЛКА", []string{"елка", "и", "елка"}},
+		{"deploy—rollback", []string{"deploy", "rollback"}},
+		{"  ...  ", []string{}},
+	}
+	for _, tt := range tests {
+		if got := words(tt.text); !slices.Equal(got, tt.want) {
+			t.Errorf("words(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+// Разные формы слова дают одну основу, стеммер выбирается по алфавиту
+func TestTokenizeStems(t *testing.T) {
+	tests := [][]string{
+		{"релиз", "релизы", "релизов", "Релизами"},
+		{"сервер", "серверы", "сервера"},
+		{"release", "releases", "released"},
+		{"deploy", "deploying", "deployed"},
+	}
+	for _, forms := range tests {
+		want := tokenize(forms[0])
+		for _, form := range forms[1:] {
+			if got := tokenize(form); !slices.Equal(got, want) {
+				t.Errorf("tokenize(%q) = %q, want %q", form, got, want)
+			}
+		}
+	}
+}
+
+// Слова из смешанных алфавитов и с цифрами не изменяются
+func TestTokenizeMixed(t *testing.T) {
+	for _, word := range []string{"iphone15", "релизv2", "2024"} {
+		if got := tokenize(word); !slices.Equal(got, []string{word}) {
+			t.Errorf("tokenize(%q) = %q", word, got)
+		}
+	}
+}
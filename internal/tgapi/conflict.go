@@ -0,0 +1,69 @@
+package tgapi
+
+import (
+	"net/http"
+	"path"
+	"sync"
+	"time"
+
+	"tg-archive-bot/internal/metrics"
+
+	ta "github.com/mymmrac/telego/telegoapi"
+	"go.uber.org/zap"
+)
+
+// границы паузы после конфликта long polling
+const (
+	conflictMinDelay = 5 * time.Second
+	conflictMaxDelay = time.Minute
+)
+
+var pollingConflicts = metrics.NewCounterVec("tgarchive_polling_conflicts_total",
+	"Ответы 409 на getUpdates: обновления этого бота получает другой процесс", "bot")
+
+// ConflictCaller обнаруживает ответ 409 Conflict на getUpdates, который означает, что
+// обновления бота забирает другой процесс или установлен webhook. Каждый конфликт пишется
+// в лог на уровне error, следующие попытки откладываются со все большей паузой
+type ConflictCaller struct {
+	caller ta.Caller
+	bot    string
+	log    *zap.Logger
+	// onConflict вызывается при каждом конфликте, может остановить бота
+	onConflict func(err *ta.Error)
+
+	mu    sync.Mutex
+	delay time.Duration
+}
+
+// NewConflictCaller оборачивает caller бота bot, onConflict может быть nil
+func NewConflictCaller(caller ta.Caller, bot string, logger *zap.Logger, onConflict func(err *ta.Error)) *ConflictCaller {
+	return &ConflictCaller{caller: caller, bot: bot, log: logger, onConflict: onConflict}
+}
+
+// Call выполняет вызов и обрабатывает конфликт getUpdates
+func (c *ConflictCaller) Call(url string, data *ta.RequestData) (*ta.Response, error) {
+	resp, err := c.caller.Call(url, data)
+	if path.Base(url) != methodGetUpdates || err != nil {
+		return resp, err
+	}
+	if resp.Ok || resp.Error == nil || resp.ErrorCode != http.StatusConflict {
+		c.mu.Lock()
+		c.delay = 0
+		c.mu.Unlock()
+		return resp, err
+	}
+
+	c.mu.Lock()
+	c.delay = min(max(c.delay*2, conflictMinDelay), conflictMaxDelay)
+	delay := c.delay
+	c.mu.Unlock()
+
+	pollingConflicts.Inc(c.bot)
+	c.log.Error("another process is polling updates for this bot, check for duplicate instances or a webhook",
+		zap.String("description", resp.Description), zap.Duration("backoff", delay))
+	if c.onConflict != nil {
+		c.onConflict(resp.Error)
+	}
+	time.Sleep(delay)
+	return resp, err
+}
@@ -0,0 +1,3 @@
+package tgapi
+
+// обертки над telegoapi.Caller: метрики вызовов Bot API, ограничение частоты и повторы
@@ -0,0 +1,93 @@
+package tgapi
+
+import (
+	"sync"
+	"time"
+)
+
+// bucket token bucket: rate токенов в секунду, не больше burst
+type bucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(rate, burst float64) *bucket {
+	return &bucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// reserve забирает токен и возвращает, сколько нужно подождать до его появления
+func (b *bucket) reserve(now time.Time) time.Duration {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// limiter общий лимит и лимиты отдельных чатов
+type limiter struct {
+	mu      sync.Mutex
+	global  *bucket
+	chats   map[int64]*bucket
+	cleaned time.Time
+
+	privateRate float64
+	groupRate   float64
+	chatBurst   float64
+}
+
+// setRates меняет скорость пополнения, лимиты чатов создаются заново с новой скоростью
+func (l *limiter) setRates(global, private, group float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.global == nil {
+		l.global = newBucket(global, global)
+	} else {
+		l.global.rate, l.global.burst = global, global
+	}
+	l.privateRate, l.groupRate = private, group
+	l.chats = make(map[int64]*bucket)
+}
+
+// chatIdle через сколько неиспользуемый лимит чата удаляется
+const chatIdle = 10 * time.Minute
+
+// wait ждет, пока вызов уложится в общий лимит и лимит чата chatID (0 — без чата)
+func (l *limiter) wait(chatID int64) time.Duration {
+	l.mu.Lock()
+	now := time.Now()
+	delay := l.global.reserve(now)
+	if chatID != 0 {
+		b, ok := l.chats[chatID]
+		if !ok {
+			rate := l.privateRate
+			if chatID < 0 {
+				rate = l.groupRate
+			}
+			b = newBucket(rate, l.chatBurst)
+			l.chats[chatID] = b
+		}
+		delay = max(delay, b.reserve(now))
+	}
+	if now.Sub(l.cleaned) > chatIdle {
+		for id, b := range l.chats {
+			if now.Sub(b.last) > chatIdle {
+				delete(l.chats, id)
+			}
+		}
+		l.cleaned = now
+	}
+	l.mu.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	return delay
+}
@@ -0,0 +1,66 @@
+package tgapi
+
+import (
+	"path"
+	"strconv"
+	"time"
+
+	"tg-archive-bot/internal/metrics"
+
+	ta "github.com/mymmrac/telego/telegoapi"
+)
+
+// методы, которые держат соединение до появления обновлений, их длительность не показательна
+const methodGetUpdates = "getUpdates"
+
+var (
+	apiCalls = metrics.NewCounterVec("tgarchive_api_calls_total",
+		"Вызовы Bot API по методу и результату: ok, код ошибки Telegram или error для сетевых ошибок",
+		"bot", "method", "result")
+	apiDuration = metrics.NewHistogramVec("tgarchive_api_call_duration_seconds",
+		"Длительность вызовов Bot API", metrics.DefBuckets, "bot", "method")
+	retryAfter = metrics.NewCounterVec("tgarchive_api_retry_after_seconds_total",
+		"Суммарное время ожидания, запрошенное Telegram в ответах 429 (retry_after)", "bot", "method")
+	pollingErrors = metrics.NewCounterVec("tgarchive_polling_errors_total",
+		"Ошибки получения обновлений через long polling", "bot")
+)
+
+// MetricsCaller считает вызовы Bot API, их длительность и ошибки
+type MetricsCaller struct {
+	Caller ta.Caller
+	// Bot значение метки bot
+	Bot string
+	// Polled вызывается после каждого успешного getUpdates, если задан
+	Polled func()
+}
+
+// Call вызывает метод через Caller и записывает метрики
+func (c *MetricsCaller) Call(url string, data *ta.RequestData) (*ta.Response, error) {
+	method := path.Base(url)
+	start := time.Now()
+	resp, err := c.Caller.Call(url, data)
+	if method != methodGetUpdates {
+		apiDuration.Since(start, c.Bot, method)
+	}
+
+	result := "ok"
+	switch {
+	case err != nil:
+		result = "error"
+	case !resp.Ok && resp.Error != nil:
+		result = strconv.Itoa(resp.ErrorCode)
+		if resp.Parameters != nil && resp.Parameters.RetryAfter > 0 {
+			retryAfter.Add(float64(resp.Parameters.RetryAfter), c.Bot, method)
+		}
+	}
+	apiCalls.Inc(c.Bot, method, result)
+	if method == methodGetUpdates {
+		switch {
+		case result != "ok":
+			pollingErrors.Inc(c.Bot)
+		case c.Polled != nil:
+			c.Polled()
+		}
+	}
+	return resp, err
+}
@@ -0,0 +1,252 @@
+package tgapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"tg-archive-bot/internal/metrics"
+
+	ta "github.com/mymmrac/telego/telegoapi"
+	"github.com/valyala/fasthttp"
+	"go.uber.org/zap"
+)
+
+var (
+	apiRetries = metrics.NewCounterVec("tgarchive_api_retries_total",
+		"Повторы вызовов Bot API по причине: flood (429), server (5xx и сетевые ошибки), migrate", "bot", "method", "reason")
+	rateLimitWait = metrics.NewHistogramVec("tgarchive_api_rate_limit_wait_seconds",
+		"Ожидание перед вызовом Bot API из-за собственных ограничений частоты", metrics.DefBuckets, "bot", "method")
+)
+
+// ErrRetriesExhausted вызов не удался после всех повторов
+var ErrRetriesExhausted = errors.New("telegram api: retries exhausted")
+
+// Limits ограничения ResilientCaller, нулевые значения заменяются значениями по умолчанию
+type Limits struct {
+	// GlobalRate вызовов в секунду для всего бота
+	GlobalRate float64
+	// PrivateRate и GroupRate сообщений в секунду в один чат
+	PrivateRate float64
+	GroupRate   float64
+	// MaxAttempts попыток одного вызова
+	MaxAttempts int
+	// MaxRetryAfter самое долгое ожидание по retry_after, дольше ответ 429 возвращается как есть
+	MaxRetryAfter time.Duration
+	// BaseDelay и MaxDelay границы паузы между повторами при ошибках сервера
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// DefaultLimits ограничения Telegram для рассылок: 30 сообщений в секунду,
+// одно сообщение в секунду в личный чат и 20 в минуту в группу
+var DefaultLimits = Limits{
+	GlobalRate:    30,
+	PrivateRate:   1,
+	GroupRate:     20.0 / 60,
+	MaxAttempts:   5,
+	MaxRetryAfter: 5 * time.Minute,
+	BaseDelay:     500 * time.Millisecond,
+	MaxDelay:      30 * time.Second,
+}
+
+// Transport HTTP клиент под ResilientCaller. Повторы выполняет только ResilientCaller:
+// fasthttp сам повторяет POST, если сервер закрыл соединение до ответа, и сообщение уходит дважды
+var Transport ta.Caller = &ta.FastHTTPCaller{Client: &fasthttp.Client{MaxIdemponentCallAttempts: 1}}
+
+// ResilientCaller ограничивает частоту вызовов, ждет retry_after при ответе 429,
+// повторяет вызовы при ошибках сервера и сети с паузой и переходит на новый chat_id
+// после преобразования группы в супергруппу. Отправка сообщений после ошибки сервера
+// или обрыва соединения не повторяется: сообщение могло уже уйти, и повтор его продублирует
+type ResilientCaller struct {
+	caller  ta.Caller
+	bot     string
+	limits  atomic.Pointer[Limits]
+	limiter *limiter
+	log     *zap.Logger
+}
+
+// NewResilientCaller оборачивает caller бота bot
+func NewResilientCaller(caller ta.Caller, bot string, limits Limits, logger *zap.Logger) *ResilientCaller {
+	c := &ResilientCaller{
+		caller: caller,
+		bot:    bot,
+		limiter: &limiter{
+			chats:     make(map[int64]*bucket),
+			chatBurst: 3,
+		},
+		log: logger,
+	}
+	c.SetLimits(limits)
+	return c
+}
+
+// SetLimits заменяет ограничения, уже накопленные ожидания сохраняются
+func (c *ResilientCaller) SetLimits(limits Limits) {
+	if limits.GlobalRate <= 0 {
+		limits.GlobalRate = DefaultLimits.GlobalRate
+	}
+	if limits.PrivateRate <= 0 {
+		limits.PrivateRate = DefaultLimits.PrivateRate
+	}
+	if limits.GroupRate <= 0 {
+		limits.GroupRate = DefaultLimits.GroupRate
+	}
+	if limits.MaxAttempts <= 0 {
+		limits.MaxAttempts = DefaultLimits.MaxAttempts
+	}
+	if limits.MaxRetryAfter <= 0 {
+		limits.MaxRetryAfter = DefaultLimits.MaxRetryAfter
+	}
+	if limits.BaseDelay <= 0 {
+		limits.BaseDelay = DefaultLimits.BaseDelay
+	}
+	if limits.MaxDelay <= 0 {
+		limits.MaxDelay = DefaultLimits.MaxDelay
+	}
+	c.limits.Store(&limits)
+	c.limiter.setRates(limits.GlobalRate, limits.PrivateRate, limits.GroupRate)
+}
+
+// Call выполняет вызов с ограничением частоты и повторами
+func (c *ResilientCaller) Call(url string, data *ta.RequestData) (*ta.Response, error) {
+	limits := c.limits.Load()
+	method := path.Base(url)
+	// тело читается при каждой попытке, поэтому попытки получают копию
+	body := bytes.Clone(data.Buffer.Bytes())
+	chatID, hasChat := requestChatID(data.ContentType, body)
+
+	if method != methodGetUpdates {
+		var limitChat int64
+		if hasChat && isSendMethod(method) {
+			limitChat = chatID
+		}
+		if waited := c.limiter.wait(limitChat); waited > 0 {
+			rateLimitWait.Observe(waited.Seconds(), c.bot, method)
+		}
+	}
+
+	var lastErr error
+	for attempt := 1; attempt <= limits.MaxAttempts; attempt++ {
+		resp, err := c.caller.Call(url, &ta.RequestData{ContentType: data.ContentType, Buffer: bytes.NewBuffer(body)})
+		if attempt == limits.MaxAttempts {
+			if err != nil {
+				return nil, errors.Join(err, ErrRetriesExhausted)
+			}
+			return resp, nil
+		}
+
+		switch {
+		case err != nil && isSendMethod(method) && !notSent(err):
+			return nil, err
+
+		case err != nil:
+			// сервер вернул 5xx или запрос не дошел
+			lastErr = err
+			delay := backoff(limits, attempt)
+			apiRetries.Inc(c.bot, method, "server")
+			c.log.Warn("api call failed, retrying", zap.String("method", method), zap.Int("attempt", attempt),
+				zap.Duration("delay", delay), zap.Error(err))
+			time.Sleep(delay)
+
+		case !resp.Ok && resp.Error != nil && resp.Parameters != nil && resp.Parameters.RetryAfter > 0:
+			wait := time.Duration(resp.Parameters.RetryAfter) * time.Second
+			if wait > limits.MaxRetryAfter {
+				return resp, nil
+			}
+			apiRetries.Inc(c.bot, method, "flood")
+			c.log.Warn("flood limit, waiting", zap.String("method", method), zap.Duration("retry_after", wait))
+			time.Sleep(wait)
+
+		case !resp.Ok && resp.Error != nil && resp.Parameters != nil && resp.Parameters.MigrateToChatID != 0:
+			newChatID := resp.Parameters.MigrateToChatID
+			if !hasChat {
+				return resp, nil
+			}
+			migrated, ok := replaceChatID(data.ContentType, body, newChatID)
+			if !ok {
+				return resp, nil
+			}
+			apiRetries.Inc(c.bot, method, "migrate")
+			c.log.Info("chat migrated to supergroup", zap.Int64("chat_id", chatID), zap.Int64("new_chat_id", newChatID))
+			body, chatID = migrated, newChatID
+
+		case !resp.Ok && resp.Error != nil && resp.ErrorCode >= http.StatusInternalServerError && !isSendMethod(method):
+			delay := backoff(limits, attempt)
+			apiRetries.Inc(c.bot, method, "server")
+			time.Sleep(delay)
+
+		default:
+			return resp, nil
+		}
+	}
+	return nil, errors.Join(lastErr, ErrRetriesExhausted)
+}
+
+// backoff экспоненциальная пауза со случайным разбросом от половины до полной величины
+func backoff(limits *Limits, attempt int) time.Duration {
+	delay := limits.BaseDelay << (attempt - 1)
+	if delay > limits.MaxDelay || delay <= 0 {
+		delay = limits.MaxDelay
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// notSent ошибка возникла до отправки запроса: соединение не установлено
+func notSent(err error) bool {
+	var dialErr *fasthttp.ErrDialWithUpstream
+	var dnsErr *net.DNSError
+	return errors.As(err, &dialErr) || errors.As(err, &dnsErr) || errors.Is(err, fasthttp.ErrNoFreeConns)
+}
+
+// isSendMethod методы, отправляющие сообщения в чат, на них действует лимит чата.
+// Они не идемпотентны, поэтому повторяются только когда сообщение точно не отправлено
+func isSendMethod(method string) bool {
+	for _, prefix := range []string{"send", "copyMessage", "forwardMessage"} {
+		if strings.HasPrefix(method, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestChatID chat_id из JSON запроса. Запросы с файлами (multipart) не разбираются
+func requestChatID(contentType string, body []byte) (int64, bool) {
+	if contentType != ta.ContentTypeJSON {
+		return 0, false
+	}
+	var req struct {
+		ChatID json.RawMessage `json:"chat_id"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil || len(req.ChatID) == 0 {
+		return 0, false
+	}
+	// chat_id может быть числом или @username
+	id, err := strconv.ParseInt(string(req.ChatID), 10, 64)
+	return id, err == nil
+}
+
+// replaceChatID тело JSON запроса с новым chat_id
+func replaceChatID(contentType string, body []byte, chatID int64) ([]byte, bool) {
+	if contentType != ta.ContentTypeJSON {
+		return nil, false
+	}
+	var req map[string]json.RawMessage
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, false
+	}
+	req["chat_id"] = json.RawMessage(strconv.FormatInt(chatID, 10))
+	migrated, err := json.Marshal(req)
+	if err != nil {
+		return nil, false
+	}
+	return migrated, true
+}
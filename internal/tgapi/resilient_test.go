@@ -0,0 +1,269 @@
+package tgapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	ta "github.com/mymmrac/telego/telegoapi"
+	"go.uber.org/zap"
+)
+
+// fakeAPI сервер Bot API, отвечающий по очереди заготовленными ответами
+type fakeAPI struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	responses []func(w http.ResponseWriter)
+	bodies    []map[string]any
+}
+
+func newFakeAPI(t *testing.T, responses ...func(w http.ResponseWriter)) *fakeAPI {
+	t.Helper()
+	api := &fakeAPI{responses: responses}
+	api.Server = httptest.NewServer(http.HandlerFunc(api.serve))
+	t.Cleanup(api.Close)
+	return api
+}
+
+func (a *fakeAPI) serve(w http.ResponseWriter, r *http.Request) {
+	data, _ := io.ReadAll(r.Body)
+	var body map[string]any
+	_ = json.Unmarshal(data, &body)
+
+	a.mu.Lock()
+	a.bodies = append(a.bodies, body)
+	respond := ok
+	if len(a.responses) > 0 {
+		respond, a.responses = a.responses[0], a.responses[1:]
+	}
+	a.mu.Unlock()
+	respond(w)
+}
+
+// calls тела полученных запросов
+func (a *fakeAPI) calls() []map[string]any {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]map[string]any(nil), a.bodies...)
+}
+
+func ok(w http.ResponseWriter) {
+	_, _ = io.WriteString(w, `{"ok":true,"result":true}`)
+}
+
+func status(code int) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) { w.WriteHeader(code) }
+}
+
+func apiError(code int, parameters string) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		_, _ = io.WriteString(w, `{"ok":false,"error_code":`+strconv.Itoa(code)+`,"description":"error","parameters":`+parameters+`}`)
+	}
+}
+
+// fastLimits ограничения без заметных пауз между повторами
+var fastLimits = Limits{
+	GlobalRate:  1000,
+	PrivateRate: 1000,
+	GroupRate:   1000,
+	MaxAttempts: 3,
+	BaseDelay:   time.Millisecond,
+	MaxDelay:    5 * time.Millisecond,
+}
+
+func call(t *testing.T, c *ResilientCaller, url, method string, body any) (*ta.Response, error) {
+	t.Helper()
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c.Call(url+"/bottoken/"+method, &ta.RequestData{ContentType: ta.ContentTypeJSON, Buffer: bytes.NewBuffer(data)})
+}
+
+func TestRetryAfter(t *testing.T) {
+	api := newFakeAPI(t, apiError(http.StatusTooManyRequests, `{"retry_after":1}`), ok)
+	c := NewResilientCaller(Transport, "test", fastLimits, zap.NewNop())
+
+	start := time.Now()
+	resp, err := call(t, c, api.URL, "sendMessage", map[string]any{"chat_id": 1, "text": "hi"})
+	if err != nil || !resp.Ok {
+		t.Fatalf("call: resp %+v, err %v", resp, err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("waited %s, want at least retry_after 1s", elapsed)
+	}
+	if n := len(api.calls()); n != 2 {
+		t.Errorf("got %d calls, want 2", n)
+	}
+}
+
+func TestRetryAfterTooLong(t *testing.T) {
+	api := newFakeAPI(t, apiError(http.StatusTooManyRequests, `{"retry_after":3600}`))
+	limits := fastLimits
+	limits.MaxRetryAfter = time.Minute
+	c := NewResilientCaller(Transport, "test", limits, zap.NewNop())
+
+	resp, err := call(t, c, api.URL, "sendMessage", map[string]any{"chat_id": 1, "text": "hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Ok || resp.ErrorCode != http.StatusTooManyRequests {
+		t.Errorf("got %+v, want 429 returned as is", resp)
+	}
+	if n := len(api.calls()); n != 1 {
+		t.Errorf("got %d calls, want 1", n)
+	}
+}
+
+func TestServerErrorBackoff(t *testing.T) {
+	api := newFakeAPI(t, status(http.StatusBadGateway), status(http.StatusServiceUnavailable), ok)
+	c := NewResilientCaller(Transport, "test", fastLimits, zap.NewNop())
+
+	resp, err := call(t, c, api.URL, "getChat", map[string]any{"chat_id": 1})
+	if err != nil || !resp.Ok {
+		t.Fatalf("call: resp %+v, err %v", resp, err)
+	}
+	if n := len(api.calls()); n != 3 {
+		t.Errorf("got %d calls, want 3", n)
+	}
+}
+
+func TestServerErrorExhausted(t *testing.T) {
+	api := newFakeAPI(t, status(http.StatusBadGateway), status(http.StatusBadGateway), status(http.StatusBadGateway))
+	c := NewResilientCaller(Transport, "test", fastLimits, zap.NewNop())
+
+	_, err := call(t, c, api.URL, "getChat", map[string]any{"chat_id": 1})
+	if !errors.Is(err, ErrRetriesExhausted) {
+		t.Fatalf("got %v, want ErrRetriesExhausted", err)
+	}
+	if n := len(api.calls()); n != fastLimits.MaxAttempts {
+		t.Errorf("got %d calls, want %d", n, fastLimits.MaxAttempts)
+	}
+}
+
+// Сообщение могло уйти до ошибки сервера, повтор его продублирует
+func TestSendNotRetriedAfterServerError(t *testing.T) {
+	for _, method := range []string{"sendMessage", "forwardMessage", "copyMessage"} {
+		t.Run(method, func(t *testing.T) {
+			api := newFakeAPI(t, status(http.StatusBadGateway), ok)
+			c := NewResilientCaller(Transport, "test", fastLimits, zap.NewNop())
+
+			if _, err := call(t, c, api.URL, method, map[string]any{"chat_id": 1}); err == nil {
+				t.Fatal("got nil error, want server error")
+			}
+			if n := len(api.calls()); n != 1 {
+				t.Errorf("got %d calls, want 1", n)
+			}
+		})
+	}
+}
+
+func TestSendNotRetriedAfterConnectionReset(t *testing.T) {
+	api := newFakeAPI(t, func(w http.ResponseWriter) {
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			_ = conn.Close()
+		}
+	}, ok)
+	c := NewResilientCaller(Transport, "test", fastLimits, zap.NewNop())
+
+	if _, err := call(t, c, api.URL, "sendMessage", map[string]any{"chat_id": 1, "text": "hi"}); err == nil {
+		t.Fatal("got nil error, want connection error")
+	}
+	if n := len(api.calls()); n != 1 {
+		t.Errorf("got %d calls, want 1", n)
+	}
+}
+
+// countingCaller считает попытки, которые не доходят до сервера
+type countingCaller struct {
+	ta.Caller
+	calls int
+}
+
+func (c *countingCaller) Call(url string, data *ta.RequestData) (*ta.Response, error) {
+	c.calls++
+	return c.Caller.Call(url, data)
+}
+
+func TestSendRetriedWhenNotSent(t *testing.T) {
+	// адрес, на котором никто не слушает
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	_ = listener.Close()
+
+	counting := &countingCaller{Caller: Transport}
+	c := NewResilientCaller(counting, "test", fastLimits, zap.NewNop())
+
+	_, err = call(t, c, "http://"+addr, "sendMessage", map[string]any{"chat_id": 1, "text": "hi"})
+	if !errors.Is(err, ErrRetriesExhausted) {
+		t.Fatalf("got %v, want ErrRetriesExhausted", err)
+	}
+	if counting.calls != fastLimits.MaxAttempts {
+		t.Errorf("got %d attempts, want %d", counting.calls, fastLimits.MaxAttempts)
+	}
+}
+
+func TestMigrateToChatID(t *testing.T) {
+	api := newFakeAPI(t, apiError(http.StatusBadRequest, `{"migrate_to_chat_id":-1001234}`), ok)
+	c := NewResilientCaller(Transport, "test", fastLimits, zap.NewNop())
+
+	resp, err := call(t, c, api.URL, "sendMessage", map[string]any{"chat_id": -42, "text": "hi"})
+	if err != nil || !resp.Ok {
+		t.Fatalf("call: resp %+v, err %v", resp, err)
+	}
+	calls := api.calls()
+	if len(calls) != 2 {
+		t.Fatalf("got %d calls, want 2", len(calls))
+	}
+	if got := calls[1]["chat_id"]; got != float64(-1001234) {
+		t.Errorf("retried with chat_id %v, want -1001234", got)
+	}
+	if got := calls[1]["text"]; got != "hi" {
+		t.Errorf("retried with text %v, want hi", got)
+	}
+}
+
+func TestChatLimit(t *testing.T) {
+	api := newFakeAPI(t)
+	limits := fastLimits
+	limits.PrivateRate = 10
+	c := NewResilientCaller(Transport, "test", limits, zap.NewNop())
+
+	// первые сообщения укладываются в запас, дальше одно в 100 мс
+	start := time.Now()
+	for range 5 {
+		if _, err := call(t, c, api.URL, "sendMessage", map[string]any{"chat_id": 1, "text": "hi"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("5 messages to one chat took %s, want at least 150ms", elapsed)
+	}
+
+	// другой чат и вызовы без отправки сообщений лимит чата не задерживает
+	start = time.Now()
+	for range 3 {
+		if _, err := call(t, c, api.URL, "sendMessage", map[string]any{"chat_id": 2, "text": "hi"}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := call(t, c, api.URL, "getChat", map[string]any{"chat_id": 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 80*time.Millisecond {
+		t.Errorf("calls outside the chat limit took %s", elapsed)
+	}
+}